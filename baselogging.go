@@ -3,14 +3,13 @@ package greenery
 import (
 	"fmt"
 	"io"
-	"os"
 	"strings"
 	"time"
 )
 
 // Super basic logging to have a default for the library, will simply output
-// everything to stderr and trace to stdout as-is, no support for structured
-// logging.
+// everything to the error writer, or the log file, and trace to the output
+// writer as-is, no support for structured logging.
 const (
 	debugLevel = iota
 	infoLevel
@@ -154,9 +153,10 @@ func logValueFromString(e string) uint8 {
 	panic(fmt.Sprintf("Invalid log level %s, only error, warn, info and debug are supported", e))
 }
 
-// BaseTraceLogger represents a base logger used for Tracing
+// BaseTraceLogger represents a base logger used for Tracing, writing to the
+// output writer of the configuration
 func BaseTraceLogger(cfg Config) Logger {
-	return &baseLogger{w: os.Stdout, level: debugLevel, prefix: "TRACE: "}
+	return &baseLogger{w: cfg.Out(), level: debugLevel, prefix: "TRACE: "}
 }
 
 // BasePrettyLogger represents a base logger used for pretty logging
@@ -9,6 +9,7 @@ import (
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -29,11 +30,70 @@ type additionalStruct struct {
 	Env     string
 }
 
+// viperBindings records the flags, environment variables and defaults bound
+// to the viper keys while binding, so that a new viper instance can be set up
+// the same way when the configuration is reloaded.
+type viperBindings struct {
+	v        *viper.Viper
+	flags    map[string]*pflag.Flag
+	envs     map[string]string
+	defaults map[string]interface{}
+}
+
+// newViperBindings returns the bindings recorder for the passed viper
+func newViperBindings(v *viper.Viper) *viperBindings {
+	return &viperBindings{
+		v:        v,
+		flags:    map[string]*pflag.Flag{},
+		envs:     map[string]string{},
+		defaults: map[string]interface{}{},
+	}
+}
+
+// BindPFlag binds the passed flag to the passed key
+func (b *viperBindings) BindPFlag(key string, f *pflag.Flag) error {
+	b.flags[key] = f
+	return b.v.BindPFlag(key, f)
+}
+
+// BindEnv binds the passed environment variable to the passed key
+func (b *viperBindings) BindEnv(key, env string) error {
+	b.envs[key] = env
+	return b.v.BindEnv(key, env)
+}
+
+// SetDefault sets the default value of the passed key
+func (b *viperBindings) SetDefault(key string, value interface{}) {
+	b.defaults[key] = value
+	b.v.SetDefault(key, value)
+}
+
+// newViper returns a new viper instance, using the passed filesystem, with
+// all the recorded bindings.
+func (b *viperBindings) newViper(fs afero.Fs) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetFs(fs)
+	for key, f := range b.flags {
+		if err := v.BindPFlag(key, f); err != nil {
+			return nil, err
+		}
+	}
+	for key, env := range b.envs {
+		if err := v.BindEnv(key, env); err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range b.defaults {
+		v.SetDefault(key, value)
+	}
+	return v, nil
+}
+
 // createBindings executes the binding and sets up the environmental
 // variables, as well as viper defaults as specified in the configuration
 // definition tags.
 func createBindings(tracer func(int, string, ...interface{}), cfg interface{},
-	vp *viper.Viper, p map[string]*cobra.Command, env, docs map[string]string,
+	vp *viperBindings, p map[string]*cobra.Command, env, docs map[string]string,
 	appname string, seenFields map[string]bool) ([]additionalStruct, error) {
 
 	additional := []additionalStruct{}
@@ -46,10 +106,14 @@ func createBindings(tracer func(int, string, ...interface{}), cfg interface{},
 	t := tp.Elem()
 	v := reflect.ValueOf(cfg).Elem()
 
+	fields, err := structFields(t)
+	if err != nil {
+		return nil, err
+	}
+
 	// We don't want users to be able to have fields in their structs with our
 	// same names, exported or unexported.
-	for i := 0; i < v.NumField(); i++ {
-		x := t.Field(i)
+	for _, x := range fields {
 
 		if seenFields[x.Name] {
 			return nil, fmt.Errorf("Field collision on field %s, user configurations cannot shadow base configuration fields", x.Name)
@@ -68,7 +132,14 @@ func createBindings(tracer func(int, string, ...interface{}), cfg interface{},
 			continue
 		}
 
-		field := v.FieldByName(x.Name)
+		// Map instances are only in the configuration file, and are loaded
+		// separately
+		if isMapCfg(x) {
+			tracer(1, "Map %s in section %s, skipping", x.Name, mapSection(x))
+			continue
+		}
+
+		field := v.FieldByIndex(x.Index)
 
 		cobra, vipername, viperenv, err := parseTags(x)
 		if err != nil {
@@ -84,6 +155,10 @@ func createBindings(tracer func(int, string, ...interface{}), cfg interface{},
 				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
 				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 				ok = true
+			case reflect.Map:
+				ok = mapSupported(field.Type())
+			case reflect.Slice:
+				ok = sliceSupported(field.Type())
 			case reflect.Ptr:
 				if field.Type().Implements(flagInterface) {
 					ok = true
@@ -146,12 +221,9 @@ func createBindings(tracer func(int, string, ...interface{}), cfg interface{},
 							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 							ok = true
 						case reflect.Slice:
-							switch field.Type().Elem().Kind() {
-							case reflect.Int:
-								ok = true
-							case reflect.String:
-								ok = true
-							}
+							ok = sliceSupported(field.Type())
+						case reflect.Map:
+							ok = mapSupported(field.Type())
 						case reflect.Struct:
 							if reflect.PtrTo(field.Type()).Implements(unmarshalInterface) && reflect.PtrTo(field.Type()).Implements(marshalInterface) {
 								ok = true
@@ -208,7 +280,7 @@ func createBindings(tracer func(int, string, ...interface{}), cfg interface{},
 			}
 
 			if err := doBind(tracer, vp, cmd, field, vipername, viperenv, x.Name,
-				ccobra[1], ccobra[2], env, docs, appname); err != nil {
+				ccobra[1], ccobra[2], env, docs, appname, isSecret(x), sliceOptions(x)); err != nil {
 				return nil, err
 			}
 
@@ -229,9 +301,9 @@ func createBindings(tracer func(int, string, ...interface{}), cfg interface{},
 
 // doBind binds the specified variable, separating to make createBindings not
 // as super long
-func doBind(tracer func(int, string, ...interface{}), v *viper.Viper, cmd *cobra.Command,
+func doBind(tracer func(int, string, ...interface{}), v *viperBindings, cmd *cobra.Command,
 	field reflect.Value, vipername, viperenv, varname, name, short string,
-	env, docs map[string]string, appname string) (err error) {
+	env, docs map[string]string, appname string, secret bool, lopts listOptions) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			// Cobra/viper panic, let's catch it and override any existing error
@@ -248,9 +320,9 @@ func doBind(tracer func(int, string, ...interface{}), v *viper.Viper, cmd *cobra
 	// Check if we have a flag value or a base type. Also save the stringer
 	// method for later since we might need it for the viper default value.
 	setter, stringer := getSetterStringer(field)
-	doc, ok := docs[varname]
+	doc, ok := fieldDoc(docs, varname)
 	if !ok {
-		err = fmt.Errorf("No documentation for command line parameter %s (variable %s)", name, varname)
+		err = fmt.Errorf("%w: No documentation for command line parameter %s (variable %s)", ErrMissingDocs, name, varname)
 		return
 	}
 
@@ -334,6 +406,20 @@ func doBind(tracer func(int, string, ...interface{}), v *viper.Viper, cmd *cobra
 			tracer(1, "Will create an uint64 flag for %s", varname)
 			cmd.PersistentFlags().Uint64VarP(field.Addr().Interface().(*uint64),
 				name, short, field.Interface().(uint64), doc)
+		case reflect.Map:
+			if !mapSupported(field.Type()) {
+				return fmt.Errorf("Cannot create a flag for %s/%v, unsupported type",
+					varname, field.Type())
+			}
+			tracer(1, "Will create a map flag for %s", varname)
+			cmd.PersistentFlags().VarP(&mapValue{field: field, name: name}, name, short, doc)
+		case reflect.Slice:
+			if !sliceSupported(field.Type()) {
+				return fmt.Errorf("Cannot create a flag for %s/%v, unsupported type",
+					varname, field.Kind())
+			}
+			tracer(1, "Will create a slice flag for %s, csv: %v append: %v", varname, lopts.csv, lopts.append)
+			cmd.PersistentFlags().VarP(&sliceValue{field: field, name: name, csv: lopts.csv}, name, short, doc)
 		default:
 			return fmt.Errorf("Cannot create a flag for %s/%v, unsupported type",
 				varname, field.Kind())
@@ -356,7 +442,11 @@ func doBind(tracer func(int, string, ...interface{}), v *viper.Viper, cmd *cobra
 				name, varname)
 		}
 
-		if err = v.BindPFlag(vipername, f); err != nil {
+		// Values appended to the configuration file list are added when
+		// loading, viper has to return the list without them
+		if lopts.append {
+			tracer(1, "Not binding %s, its values are appended", name)
+		} else if err = v.BindPFlag(vipername, f); err != nil {
 			// Should not happen
 			return
 		}
@@ -364,11 +454,17 @@ func doBind(tracer func(int, string, ...interface{}), v *viper.Viper, cmd *cobra
 		if stringer.Kind() != reflect.Invalid {
 			rv := stringer.Call([]reflect.Value{})[0].String()
 			v.SetDefault(vipername, rv)
-			tracer(1, "Setting default for %s to %s", vipername, rv)
+			tracer(1, "Setting default for %s to %s", vipername, maskValue(secret, rv))
+		} else if field.Kind() == reflect.Map {
+			// Set as a string, as the keys of a map default would be
+			// considered configuration variables themselves
+			v.SetDefault(vipername, mapString(field))
+			tracer(1, "Setting default for %s to %s", vipername,
+				maskValue(secret, mapString(field)))
 		} else {
 			v.SetDefault(vipername, field.Interface())
 			tracer(1, "Setting default for %s to %v", vipername,
-				field.Interface())
+				maskValue(secret, field.Interface()))
 		}
 	} else {
 		tracer(1, "No viper binding %s %s", viperenv, vipername)
@@ -392,6 +488,299 @@ func doBind(tracer func(int, string, ...interface{}), v *viper.Viper, cmd *cobra
 	return nil
 }
 
+// tagOptions returns the options in the optional fourth part of the tag of
+// the passed field, separated by spaces.
+func tagOptions(x reflect.StructField) []string {
+	tags := strings.Split(x.Tag.Get("greenery"), sepTag)
+	if len(tags) != 4 {
+		return nil
+	}
+	return strings.Fields(tags[3])
+}
+
+// isSecret returns whether the field is marked as secret in its tag, in which
+// case its value should not be displayed or logged.
+func isSecret(x reflect.StructField) bool {
+	for _, opt := range tagOptions(x) {
+		if opt == tagSecret {
+			return true
+		}
+	}
+	return false
+}
+
+// listOptions are the tag options of slice fields, see tagCSV, tagAppend and
+// tagSep.
+type listOptions struct {
+	csv    bool
+	append bool
+	sep    rune
+}
+
+// sliceOptions returns the slice options in the tag of the passed field.
+func sliceOptions(x reflect.StructField) listOptions {
+	lopts := listOptions{sep: defaultSep}
+	for _, opt := range tagOptions(x) {
+		switch {
+		case opt == tagCSV:
+			lopts.csv = true
+		case opt == tagAppend:
+			lopts.append = true
+		case strings.HasPrefix(opt, tagSep):
+			lopts.sep, _ = utf8.DecodeRuneInString(strings.TrimPrefix(opt, tagSep))
+		}
+	}
+	return lopts
+}
+
+// validSep returns whether the passed separator can be used for environment
+// lists, it has to be a single character that cannot be confused with the
+// CSV quoting.
+func validSep(sep string) bool {
+	r, size := utf8.DecodeRuneInString(sep)
+	return size > 0 && size == len(sep) && r != utf8.RuneError &&
+		r != '"' && r != '\r' && r != '\n'
+}
+
+// maskValue returns the passed value, or a placeholder if it is secret.
+func maskValue(secret bool, v interface{}) interface{} {
+	if secret {
+		return maskedValue
+	}
+	return v
+}
+
+// isNestedCfg returns whether the passed field is a nested configuration
+// struct, whose fields are configuration variables themselves, rather than a
+// variable. The tag of a nested struct contains prefixes rather than a
+// command line specification.
+func isNestedCfg(x reflect.StructField) bool {
+	tag, ok := x.Tag.Lookup("greenery")
+	if !ok || x.Type.Kind() != reflect.Struct ||
+		strings.Contains(strings.Split(tag, sepTag)[0], sepCmdParts) {
+		return false
+	}
+
+	r, _ := utf8.DecodeRuneInString(x.Name)
+	if !unicode.IsUpper(r) {
+		return false
+	}
+
+	pt := reflect.PtrTo(x.Type)
+	return !pt.Implements(flagInterface) && !pt.Implements(unmarshalInterface)
+}
+
+// isMapCfg returns whether the passed field is a map of named instances of a
+// configuration struct, each loaded from a subsection of the section in its
+// tag. As for nested structs the tag does not contain a command line
+// specification.
+func isMapCfg(x reflect.StructField) bool {
+	tag, ok := x.Tag.Lookup("greenery")
+	if !ok || x.Type.Kind() != reflect.Map || x.Type.Key().Kind() != reflect.String ||
+		x.Type.Elem().Kind() != reflect.Struct {
+		return false
+	}
+
+	return !strings.Contains(strings.Split(tag, sepTag)[0], sepCmdParts)
+}
+
+// mapSection returns the section containing the instances of the passed map
+// configuration field.
+func mapSection(x reflect.StructField) string {
+	tags := strings.Split(x.Tag.Get("greenery"), sepTag)
+	if len(tags) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(tags[1])
+}
+
+// checkMapCfg verifies the tag of the passed map configuration field, as well
+// as the tags of the fields of its instances. Instances can only be set in
+// the configuration file.
+func checkMapCfg(x reflect.StructField) error {
+	tag := x.Tag.Get("greenery")
+	tags := strings.Split(tag, sepTag)
+	if len(tags) != 3 {
+		return fmt.Errorf("Invalid tag for map %s, found %d parts instead of 3 in %s", x.Name, len(tags), tag)
+	}
+
+	if strings.TrimSpace(tags[0]) != "" || strings.TrimSpace(tags[2]) != "" {
+		return fmt.Errorf("Invalid tag for map %s, its instances can be set only in the configuration file", x.Name)
+	}
+
+	section := strings.TrimSpace(tags[1])
+	for _, part := range strings.Split(section, sepKeyParts) {
+		if part == "" {
+			return fmt.Errorf("Invalid tag for map %s, empty section name in '%s'", x.Name, section)
+		}
+	}
+
+	fields, err := structFields(x.Type.Elem())
+	if err != nil {
+		return err
+	}
+
+	for _, ix := range fields {
+		if r, _ := utf8.DecodeRuneInString(ix.Name); !unicode.IsUpper(r) {
+			continue
+		}
+		if _, _, _, err := parseTags(ix); err != nil {
+			return fmt.Errorf("Invalid instance field in map %s: %w", x.Name, err)
+		}
+
+		// Instances are created from their zero value
+		if ix.Type.Kind() == reflect.Ptr {
+			return fmt.Errorf("Invalid instance field in map %s, %s cannot be a pointer", x.Name, ix.Name)
+		}
+	}
+	return nil
+}
+
+// instanceFields returns the fields of the passed instance of a map
+// configuration field, named after the map and the instance, like
+// Backends.primary.URL, and with their configuration file name in the
+// subsection of the instance. Fields that are not in the configuration file
+// are not returned.
+func instanceFields(x reflect.StructField, name string) []reflect.StructField {
+	fields, _ := structFields(x.Type.Elem())
+	section := mapSection(x) + sepKeyParts + name
+
+	ifields := []reflect.StructField{}
+	for _, ix := range fields {
+		cobra, vipername, _, err := parseTags(ix)
+		if err != nil || vipername == "" || strings.HasSuffix(cobra, sepCmdParts+"custom") {
+			continue
+		}
+
+		ix.Name = x.Name + sepKeyParts + name + sepKeyParts + ix.Name
+		ix.Tag = prefixTag(ix.Tag, "", section, "")
+		ifields = append(ifields, ix)
+	}
+	return ifields
+}
+
+// structFields returns the fields of the passed struct type, where any nested
+// configuration struct is replaced by its exported fields. These are named
+// after their path, like DB.Host, their index is relative to the passed type
+// and their tag has the prefixes from the tag of the nested struct applied.
+func structFields(t reflect.Type) ([]reflect.StructField, error) {
+	fields := []reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		x := t.Field(i)
+		if isMapCfg(x) {
+			if err := checkMapCfg(x); err != nil {
+				return nil, err
+			}
+		}
+
+		if !isNestedCfg(x) {
+			fields = append(fields, x)
+			continue
+		}
+
+		prefixes := strings.Split(x.Tag.Get("greenery"), sepTag)
+		if len(prefixes) != 3 {
+			return nil, fmt.Errorf("Invalid tag for nested struct %s, found %d parts instead of 3 in %s", x.Name, len(prefixes), x.Tag.Get("greenery"))
+		}
+		for j := range prefixes {
+			prefixes[j] = strings.TrimSpace(prefixes[j])
+		}
+		if strings.Contains(prefixes[0], sepMultipleCmds) {
+			return nil, fmt.Errorf("Invalid tag for nested struct %s, the flag prefix '%s' cannot contain %s", x.Name, prefixes[0], sepMultipleCmds)
+		}
+		if prefixes[1] != "" {
+			for _, part := range strings.Split(prefixes[1], sepKeyParts) {
+				if part == "" {
+					return nil, fmt.Errorf("Invalid tag for nested struct %s, empty section name in '%s'", x.Name, prefixes[1])
+				}
+			}
+		}
+
+		nested, err := structFields(x.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, nx := range nested {
+			r, _ := utf8.DecodeRuneInString(nx.Name)
+			if !unicode.IsUpper(r) || nx.Type == basePType {
+				continue
+			}
+
+			nx.Name = x.Name + "." + nx.Name
+			nx.Index = append([]int{i}, nx.Index...)
+			nx.Tag = prefixTag(nx.Tag, prefixes[0], prefixes[1], prefixes[2])
+			fields = append(fields, nx)
+		}
+	}
+
+	return fields, nil
+}
+
+// prefixTag returns the passed greenery tag with the flag, section and
+// environment prefixes applied to the respective names, if present.
+func prefixTag(tag reflect.StructTag, flagPrefix, section, envPrefix string) reflect.StructTag {
+	tags := strings.Split(tag.Get("greenery"), sepTag)
+	if len(tags) < 3 {
+		// Malformed, will be reported by parseTags
+		return tag
+	}
+
+	if flagPrefix != "" {
+		cmds := strings.Split(tags[0], sepMultipleCmds)
+		for i, cc := range cmds {
+			ccobra := strings.Split(cc, sepCmdParts)
+			if len(ccobra) == 3 && strings.TrimSpace(ccobra[1]) != "" {
+				ccobra[1] = flagPrefix + "-" + strings.TrimSpace(ccobra[1])
+				cmds[i] = strings.Join(ccobra, sepCmdParts)
+			}
+		}
+		tags[0] = strings.Join(cmds, sepMultipleCmds)
+	}
+
+	if vipername := strings.TrimSpace(tags[1]); vipername != "" && section != "" {
+		tags[1] = section + sepKeyParts + strings.TrimLeft(vipername, sepKeyParts)
+	}
+
+	if viperenv := strings.TrimSpace(tags[2]); viperenv != "" && envPrefix != "" {
+		tags[2] = envPrefix + "_" + viperenv
+	}
+
+	return reflect.StructTag(fmt.Sprintf("greenery:%q", strings.Join(tags, sepTag)))
+}
+
+// fieldByName returns the field with the passed name in the passed struct
+// type, looking also at the fields of any nested configuration struct.
+func fieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+	if strings.Contains(name, ".") {
+		if fields, err := structFields(t); err == nil {
+			for _, x := range fields {
+				if x.Name == name {
+					return x, true
+				}
+			}
+		}
+		return reflect.StructField{}, false
+	}
+
+	return t.FieldByName(name)
+}
+
+// fieldDoc returns the documentation for the passed field, fields of nested
+// configuration structs can be documented either by their path, like
+// DB.Host, or simply by their name.
+func fieldDoc(docs map[string]string, name string) (string, bool) {
+	if d, ok := docs[name]; ok {
+		return d, true
+	}
+
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		d, ok := docs[name[i+1:]]
+		return d, ok
+	}
+	return "", false
+}
+
 // parseTags returns the various parts of our tag
 func parseTags(x reflect.StructField) (string, string, string, error) {
 	name := x.Name
@@ -402,7 +791,26 @@ func parseTags(x reflect.StructField) (string, string, string, error) {
 	}
 
 	tags := strings.Split(tag, sepTag)
-	if len(tags) != 3 {
+	if len(tags) == 4 {
+		opts := strings.Fields(tags[3])
+		if len(opts) == 0 {
+			return "", "", "", fmt.Errorf("Invalid tag for %s, unknown option '' in %s", name, tag)
+		}
+		for _, opt := range opts {
+			switch {
+			case opt == tagSecret:
+			case opt == tagCSV || opt == tagAppend || strings.HasPrefix(opt, tagSep):
+				if !sliceSupported(x.Type) {
+					return "", "", "", fmt.Errorf("Invalid tag for %s, option '%s' is only valid for slices in %s", name, opt, tag)
+				}
+				if strings.HasPrefix(opt, tagSep) && !validSep(strings.TrimPrefix(opt, tagSep)) {
+					return "", "", "", fmt.Errorf("Invalid tag for %s, the separator in '%s' must be a single character other than a quote in %s", name, opt, tag)
+				}
+			default:
+				return "", "", "", fmt.Errorf("Invalid tag for %s, unknown option '%s' in %s", name, opt, tag)
+			}
+		}
+	} else if len(tags) != 3 {
 		return "", "", "", fmt.Errorf("Invalid tag for %s, found %d parts instead of 3 in %s", name, len(tags), tag)
 	}
 
@@ -416,8 +824,11 @@ func parseTags(x reflect.StructField) (string, string, string, error) {
 			}
 		}
 
-		if strings.Count(vipername, sepKeyParts) > 1 {
-			return "", "", "", fmt.Errorf("Invalid config file tag for '%s', more than two %s present in '%s%s'", name, sepKeyParts, sepKeyParts, vipername)
+		// Nested sections are allowed, but all the names must be present
+		for _, part := range strings.Split(vipername, sepKeyParts) {
+			if part == "" {
+				return "", "", "", fmt.Errorf("Invalid config file tag for '%s', empty section or variable name in '%s'", name, vipername)
+			}
 		}
 	}
 
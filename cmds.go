@@ -1,12 +1,19 @@
 package greenery
 
 import (
+	"bytes"
+	"encoding"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // getHelpCmd creates a help command with a custom runner
@@ -82,16 +89,25 @@ func configInitCmdRunner(icfg Config, args []string) error {
 	}
 
 	if len(args) != 0 {
-		return fmt.Errorf("The command does not support additional arguments")
+		return usageErrorf("The command does not support additional arguments")
 	}
 
-	used, err := initCfgFile(icfg, cfg)
+	var in io.Reader
+	if cfg.CfgInteractive {
+		var terminal bool
+		if in, terminal = stdinTerminal(); !terminal {
+			cfg.Trace("The standard input is not a terminal, not prompting")
+			in = nil
+		}
+	}
+
+	used, err := initCfgFile(icfg, cfg, in)
 	cfg.s_usedConf = used
 
 	if err == nil {
 		// Allow users to quiet this for use in scripts
 		if cfg.Verbosity.Value != 0 {
-			fmt.Printf("Configuration file generated at %s\n", used)
+			fmt.Fprintf(cfg.Out(), "Configuration file generated at %s\n", used)
 		}
 	}
 	return err
@@ -106,32 +122,38 @@ func configEnvCmdRunner(icfg Config, args []string) error {
 	}
 
 	if len(args) != 0 {
-		return fmt.Errorf("The command does not support additional arguments")
+		return usageErrorf("The command does not support additional arguments")
+	}
+
+	if cfg.CfgExport.Value != "" {
+		fmt.Fprint(cfg.Out(), cfg.exportEnv(icfg, cfg.CfgExport.Value, cfg.CfgAll))
+		return nil
 	}
 
 	_, docs := cfg.GetDocs()
 
-	fmt.Printf("%s\n-------------------------------------------------------------------", docs.ConfigEnvMsg1)
+	fmt.Fprintf(cfg.Out(), "%s\n-------------------------------------------------------------------", docs.ConfigEnvMsg1)
 
 	out := []string{}
 	any := false
+	secrets := cfg.secretEnv()
 	for k, v := range cfg.s_env {
 		// Note that viper uses getenv, not lookupenv, so empty env variables
 		// count the same as unset env variables.
 		if v != "" {
-			out = append(out, fmt.Sprintf("\n  %s -> %s", k, v))
+			out = append(out, fmt.Sprintf("\n  %s -> %s", k, maskValue(secrets[k], v)))
 			any = true
 		}
 	}
 
 	if !any {
-		fmt.Printf("\n  %s", docs.ConfigEnvMsg2)
+		fmt.Fprintf(cfg.Out(), "\n  %s", docs.ConfigEnvMsg2)
 	} else {
 		sort.Strings(out)
-		fmt.Print(strings.Join(out, ""))
+		fmt.Fprint(cfg.Out(), strings.Join(out, ""))
 	}
 
-	fmt.Printf(`
+	fmt.Fprintf(cfg.Out(), `
 -------------------------------------------------------------------
 
 
@@ -145,22 +167,14 @@ func configEnvCmdRunner(icfg Config, args []string) error {
 	apHelper := func(t []string, x reflect.StructField) []string {
 		_, _, viperenv, _ := parseTags(x)
 		if viperenv != "" {
-			ds, ok := cfg.s_docs.ConfigFile[x.Name]
-			if ds == "" || !ok {
-				ds, ok = cfg.s_docs.CmdLine[x.Name]
-				if !ok {
-					// Should not happen due to previous checks
-					cfg.Errorf("Could not find any documentation, cmdline or configfile, for %s", x.Name)
-				}
-			}
-			t = append(t, fmt.Sprintf("%s_%s: %s\n", cfg.s_ucAppName, viperenv, ds))
+			t = append(t, fmt.Sprintf("%s_%s: %s\n", cfg.s_ucAppName, viperenv, cfg.envDoc(x)))
 		}
 
 		return t
 	}
 
-	for i := 0; i < t.NumField(); i++ {
-		x := t.Field(i)
+	fields, _ := structFields(t)
+	for _, x := range fields {
 		if x.Type == basePType {
 			cfg.Trace("Get values for our base struct")
 			for i2 := 0; i2 < baseType.NumField(); i2++ {
@@ -173,15 +187,152 @@ func configEnvCmdRunner(icfg Config, args []string) error {
 	}
 
 	sort.Strings(out)
-	fmt.Printf("%s-------------------------------------------------------------------\n", strings.Join(out, ""))
+	fmt.Fprintf(cfg.Out(), "%s-------------------------------------------------------------------\n", strings.Join(out, ""))
 
 	return nil
 }
 
+// envDoc returns the documentation for the environment variable of the
+// passed field, the configuration file documentation is preferred if present.
+func (cfg *BaseConfig) envDoc(x reflect.StructField) string {
+	ds, ok := fieldDoc(cfg.s_docs.ConfigFile, x.Name)
+	if ds == "" || !ok {
+		ds, ok = fieldDoc(cfg.s_docs.CmdLine, x.Name)
+		if !ok {
+			// Should not happen due to previous checks
+			cfg.Errorf("Could not find any documentation, cmdline or configfile, for %s", x.Name)
+		}
+	}
+	return ds
+}
+
+// fieldString returns the string representation of the value of the passed
+// field, as it would be set via the environment.
+func fieldString(x reflect.StructField, field reflect.Value) string {
+	switch field.Kind() {
+	case reflect.Map:
+		return mapString(field)
+	case reflect.Slice:
+		return listString(field, sliceOptions(x).sep)
+	}
+
+	if _, stringer := getSetterStringer(field); stringer.Kind() != reflect.Invalid {
+		return stringer.Call([]reflect.Value{})[0].String()
+	}
+
+	if m, ok := field.Interface().(encoding.TextMarshaler); ok {
+		if b, err := m.MarshalText(); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// shellExport returns the statement setting the passed environment variable
+// in the passed shell, quoting the value as needed.
+func shellExport(shell, name, value string) string {
+	switch shell {
+	case "fish":
+		value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+		return "set -x " + name + " '" + value + "'"
+	case "powershell":
+		return "$env:" + name + " = '" + strings.Replace(value, "'", "''", -1) + "'"
+	default:
+		return "export " + name + "='" + strings.Replace(value, "'", `'\''`, -1) + "'"
+	}
+}
+
+// validateShell is the validator for the --export flag, accepting either no
+// value or one of the supported shells.
+func validateShell(name, s string, data interface{}) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	return validateEnum(name, s, data)
+}
+
+// exportEnv returns a script setting the environment variables of the
+// application in the passed shell, preceded by their documentation. Unless
+// all is set only the variables currently set are included, otherwise every
+// variable is, set to the current value of the configuration. Secret values
+// are masked and commented out.
+func (cfg *BaseConfig) exportEnv(icfg Config, shell string, all bool) string {
+	out := map[string]string{}
+
+	helper := func(x reflect.StructField, v reflect.Value) {
+		_, _, viperenv, _ := parseTags(x)
+		if viperenv == "" {
+			return
+		}
+
+		name := cfg.s_ucAppName + "_" + viperenv
+		if _, ok := out[name]; ok {
+			return
+		}
+
+		// Note that viper uses getenv, not lookupenv, so empty env variables
+		// count the same as unset env variables.
+		value := os.Getenv(name)
+		if value == "" {
+			if !all {
+				return
+			}
+			value = fieldString(x, v.FieldByIndex(x.Index))
+		}
+
+		line := shellExport(shell, name, value)
+		if isSecret(x) {
+			line = "# " + shellExport(shell, name, maskedValue)
+		}
+		out[name] = commentify(cfg.envDoc(x), "# ") + line + "\n"
+	}
+
+	t := reflect.TypeOf(icfg).Elem()
+	v := reflect.ValueOf(icfg).Elem()
+	fields, _ := structFields(t)
+	for _, x := range fields {
+		if x.Type == basePType {
+			for i2 := 0; i2 < baseType.NumField(); i2++ {
+				helper(baseType.Field(i2), v.FieldByIndex(x.Index).Elem())
+			}
+		} else {
+			helper(x, v)
+		}
+	}
+
+	names := make([]string, 0, len(out))
+	for name := range out {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var script string
+	for _, name := range names {
+		script += out[name]
+	}
+	return script
+}
+
 // configDisplayCmdRunner is the runner for the config display command
 func configDisplayCmdRunner(icfg Config, args []string) error {
 	if len(args) != 0 {
-		return fmt.Errorf("The command does not support additional arguments")
+		return usageErrorf("The command does not support additional arguments")
+	}
+
+	cfg, err := getCfg(icfg)
+	if err != nil {
+		// Should never happen given the interface
+		return err
+	}
+
+	if cfg.CfgOutput.Value != "text" {
+		var out string
+		if out, err = cfgContents(icfg, cfg.CfgOutput.Value, false); err != nil {
+			return err
+		}
+
+		fmt.Fprint(cfg.Out(), out)
+		return nil
 	}
 
 	out, err := icfg.Dump(icfg)
@@ -190,7 +341,386 @@ func configDisplayCmdRunner(icfg Config, args []string) error {
 		return err
 	}
 
-	fmt.Println(out)
+	fmt.Fprintln(cfg.Out(), out)
+	return nil
+}
+
+// configProfilesCmdRunner is the runner for the config profiles command
+func configProfilesCmdRunner(icfg Config, args []string) error {
+	cfg, err := getCfg(icfg)
+	if err != nil {
+		// Should never happen given the interface
+		return err
+	}
+
+	if len(args) != 0 {
+		return usageErrorf("The command does not support additional arguments")
+	}
+
+	for _, p := range cfg.s_profiles {
+		if p == cfg.s_profile {
+			fmt.Fprintf(cfg.Out(), "* %s\n", p)
+		} else {
+			fmt.Fprintf(cfg.Out(), "  %s\n", p)
+		}
+	}
+	return nil
+}
+
+// configGetCmdRunner is the runner for the config get command
+func configGetCmdRunner(icfg Config, args []string) error {
+	cfg, err := getCfg(icfg)
+	if err != nil {
+		// Should never happen given the interface
+		return err
+	}
+
+	if len(args) != 1 {
+		return usageErrorf("The command requires the name of the configuration variable, as section.key")
+	}
+
+	fields, _, _, err := cfg.keyFields(icfg, args[0])
+	if err != nil {
+		return err
+	}
+
+	f := fields[0]
+	fmt.Fprintln(cfg.Out(), maskValue(isSecret(f.x) && !cfg.CfgReveal, fieldString(f.x, f.v.FieldByIndex(f.x.Index))))
+	return nil
+}
+
+// configSetCmdRunner is the runner for the config set command
+func configSetCmdRunner(icfg Config, args []string) error {
+	cfg, err := getCfg(icfg)
+	if err != nil {
+		// Should never happen given the interface
+		return err
+	}
+
+	if len(args) != 2 {
+		return usageErrorf("The command requires the name of the configuration variable, as section.key, and its value")
+	}
+
+	fields, parent, child, err := cfg.keyFields(icfg, args[0])
+	if err != nil {
+		return err
+	}
+
+	name, err := cfg.editedCfgFile()
+	if err != nil {
+		return err
+	}
+
+	// Validate the value the same way it would be when loaded, the value
+	// written is then the one the fields were set to.
+	for _, f := range fields {
+		if err = cfg.setString(icfg, f.x.Name, args[1]); err != nil {
+			return fmt.Errorf("Invalid value for %s: %w", args[0], &FieldError{Field: f.x.Name, Source: SourceCmdline, Err: err})
+		}
+	}
+
+	_, docs := cfg.GetDocs()
+	line, err := paramHelper(icfg, docs.ConfigFile, docs.CmdLine, fields[0].v, fields[0].x)
+	if err != nil {
+		return err
+	}
+
+	value := lineValue(line, cfgFormat(name))
+	return cfg.editCfgFile(name, parent, child, &value)
+}
+
+// configUnsetCmdRunner is the runner for the config unset command
+func configUnsetCmdRunner(icfg Config, args []string) error {
+	cfg, err := getCfg(icfg)
+	if err != nil {
+		// Should never happen given the interface
+		return err
+	}
+
+	if len(args) != 1 {
+		return usageErrorf("The command requires the name of the configuration variable, as section.key")
+	}
+
+	_, parent, child, err := cfg.keyFields(icfg, args[0])
+	if err != nil {
+		return err
+	}
+
+	name, err := cfg.editedCfgFile()
+	if err != nil {
+		return err
+	}
+
+	return cfg.editCfgFile(name, parent, child, nil)
+}
+
+// loadOtherCfgFile returns a copy of the default configuration with the
+// values in the passed configuration file applied, using the same profile as
+// the current configuration. The environment and command line are not taken
+// into account.
+func (cfg *BaseConfig) loadOtherCfgFile(name string) (Config, error) {
+	ocfg, obase := copyConfig(cfg.s_defaults)
+	noEnv := obase.NoEnv
+	obase.Profile = cfg.s_profile
+	obase.NoEnv = true
+
+	vp := viper.New()
+	vp.SetFs(cfg.s_fs)
+	if err := obase.readCfgFiles(vp, []string{name}); err != nil {
+		return nil, err
+	}
+	obase.NoEnv = noEnv
+
+	viperKeys := map[string]bool{}
+	t := reflect.TypeOf(ocfg).Elem()
+	v := reflect.ValueOf(ocfg).Elem()
+	fields, err := structFields(t)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range fields {
+		if x.Type == basePType {
+			for i2 := 0; i2 < baseType.NumField(); i2++ {
+				if err := loadHelper(ocfg, vp, viperKeys, baseType.Field(i2), v.FieldByIndex(x.Index).Elem()); err != nil {
+					return nil, err
+				}
+			}
+		} else if err := loadHelper(ocfg, vp, viperKeys, x, v); err != nil {
+			return nil, err
+		}
+	}
+	return ocfg, nil
+}
+
+// cfgDiff returns the configuration file variables that differ between the
+// passed configurations, together with their documentation. Variables only
+// present in one of them, like the ones of map instances, are reported as
+// removed or added after the changed ones. Secret values are masked.
+func cfgDiff(from, to Config) (string, error) {
+	fl, err := cfgLines(from, true)
+	if err != nil {
+		return "", err
+	}
+
+	tl, err := cfgLines(to, true)
+	if err != nil {
+		return "", err
+	}
+
+	lineKey := func(v *cfgLine) string {
+		if v.parent != "" {
+			return v.parent + sepKeyParts + v.child
+		}
+		return v.child
+	}
+
+	lineText := func(prefix string, v *cfgLine) string {
+		value := v.value
+		if v.secret {
+			value = strconv.Quote(maskedValue)
+		}
+		return prefix + lineKey(v) + " = " + value + "\n"
+	}
+
+	fromLines := map[string]*cfgLine{}
+	for _, v := range fl {
+		fromLines[lineKey(v)] = v
+	}
+	toLines := map[string]*cfgLine{}
+	for _, v := range tl {
+		toLines[lineKey(v)] = v
+	}
+
+	var out string
+	for _, v := range fl {
+		if v.skipvalue {
+			continue
+		}
+
+		tv, ok := toLines[lineKey(v)]
+		if ok && v.value == tv.value {
+			continue
+		}
+
+		out += commentify(v.doc, "# ") + lineText("-", v)
+		if ok {
+			out += lineText("+", tv)
+		}
+	}
+
+	for _, v := range tl {
+		if _, ok := fromLines[lineKey(v)]; ok || v.skipvalue {
+			continue
+		}
+		out += commentify(v.doc, "# ") + lineText("+", v)
+	}
+	return out, nil
+}
+
+// configDiffCmdRunner is the runner for the config diff command
+func configDiffCmdRunner(icfg Config, args []string) error {
+	cfg, err := getCfg(icfg)
+	if err != nil {
+		// Should never happen given the interface
+		return err
+	}
+
+	if len(args) > 1 {
+		return usageErrorf("The command supports at most one configuration file to compare against")
+	}
+
+	if cfg.CfgDefaults && len(args) == 0 {
+		return usageErrorf("The --defaults parameter requires the configuration file to compare")
+	}
+
+	from, to := cfg.s_defaults, icfg
+	if len(args) == 1 {
+		if !cfg.CfgDefaults {
+			from = icfg
+		}
+		if to, err = cfg.loadOtherCfgFile(args[0]); err != nil {
+			return err
+		}
+	}
+
+	out, err := cfgDiff(from, to)
+	if err != nil || out == "" {
+		return err
+	}
+
+	fmt.Fprint(cfg.Out(), out)
+	if cfg.CfgDefaults {
+		return fmt.Errorf("The configuration file %s differs from the defaults", args[0])
+	}
+	if len(args) == 1 {
+		return fmt.Errorf("The configuration differs from %s", args[0])
+	}
+	return fmt.Errorf("The configuration differs from the defaults")
+}
+
+// configUpgradeCmdRunner is the runner for the config upgrade command
+func configUpgradeCmdRunner(icfg Config, args []string) error {
+	cfg, err := getCfg(icfg)
+	if err != nil {
+		// Should never happen given the interface
+		return err
+	}
+
+	if len(args) != 0 {
+		return usageErrorf("The command does not support additional arguments")
+	}
+
+	name, err := cfg.editedCfgFile()
+	if err != nil {
+		return err
+	}
+
+	fi, err := cfg.s_fs.Stat(name)
+	if err != nil {
+		return fmt.Errorf("Cannot access the config file %s: %w", name, err)
+	}
+
+	old, upgraded, err := cfg.upgradeCfgFile(name)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(old, upgraded) {
+		if cfg.Verbosity.Value != 0 {
+			fmt.Fprintf(cfg.Out(), "Configuration file %s is already up to date\n", name)
+		}
+		return nil
+	}
+
+	for _, l := range lineDiff(strings.Split(strings.TrimSuffix(string(old), "\n"), "\n"),
+		strings.Split(strings.TrimSuffix(string(upgraded), "\n"), "\n")) {
+		fmt.Fprintln(cfg.Out(), l)
+	}
+
+	if cfg.CfgDryRun {
+		return nil
+	}
+
+	backup, err := cfg.backupName(name)
+	if err != nil {
+		return err
+	}
+
+	cfg.Tracef("Saving the previous config file as %s", backup)
+	if err = afero.WriteFile(cfg.s_fs, backup, old, fi.Mode()); err != nil {
+		return fmt.Errorf("Cannot write the backup config file %s: %w", backup, err)
+	}
+
+	if err = afero.WriteFile(cfg.s_fs, name, upgraded, fi.Mode()); err != nil {
+		return fmt.Errorf("Cannot write the config file %s: %w", name, err)
+	}
+
+	// Allow users to quiet this for use in scripts
+	if cfg.Verbosity.Value != 0 {
+		fmt.Fprintf(cfg.Out(), "Configuration file %s upgraded, the previous version was saved as %s\n", name, backup)
+	}
+	return nil
+}
+
+// backupName returns the name the backup of the passed config file should be
+// saved as, the file name followed by .bak and, when that is already taken,
+// by the first free number, so that previous backups are never overwritten.
+func (cfg *BaseConfig) backupName(name string) (string, error) {
+	backup := name + ".bak"
+	for i := 1; ; i++ {
+		if _, err := cfg.s_fs.Stat(backup); err != nil {
+			if os.IsNotExist(err) {
+				return backup, nil
+			}
+			return "", fmt.Errorf("Cannot access the backup config file %s: %w", backup, err)
+		}
+		backup = fmt.Sprintf("%s.bak.%d", name, i)
+	}
+}
+
+// configValidateCmdRunner is the runner for the config validate command
+func configValidateCmdRunner(icfg Config, args []string) error {
+	cfg, err := getCfg(icfg)
+	if err != nil {
+		// Should never happen given the interface
+		return err
+	}
+
+	if len(args) > 1 {
+		return usageErrorf("The command supports at most one configuration file to validate")
+	}
+
+	// Fragments are only loaded for the configuration files found, not for
+	// one passed explicitly.
+	top, fragments := args, false
+	if len(top) == 0 {
+		if top = cfg.validatedCfgFiles(); len(top) == 0 {
+			return fmt.Errorf("No configuration file was found to validate")
+		}
+		fragments = true
+	}
+
+	var problems []cfgProblem
+	var files []string
+	done := map[string]bool{}
+	for _, f := range top {
+		p, validated := cfg.validateCfgFiles(f, fragments, nil, done)
+		problems = append(problems, p...)
+		files = append(files, validated...)
+	}
+
+	for _, p := range problems {
+		fmt.Fprintln(cfg.Out(), p)
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("Found %d problem(s) in the configuration", len(problems))
+	}
+
+	// Allow users to quiet this for use in scripts
+	if cfg.Verbosity.Value != 0 {
+		fmt.Fprintf(cfg.Out(), "Configuration file %s is valid\n", strings.Join(files, ", "))
+	}
 	return nil
 }
 
@@ -203,20 +733,20 @@ func versionCmdRunner(icfg Config, args []string) error {
 	}
 
 	if len(args) != 0 {
-		return fmt.Errorf("The command does not support additional arguments")
+		return usageErrorf("The command does not support additional arguments")
 	}
 
 	if cfg.VersionFull != "" {
-		fmt.Println(cfg.VersionFull)
+		fmt.Fprintln(cfg.Out(), cfg.VersionFull)
 	} else {
 		if cfg.VersionPatchlevel == "" {
 			if cfg.VersionMinor != "" {
-				fmt.Printf("%s.%s\n", cfg.VersionMajor, cfg.VersionMinor)
+				fmt.Fprintf(cfg.Out(), "%s.%s\n", cfg.VersionMajor, cfg.VersionMinor)
 			} else {
-				fmt.Printf("%s\n", cfg.VersionMajor)
+				fmt.Fprintf(cfg.Out(), "%s\n", cfg.VersionMajor)
 			}
 		} else {
-			fmt.Printf("%s.%s.%s\n", cfg.VersionMajor, cfg.VersionMinor, cfg.VersionPatchlevel)
+			fmt.Fprintf(cfg.Out(), "%s.%s.%s\n", cfg.VersionMajor, cfg.VersionMinor, cfg.VersionPatchlevel)
 		}
 	}
 	return nil
@@ -1,12 +1,14 @@
 package greenery
 
 import (
+	"encoding/csv"
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/spf13/cast"
 	"github.com/spf13/viper"
 )
@@ -18,7 +20,7 @@ import (
 
 // withErr formats an error message with the common error leading text.
 func withErr(vipername string, err error) error {
-	return errors.WithMessage(err, fmt.Sprintf("Cannot convert flag value %s", vipername))
+	return fmt.Errorf("Cannot convert flag value %s: %w", vipername, err)
 }
 
 // checkIntOverflow checks if the specified int would overflow an 8/16/32 int,
@@ -146,6 +148,30 @@ func getIntSlice(vipername string, v interface{}) ([]int, error) {
 	return val, err
 }
 
+func getFloat64Slice(vipername string, v interface{}) ([]float64, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, withErr(vipername, fmt.Errorf("unable to cast %#v of type %T to []float64", v, v))
+	}
+
+	val := make([]float64, rv.Len())
+	for i := range val {
+		var err error
+		if val[i], err = getFloat64(vipername, rv.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return val, nil
+}
+
+func getDurationSlice(vipername string, v interface{}) ([]time.Duration, error) {
+	val, err := cast.ToDurationSliceE(v)
+	if err != nil {
+		err = withErr(vipername, err)
+	}
+	return val, err
+}
+
 func getUint(vipername string, v interface{}) (uint, error) {
 	val, err := cast.ToUintE(v)
 	if err != nil {
@@ -177,10 +203,297 @@ func getUint64(vipername string, v interface{}) (uint64, error) {
 	return val, err
 }
 
+// mapSupported returns whether the passed map type is supported for
+// configuration variables, keys have to be strings and values strings, ints
+// or bools.
+func mapSupported(t reflect.Type) bool {
+	if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
+		return false
+	}
+
+	switch t.Elem().Kind() {
+	case reflect.String, reflect.Int, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+// sliceSupported returns whether the passed slice type is supported for
+// configuration variables, the elements have to be strings, ints, float64s or
+// time.Durations.
+func sliceSupported(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice {
+		return false
+	}
+
+	if t.Elem() == reflect.TypeOf(time.Second) {
+		return true
+	}
+	switch t.Elem().Kind() {
+	case reflect.String, reflect.Int, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// splitList splits the passed list of values separated by sep, values
+// containing the separator can be quoted as CSV fields.
+func splitList(vipername, s string, sep rune) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return []string{}, nil
+	}
+
+	r := csv.NewReader(strings.NewReader(s))
+	r.Comma = sep
+	r.TrimLeadingSpace = true
+	items, err := r.Read()
+	if err != nil {
+		return nil, withErr(vipername, err)
+	}
+	return items, nil
+}
+
+// listString returns the passed slice as a list of values separated by sep,
+// as it would be set on the command line or in the environment.
+func listString(field reflect.Value, sep rune) string {
+	if !field.IsValid() || field.Len() == 0 {
+		return ""
+	}
+
+	items := make([]string, field.Len())
+	for i := range items {
+		items[i] = fmt.Sprint(field.Index(i).Interface())
+	}
+
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Comma = sep
+	if err := w.Write(items); err != nil {
+		// Should not happen when writing to a strings.Builder
+		return strings.Join(items, string(sep))
+	}
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// getSlice converts the passed value to a slice of the passed type.
+func getSlice(vipername string, t reflect.Type, v interface{}) (reflect.Value, error) {
+	var vs interface{}
+	var err error
+	switch {
+	case t.Elem() == reflect.TypeOf(time.Second):
+		vs, err = getDurationSlice(vipername, v)
+	case t.Elem().Kind() == reflect.Int:
+		vs, err = getIntSlice(vipername, v)
+	case t.Elem().Kind() == reflect.Float64:
+		vs, err = getFloat64Slice(vipername, v)
+	case t.Elem().Kind() == reflect.String:
+		// Unlikely to fail
+		vs, err = getStringSlice(vipername, v)
+	default:
+		// Should not happen as binding.go does check for this
+		return reflect.Value{}, fmt.Errorf(
+			"Only int, float64, time.Duration and string slices are supported, field %s with value %v", vipername, v)
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(vs).Convert(t), nil
+}
+
+// sliceValue is the command line flag used for slice fields, every time the
+// flag is repeated one value is added, or a list of comma separated values if
+// csv is set. The default value of the field is replaced the first time the
+// flag is set, the values set are kept to be appended to the configuration
+// file ones for fields with the append option.
+type sliceValue struct {
+	field  reflect.Value
+	name   string
+	csv    bool
+	values reflect.Value
+}
+
+// Set adds the passed value, or values, to the slice
+func (s *sliceValue) Set(v string) error {
+	items := []string{v}
+	if s.csv {
+		var err error
+		if items, err = splitList(s.name, v, defaultSep); err != nil {
+			return err
+		}
+	}
+
+	vs, err := getSlice(s.name, s.field.Type(), items)
+	if err != nil {
+		return err
+	}
+
+	if !s.values.IsValid() {
+		s.values = reflect.MakeSlice(s.field.Type(), 0, vs.Len())
+	}
+	s.values = reflect.AppendSlice(s.values, vs)
+	s.field.Set(s.values)
+	return nil
+}
+
+// Type returns the name of the slice type, as displayed in the help
+func (s *sliceValue) Type() string {
+	if !s.field.IsValid() {
+		return "stringSlice"
+	}
+	if s.field.Type().Elem() == reflect.TypeOf(time.Second) {
+		return "durationSlice"
+	}
+	return s.field.Type().Elem().Kind().String() + "Slice"
+}
+
+// String returns the slice as a list of comma separated values
+func (s *sliceValue) String() string {
+	return listString(s.field, defaultSep)
+}
+
+// parsePairs parses a list of key=value pairs separated by commas, pairs
+// containing commas can be quoted as CSV fields.
+func parsePairs(vipername, s string) (map[string]string, error) {
+	pairs := map[string]string{}
+	items, err := splitList(vipername, s, defaultSep)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, withErr(vipername, fmt.Errorf("%s is not a key=value pair", item))
+		}
+		pairs[strings.TrimSpace(kv[0])] = kv[1]
+	}
+	return pairs, nil
+}
+
+// getMap converts the passed value, either a table from the configuration
+// file or a list of key=value pairs as set on the command line or in the
+// environment, to a map of the passed type.
+func getMap(vipername string, t reflect.Type, v interface{}) (reflect.Value, error) {
+	values := map[string]interface{}{}
+	if vs, ok := v.(string); ok {
+		pairs, err := parsePairs(vipername, vs)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		for k, pv := range pairs {
+			values[k] = pv
+		}
+	} else if v != nil {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Map {
+			return reflect.Value{}, withErr(vipername, fmt.Errorf("unable to cast %#v of type %T to a map", v, v))
+		}
+		for _, k := range rv.MapKeys() {
+			values[fmt.Sprint(k.Interface())] = rv.MapIndex(k).Interface()
+		}
+	}
+
+	// Keys are lowercased whatever their source, as viper does for the ones
+	// read from configuration files.
+	m := reflect.MakeMapWithSize(t, len(values))
+	for k, ev := range values {
+		var err error
+		k = strings.ToLower(k)
+		name := vipername + sepKeyParts + k
+		switch t.Elem().Kind() {
+		case reflect.String:
+			ev, err = getString(name, ev)
+		case reflect.Int:
+			ev, err = getInt(name, ev)
+		case reflect.Bool:
+			ev, err = getBool(name, ev)
+		default:
+			// Should not happen as binding.go does check for this
+			return reflect.Value{}, fmt.Errorf("Only string, int and bool maps are supported, field %s", vipername)
+		}
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		m.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), reflect.ValueOf(ev).Convert(t.Elem()))
+	}
+	return m, nil
+}
+
+// mapString returns the passed map as a list of key=value pairs separated by
+// commas, sorted by key, as it would be set on the command line or in the
+// environment.
+func mapString(field reflect.Value) string {
+	if !field.IsValid() || field.Len() == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, field.Len())
+	for _, k := range field.MapKeys() {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", k.Interface(), field.MapIndex(k).Interface()))
+	}
+	sort.Strings(pairs)
+
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	if err := w.Write(pairs); err != nil {
+		// Should not happen when writing to a strings.Builder
+		return strings.Join(pairs, ",")
+	}
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// mapValue is the command line flag used for map fields, it accepts a list
+// of key=value pairs separated by commas and can be repeated. The default
+// value of the field is replaced the first time the flag is set.
+type mapValue struct {
+	field reflect.Value
+	name  string
+	set   bool
+}
+
+// Set adds the passed key=value pairs to the map
+func (m *mapValue) Set(s string) error {
+	vs, err := getMap(m.name, m.field.Type(), s)
+	if err != nil {
+		return err
+	}
+
+	if !m.set {
+		m.field.Set(reflect.MakeMap(m.field.Type()))
+		m.set = true
+	}
+	for _, k := range vs.MapKeys() {
+		m.field.SetMapIndex(k, vs.MapIndex(k))
+	}
+	return nil
+}
+
+// Type returns the name of the map type, as displayed in the help
+func (m *mapValue) Type() string {
+	if !m.field.IsValid() {
+		return "stringToString"
+	}
+	switch m.field.Type().Elem().Kind() {
+	case reflect.Int:
+		return "stringToInt"
+	case reflect.Bool:
+		return "stringToBool"
+	default:
+		return "stringToString"
+	}
+}
+
+// String returns the map as a list of key=value pairs
+func (m *mapValue) String() string {
+	return mapString(m.field)
+}
+
 // setField will reflect set a specific field with the relevant value v (viper
 // value as opposed to the passed value if viper is not nil). Vipername is
-// always passed to make error messages nicer.
-func setField(cfg Config, field reflect.Value, vp *viper.Viper, v interface{}, vipername string) (err error) {
+// always passed to make error messages nicer, secret values are not traced.
+func setField(cfg Config, field reflect.Value, vp *viper.Viper, v interface{}, vipername string, secret bool) (err error) {
 	if vp != nil {
 		v = vp.Get(vipername)
 	}
@@ -194,91 +507,91 @@ func setField(cfg Config, field reflect.Value, vp *viper.Viper, v interface{}, v
 			// Unlikely to fail
 			return
 		}
-		cfg.Tracef("Will assign string: %v", vs)
+		cfg.Tracef("Will assign string: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Bool:
 		var vs bool
 		if vs, err = getBool(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign bool: %v", vs)
+		cfg.Tracef("Will assign bool: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Float32:
 		var vs float32
 		if vs, err = getFloat32(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign float32: %v", vs)
+		cfg.Tracef("Will assign float32: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Float64:
 		var vs float64
 		if vs, err = getFloat64(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign float64: %v", vs)
+		cfg.Tracef("Will assign float64: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Uint:
 		var vs uint
 		if vs, err = getUint(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign uint: %v", vs)
+		cfg.Tracef("Will assign uint: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Uint8:
 		var vs uint8
 		if vs, err = getUint8(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign uint8: %v", vs)
+		cfg.Tracef("Will assign uint8: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Uint16:
 		var vs uint16
 		if vs, err = getUint16(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign uint16: %v", vs)
+		cfg.Tracef("Will assign uint16: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Uint32:
 		var vs uint32
 		if vs, err = getUint32(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign uint32: %v", vs)
+		cfg.Tracef("Will assign uint32: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Uint64:
 		var vs uint64
 		if vs, err = getUint64(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign uint64: %v", vs)
+		cfg.Tracef("Will assign uint64: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Int:
 		var vs int
 		if vs, err = getInt(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign int: %v", vs)
+		cfg.Tracef("Will assign int: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Int8:
 		var vs int8
 		if vs, err = getInt8(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign int8: %v", vs)
+		cfg.Tracef("Will assign int8: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Int16:
 		var vs int16
 		if vs, err = getInt16(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign int16: %v", vs)
+		cfg.Tracef("Will assign int16: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Int32:
 		var vs int32
 		if vs, err = getInt32(vipername, v); err != nil {
 			return
 		}
-		cfg.Tracef("Will assign int32: %v", vs)
+		cfg.Tracef("Will assign int32: %v", maskValue(secret, vs))
 		field.Set(reflect.ValueOf(vs))
 	case reflect.Int64:
 		// time.Duration serializes/deserializes to int64 apparently, so
@@ -288,16 +601,16 @@ func setField(cfg Config, field reflect.Value, vp *viper.Viper, v interface{}, v
 			return
 		}
 		if field.Type() == reflect.TypeOf(time.Second) {
-			cfg.Tracef("Will assign time.Duration: %v", vs)
+			cfg.Tracef("Will assign time.Duration: %v", maskValue(secret, vs))
 			field.Set(reflect.ValueOf(time.Duration(vs)))
 		} else {
-			cfg.Tracef("Will assign int64: %v", vs)
+			cfg.Tracef("Will assign int64: %v", maskValue(secret, vs))
 			field.Set(reflect.ValueOf(vs))
 		}
 	case reflect.Struct:
 		if field.Type() == reflect.TypeOf(v) {
 			// time.Time is unmarshaled as a struct in TOML
-			cfg.Tracef("Same struct type, assigning as-is: %v", v)
+			cfg.Tracef("Same struct type, assigning as-is: %v", maskValue(secret, v))
 			field.Set(reflect.ValueOf(v))
 		} else {
 			// but when it's an environment variable of course it's a
@@ -315,11 +628,11 @@ func setField(cfg Config, field reflect.Value, vp *viper.Viper, v interface{}, v
 	case reflect.Ptr:
 		if field.Type() == reflect.TypeOf(v) {
 			// TODO: Have not been able to exercise this
-			cfg.Tracef("Same ptr type, assigning as-is: %v", v)
+			cfg.Tracef("Same ptr type, assigning as-is: %v", maskValue(secret, v))
 			field.Set(reflect.ValueOf(v))
 		} else {
 			if field.Elem().Type() == reflect.TypeOf(v) {
-				cfg.Tracef("We have a ptr to the same type, assigning as-is: %v", v)
+				cfg.Tracef("We have a ptr to the same type, assigning as-is: %v", maskValue(secret, v))
 				// Ok, we have a ptr to the same in our config, just assign it
 				field.Elem().Set(reflect.ValueOf(v))
 			} else {
@@ -333,25 +646,27 @@ func setField(cfg Config, field reflect.Value, vp *viper.Viper, v interface{}, v
 			}
 		}
 	case reflect.Slice:
-		switch field.Type().Elem().Kind() {
-		case reflect.Int:
-			var vs []int
-			if vs, err = getIntSlice(vipername, v); err != nil {
-				return
-			}
-			field.Set(reflect.ValueOf(vs))
-		case reflect.String:
-			var vs []string
-			if vs, err = getStringSlice(vipername, v); err != nil {
-				// Unlikely to fail
-				return
-			}
-			field.Set(reflect.ValueOf(vs))
-		default:
-			// Should not happen as binding.go does check for this
-			return fmt.Errorf(
-				"Only int and string slices are supported, field %s with value %v", vipername, vp.Get(vipername))
+		var vs reflect.Value
+		if vs, err = getSlice(vipername, field.Type(), v); err != nil {
+			return
+		}
+		// Keep nil slices nil if there is nothing to assign
+		if vs.Len() == 0 && field.IsNil() {
+			return
+		}
+		cfg.Tracef("Will assign slice: %v", maskValue(secret, vs.Interface()))
+		field.Set(vs)
+	case reflect.Map:
+		var vs reflect.Value
+		if vs, err = getMap(vipername, field.Type(), v); err != nil {
+			return
+		}
+		// Keep nil maps nil if there is nothing to assign
+		if vs.Len() == 0 && field.IsNil() {
+			return
 		}
+		cfg.Tracef("Will assign map: %v", maskValue(secret, mapString(vs)))
+		field.Set(vs)
 	default:
 		// Should not happen, but just in case
 		return fmt.Errorf(
@@ -377,12 +692,12 @@ func setField(cfg Config, field reflect.Value, vp *viper.Viper, v interface{}, v
 		}
 
 		p := []reflect.Value{vv}
-		cfg.Tracef("Value is a struct, field isn't but has unmarshal, call it to set %v in %s", v, vipername)
+		cfg.Tracef("Value is a struct, field isn't but has unmarshal, call it to set %v in %s", maskValue(secret, v), vipername)
 		rv := setter.Call(p)
 		rve := rv[len(rv)-1]
 		if !rve.IsNil() {
 			if rerr, ok := rve.Interface().(error); ok {
-				return errors.WithMessage(rerr, "Cannot convert flag value via unmarshaling")
+				return fmt.Errorf("Cannot convert flag value via unmarshaling: %w", rerr)
 			}
 
 			// Should not happen
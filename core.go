@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"text/template"
 	"time"
@@ -17,6 +18,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -30,16 +32,25 @@ import (
 type Config interface {
 	Cleanup()
 	Dump(Config) (string, error)
+	Err() io.Writer
 	Execute(Config, map[string]*DocSet) error
+	ExitCode() int
 	GetConfigFile() string
+	GetConfigFiles() []string
 	GetCurrentCommand() string
 	GetDefaultLanguage() string
 	GetDocs() (string, *DocSet)
 	GetFs() afero.Fs
+	GetValueSource(string) string
+	Main(Config, map[string]*DocSet)
+	OnConfigChange(func(Config, Config))
+	Out() io.Writer
 	RegisterExtraParse(func(Config, map[string]interface{}) ([]string, error), []string)
 	SetFs(afero.Fs)
 	SetHandler(OverrideHandler, Handler) error
 	SetOptions(BaseConfigOptions) error
+	SetOutput(io.Writer, io.Writer)
+	Snapshot() Config
 	GetLogger() Logger
 	SetLoggers(MakeLogger, MakeLogger, MakeTraceLogger) error
 	Unmarshal(string, interface{}) error
@@ -132,6 +143,21 @@ type DocSet struct {
 	// message for the config env command
 	ConfigEnvMsg3 string
 
+	// DidYouMean contains the localizable suggestion added to the errors for
+	// unknown configuration variables, commands and flags, %s is replaced by
+	// the suggested name
+	DidYouMean string
+
+	// PromptAborted contains the localizable message of the error returned
+	// when the input ends while config init --interactive is asking for
+	// values
+	PromptAborted string
+
+	// PromptInvalid contains the localizable message shown when an invalid
+	// value is entered during config init --interactive, %s is replaced by
+	// the reason the value is invalid
+	PromptInvalid string
+
 	// Help points to a struct that contains the strings used in the help
 	// template itself (things like "Aliases:" etc.)
 	Help *HelpStrings
@@ -198,12 +224,29 @@ type HelpStrings struct {
 // BaseConfigOptions can be used to set the same-named variables in a
 // configuration to the specified values. DefaultLanguage will be ignored if
 // empty, version strings will be assigned as-is
+//
+// MergeConfigFiles enables layered configuration files: rather than loading
+// only the first configuration file found, the system configuration file,
+// the user configuration file and the project configuration file (the one in
+// the current directory, or the one passed via --config) are all loaded, in
+// this order, each one overriding the values of the previous ones key by
+// key.
+//
+// WatchConfig enables reloading of the configuration, for long-running
+// commands, when the loaded configuration files change on disk or when the
+// program receives SIGHUP. The new configuration is validated before
+// replacing the current one, and functions registered via OnConfigChange are
+// then called. As the values are replaced while the command is running, its
+// handler should read them from a copy returned by Snapshot. The built-in
+// config and version commands do not watch the configuration.
 type BaseConfigOptions struct {
 	DefaultLanguage   string
 	VersionFull       string
 	VersionMajor      string
 	VersionMinor      string
 	VersionPatchlevel string
+	MergeConfigFiles  bool
+	WatchConfig       bool
 }
 
 // BaseConfig is the default base configuration, that needs to be embedded in
@@ -222,6 +265,11 @@ type BaseConfig struct {
 	// requested configuration file.
 	ConfFile string `greenery:"|config|c, , CONFIGFILE"`
 
+	// Profile maps to the profile options, it contains the name of the
+	// requested configuration profile, whose [profile.<name>] table in the
+	// configuration file will be overlaid on the base configuration.
+	Profile string `greenery:"|profile|, , PROFILE"`
+
 	// LogFile maps to the log file options, it contains the name of the
 	// requested log file.
 	LogFile string `greenery:"|log-file|, .log-file, LOGFILE"`
@@ -244,6 +292,10 @@ type BaseConfig struct {
 	// level of verbosity.
 	Verbosity *IntValue `greenery:"|verbosity|v, .verbosity, VERBOSITY"`
 
+	// ErrorFormat maps to the error format options, it contains whether the
+	// errors returned by Execute are reported as text or as JSON objects.
+	ErrorFormat *EnumValue `greenery:"|error-format|, .error-format, ERRORFORMAT"`
+
 	// DoTrace maps to the tracing options, it contains whether the user
 	// requested tracing output.
 	DoTrace bool `greenery:"|trace|hidden, , TRACE"`
@@ -257,10 +309,49 @@ type BaseConfig struct {
 	// overwritten by the command.
 	CfgForce bool `greenery:"config>init|force|,,"`
 
+	// CfgInteractive maps to the --interactive parameter to the config init
+	// command, if set the configuration values are asked for on the terminal
+	// before the configuration file is written.
+	CfgInteractive bool `greenery:"config>init|interactive|,,"`
+
 	// CfgLocation maps to the --location parameter to the config init
 	// command, this controls where the configuration file is created.
 	CfgLocation *EnumValue `greenery:"config>init|location|,,"`
 
+	// CfgFormat maps to the --format parameter to the config init command,
+	// this controls the format of the configuration file that is created.
+	CfgFormat *EnumValue `greenery:"config>init|format|,,"`
+
+	// CfgOutput maps to the --output parameter to the config display
+	// command, this controls whether the configuration is displayed as text
+	// or as a configuration file in one of the supported formats.
+	CfgOutput *EnumValue `greenery:"config>display|output|,,"`
+
+	// CfgReveal maps to the --reveal parameter to the config display and
+	// config get commands, this controls whether the values of fields marked
+	// as secret are displayed.
+	CfgReveal bool `greenery:"config>display|reveal|&config>get|reveal|,,"`
+
+	// CfgExport maps to the --export parameter to the config env command,
+	// if set the environment variables are output as a script for the
+	// requested shell.
+	CfgExport *CustomStringValue `greenery:"config>env|export|,,"`
+
+	// CfgAll maps to the --all parameter to the config env command, this
+	// controls whether all the environment variables are exported rather
+	// than only the ones currently set.
+	CfgAll bool `greenery:"config>env|all|,,"`
+
+	// CfgDryRun maps to the --dry-run parameter to the config upgrade
+	// command, this controls whether the changes are only shown rather than
+	// written to the configuration file.
+	CfgDryRun bool `greenery:"config>upgrade|dry-run|,,"`
+
+	// CfgDefaults maps to the --defaults parameter to the config diff
+	// command, this controls whether the configuration file passed is
+	// compared against the defaults rather than the current configuration.
+	CfgDefaults bool `greenery:"config>diff|defaults|,,"`
+
 	// Values users is expected to set as part of their configuration init
 	// function. Users might need to access these directly in their code
 	// afterwards (for example to implement version compatibility, or to check
@@ -283,15 +374,24 @@ type BaseConfig struct {
 	s_additionalEnv   []additionalStruct
 	s_appName         string
 	s_args            []string
+	s_bindings        *viperBindings
+	s_ccmd            *cobra.Command
+	s_cfgChange       []func(Config, Config)
 	s_cfgDir          string
+	s_cfgMtx          *sync.RWMutex
+	s_cfgSources      map[string]string
 	s_cl              Config
 	s_cmds            map[string]*cobra.Command
 	s_cobrabuf        *bytes.Buffer
 	s_currentcmd      string
 	s_defaultLanguage string
+	s_defaults        Config
 	s_docs            *DocSet
 	s_env             map[string]string
+	s_err             io.Writer
+	s_errorWritten    bool
 	s_executing       bool
+	s_exitCode        int
 	s_extraParser     func(Config, map[string]interface{}) ([]string, error)
 	s_extraWanted     []string
 	s_filesToClose    []afero.File
@@ -302,13 +402,24 @@ type BaseConfig struct {
 	s_lang            string
 	s_loaded          bool
 	s_log             Logger
+	s_mergeCfg        bool
+	s_out             io.Writer
 	s_processed       bool
+	s_profile         string
+	s_profiles        []string
+	s_reloadMtx       *sync.Mutex
 	s_trace           Logger
 	s_tracing         bool
 	s_ucAppName       string
 	s_usedConf        string
+	s_usedConfs       []string
 	s_v               *viper.Viper
+	s_valueSources    map[string]string
 	s_w               io.Writer
+	s_watchCfg        bool
+	s_watchDone       chan struct{}
+	s_watchStopped    chan struct{}
+	s_watcher         *fsnotify.Watcher
 
 	s_makeStructured MakeLogger
 	s_makePretty     MakeLogger
@@ -360,6 +471,8 @@ func (cfg *BaseConfig) SetOptions(opts BaseConfigOptions) error {
 	cfg.VersionMajor = opts.VersionMajor
 	cfg.VersionMinor = opts.VersionMinor
 	cfg.VersionPatchlevel = opts.VersionPatchlevel
+	cfg.s_mergeCfg = opts.MergeConfigFiles
+	cfg.s_watchCfg = opts.WatchConfig
 	return nil
 }
 
@@ -374,6 +487,31 @@ func (cfg *BaseConfig) GetFs() afero.Fs {
 	return cfg.s_fs
 }
 
+// SetOutput sets the writers used for the output of the library, including
+// the built-in commands, the help and usage text and the traces, and the
+// error output, including the logs if no log file is configured. Passing nil
+// writers restores the default ones, os.Stdout and os.Stderr.
+func (cfg *BaseConfig) SetOutput(out, err io.Writer) {
+	cfg.s_out = out
+	cfg.s_err = err
+}
+
+// Out returns the writer handlers should use for their output
+func (cfg *BaseConfig) Out() io.Writer {
+	if cfg.s_out == nil {
+		return os.Stdout
+	}
+	return cfg.s_out
+}
+
+// Err returns the writer handlers should use for their error output
+func (cfg *BaseConfig) Err() io.Writer {
+	if cfg.s_err == nil {
+		return os.Stderr
+	}
+	return cfg.s_err
+}
+
 // GetCurrentCommand returns the name of the currently executing command, note
 // that the root/base command is returned as ""
 func (cfg *BaseConfig) GetCurrentCommand() string {
@@ -385,6 +523,33 @@ func (cfg *BaseConfig) GetDefaultLanguage() string {
 	return cfg.s_defaultLanguage
 }
 
+// OnConfigChange registers a function that will be called, with the previous
+// and the current configuration, every time the configuration is reloaded
+// successfully. Reloading happens only if WatchConfig has been set in the
+// options. The functions are called from the goroutine watching the
+// configuration, no other reload happens while they run.
+func (cfg *BaseConfig) OnConfigChange(f func(Config, Config)) {
+	cfg.s_cfgMtx.Lock()
+	defer cfg.s_cfgMtx.Unlock()
+	cfg.s_cfgChange = append(cfg.s_cfgChange, f)
+}
+
+// Snapshot returns a copy of the current configuration. When WatchConfig has
+// been set in the options the values can be replaced at any time by a reload
+// while the command is running, a snapshot is not affected by reloads and can
+// be read safely.
+func (cfg *BaseConfig) Snapshot() Config {
+	cfg.s_cfgMtx.RLock()
+	defer cfg.s_cfgMtx.RUnlock()
+
+	if cfg.s_cl == nil {
+		c, _ := copyConfig(cfg)
+		return c
+	}
+	c, _ := copyConfig(cfg.s_cl)
+	return c
+}
+
 // RegisterExtraParse allows additional parsing for the configuration file,
 // see the "custom" example for a usage example.
 func (cfg *BaseConfig) RegisterExtraParse(f func(Config, map[string]interface{}) ([]string, error), a []string) {
@@ -396,14 +561,79 @@ func (cfg *BaseConfig) RegisterExtraParse(f func(Config, map[string]interface{})
 // type is supported by the underlying TOML library. See the "custom" example
 // for a usage example.
 func (cfg *BaseConfig) Unmarshal(s string, v interface{}) error {
+	cfg.s_cfgMtx.RLock()
+	defer cfg.s_cfgMtx.RUnlock()
 	return cfg.s_v.UnmarshalKey(s, v)
 }
 
-// GetConfigFile returns the filename of the configuration file used.
+// GetConfigFile returns the filename of the configuration file used. If
+// configuration files are merged, this is the file with the highest
+// precedence.
 func (cfg *BaseConfig) GetConfigFile() string {
+	cfg.s_cfgMtx.RLock()
+	defer cfg.s_cfgMtx.RUnlock()
 	return cfg.s_usedConf
 }
 
+// GetConfigFiles returns the filenames of all the configuration files used,
+// in order of precedence with the last one having the highest. Unless
+// configuration files are merged, this will contain at most one file.
+func (cfg *BaseConfig) GetConfigFiles() []string {
+	cfg.s_cfgMtx.RLock()
+	defer cfg.s_cfgMtx.RUnlock()
+	return cfg.s_usedConfs
+}
+
+// GetValueSource returns where the value of the passed configuration field
+// came from: "default", "config file <file name>", "environment variable
+// <variable name>" or "flag --<flag name>". An empty string is returned if
+// the field does not exist.
+func (cfg *BaseConfig) GetValueSource(name string) string {
+	cfg.s_cfgMtx.RLock()
+	defer cfg.s_cfgMtx.RUnlock()
+
+	if src, ok := cfg.s_valueSources[name]; ok {
+		return src
+	}
+
+	t := baseType
+	if cfg.s_cl != nil {
+		t = reflect.TypeOf(cfg.s_cl).Elem()
+	}
+	if f, ok := fieldByName(t, name); ok && f.PkgPath == "" {
+		return sourceDefault
+	}
+	return ""
+}
+
+// isSecretField returns whether the passed configuration field is marked as
+// secret.
+func (cfg *BaseConfig) isSecretField(name string) bool {
+	t := baseType
+	if cfg.s_cl != nil {
+		t = reflect.TypeOf(cfg.s_cl).Elem()
+	}
+	x, ok := fieldByName(t, name)
+	return ok && isSecret(x)
+}
+
+// secretEnv returns the names of the environment variables corresponding to
+// fields marked as secret.
+func (cfg *BaseConfig) secretEnv() map[string]bool {
+	secrets := map[string]bool{}
+	if cfg.s_cl == nil {
+		return secrets
+	}
+
+	fields, _ := structFields(reflect.TypeOf(cfg.s_cl).Elem())
+	for _, x := range fields {
+		if _, _, env, err := parseTags(x); err == nil && env != "" && isSecret(x) {
+			secrets[cfg.s_ucAppName+"_"+env] = true
+		}
+	}
+	return secrets
+}
+
 // GetLogger returns the current logger
 func (cfg *BaseConfig) GetLogger() Logger {
 	return cfg.s_log
@@ -489,6 +719,100 @@ func (cfg *BaseConfig) TestHelper(task string, value interface{}) interface{} {
 	return nil
 }
 
+// sourceSuffix returns the source of the value of the passed field, as
+// displayed by Dump, or an empty string if the field cannot be set by the
+// user or is a custom configuration value.
+func (cfg *BaseConfig) sourceSuffix(x reflect.StructField) string {
+	cobra, vipername, env, err := parseTags(x)
+	if err != nil || strings.HasSuffix(cobra, sepCmdParts+"custom") {
+		return ""
+	}
+
+	if parts := strings.Split(cobra, sepCmdParts); vipername == "" && env == "" &&
+		(len(parts) < 2 || parts[1] == "") {
+		return ""
+	}
+	return " (" + cfg.GetValueSource(x.Name) + ")"
+}
+
+// dumpMap returns the Dump lines for the instances of the passed map
+// configuration field, the values of the instances can only come from the
+// configuration file.
+func (cfg *BaseConfig) dumpMap(x reflect.StructField, field reflect.Value) []string {
+	out := []string{}
+	for _, k := range field.MapKeys() {
+		iv := field.MapIndex(k)
+		prefix := x.Name + sepKeyParts + k.String() + sepKeyParts
+		for _, ix := range instanceFields(x, k.String()) {
+			_, vipername, _, _ := parseTags(ix)
+			source := sourceDefault
+			if src, ok := cfg.s_cfgSources[strings.ToLower(vipername)]; ok {
+				source = sourceCfgFile + src
+			}
+
+			value := iv.FieldByIndex(ix.Index).Interface()
+			out = append(out, fmt.Sprintf("\n%s%s: %v (%s)", prefix, strings.TrimPrefix(ix.Name, prefix),
+				maskValue(isSecret(ix) && !cfg.CfgReveal, value), source))
+		}
+	}
+	return out
+}
+
+// exportedFields returns a copy of the passed struct value with only its
+// exported fields set.
+func exportedFields(v reflect.Value) reflect.Value {
+	n := reflect.New(v.Type()).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if f := n.Field(i); f.CanSet() {
+			f.Set(v.Field(i))
+		}
+	}
+	return n
+}
+
+// maskSecrets returns a copy of the passed configuration, with the values of
+// all the fields marked as secret removed. Only the exported fields are
+// copied, as the internal state contains the secret values as well, for
+// example in the environment, the arguments and viper.
+func maskSecrets(icfg Config) Config {
+	ncfg, _ := copyConfig(icfg)
+	t := reflect.TypeOf(ncfg).Elem()
+	v := reflect.ValueOf(ncfg).Elem()
+	fields, _ := structFields(t)
+	for _, x := range fields {
+		if f := v.FieldByIndex(x.Index); isMapCfg(x) && f.CanSet() && !f.IsNil() {
+			masked := reflect.MakeMapWithSize(f.Type(), f.Len())
+			for _, k := range f.MapKeys() {
+				iv := reflect.New(f.Type().Elem()).Elem()
+				iv.Set(f.MapIndex(k))
+				for _, ix := range instanceFields(x, k.String()) {
+					if sf := iv.FieldByIndex(ix.Index); isSecret(ix) && sf.Kind() == reflect.String {
+						sf.SetString(maskedValue)
+					} else if isSecret(ix) {
+						sf.Set(reflect.Zero(sf.Type()))
+					}
+				}
+				masked.SetMapIndex(k, iv)
+			}
+			f.Set(masked)
+		} else if isSecret(x) && f.CanSet() {
+			if f.Kind() == reflect.String {
+				f.SetString(maskedValue)
+			} else {
+				f.Set(reflect.Zero(f.Type()))
+			}
+		}
+	}
+
+	ev := exportedFields(v)
+	for i := 0; i < ev.NumField(); i++ {
+		if f := ev.Field(i); f.Type() == basePType && !f.IsNil() {
+			f.Set(exportedFields(f.Elem()).Addr())
+		}
+	}
+	return ev.Addr().Interface().(Config)
+}
+
 // Dump will print on stdout the current configuration values
 func (cfg *BaseConfig) Dump(icfg Config) (string, error) {
 	out := []string{}
@@ -500,9 +824,9 @@ func (cfg *BaseConfig) Dump(icfg Config) (string, error) {
 	}
 
 	if cfg.DoTrace {
-		// For debugging purposes dump the actual full contents. Not exercised
-		// in UTs.
-		return spew.Sdump(icfg), nil
+		// For debugging purposes dump the actual full contents, apart from
+		// secrets and the internal state.
+		return spew.Sdump(maskSecrets(icfg)), nil
 	}
 
 	out = append(out, ` 
@@ -519,8 +843,8 @@ effect.
 	v := reflect.ValueOf(icfg).Elem()
 	outs := []string{}
 	outb := []string{}
-	for i := 0; i < t.NumField(); i++ {
-		x := t.Field(i)
+	fields, _ := structFields(t)
+	for _, x := range fields {
 
 		// Ignore unexported fields
 		r, _ := utf8.DecodeRuneInString(x.Name)
@@ -542,23 +866,41 @@ effect.
 				// users.
 				switch x2.Name {
 				case "LogLevel":
-					outb = append(outb, fmt.Sprintf("\nLogLevel: %s", cfg.LogLevel.Value))
+					outb = append(outb, fmt.Sprintf("\nLogLevel: %s%s", cfg.LogLevel.Value, cfg.sourceSuffix(x2)))
 				case "Pretty":
 					continue
+				case "ErrorFormat":
+					continue
 				case "NoEnv":
 					continue
 				case "Verbosity":
-					outb = append(outb, fmt.Sprintf("\nVerbosity: %v", cfg.Verbosity.Value))
+					outb = append(outb, fmt.Sprintf("\nVerbosity: %v%s", cfg.Verbosity.Value, cfg.sourceSuffix(x2)))
 				case "CfgLocation":
 					continue
 				case "CfgForce":
 					continue
+				case "CfgInteractive":
+					continue
+				case "CfgFormat":
+					continue
+				case "CfgOutput":
+					continue
+				case "CfgReveal":
+					continue
+				case "CfgExport":
+					continue
+				case "CfgAll":
+					continue
+				case "CfgDryRun":
+					continue
+				case "CfgDefaults":
+					continue
 				case "DoTrace":
 					continue
 				default:
-					v2 := v.Field(i).Elem()
+					v2 := v.FieldByIndex(x.Index).Elem()
 					field := v2.FieldByName(x2.Name)
-					outb = append(outb, fmt.Sprintf("\n%s: %v", x2.Name, field.Interface()))
+					outb = append(outb, fmt.Sprintf("\n%s: %v%s", x2.Name, field.Interface(), cfg.sourceSuffix(x2)))
 				}
 			}
 
@@ -566,9 +908,19 @@ effect.
 			// accessed via accessors
 			outb = append(outb, fmt.Sprintf("\nLoaded base,user languages (accessible via GetDocs): %s", cfg.s_lang))
 			outb = append(outb, fmt.Sprintf("\nLoaded config file, if any (accessible via GetConfigFile): %s", cfg.s_usedConf))
+			if cfg.s_mergeCfg {
+				outb = append(outb, fmt.Sprintf("\nMerged config files, if any (accessible via GetConfigFiles): %s", strings.Join(cfg.s_usedConfs, ", ")))
+			}
+		} else if isMapCfg(x) {
+			outs = append(outs, cfg.dumpMap(x, v.FieldByIndex(x.Index))...)
 		} else {
-			field := v.FieldByName(x.Name)
-			outs = append(outs, fmt.Sprintf("\n%s: %v", x.Name, field.Interface()))
+			field := v.FieldByIndex(x.Index)
+			value := field.Interface()
+			if field.Kind() == reflect.Map {
+				value = mapString(field)
+			}
+			outs = append(outs, fmt.Sprintf("\n%s: %v%s", x.Name,
+				maskValue(isSecret(x) && !cfg.CfgReveal, value), cfg.sourceSuffix(x)))
 		}
 	}
 
@@ -591,33 +943,52 @@ func NewBaseConfig(appname string, fmap map[string]Handler) *BaseConfig {
 	rootCmd := &cobra.Command{}
 	configCmd := &cobra.Command{}
 	configDisplayCmd := &cobra.Command{}
+	configProfilesCmd := &cobra.Command{}
 	configEnvCmd := &cobra.Command{}
 	configInitCmd := &cobra.Command{}
+	configGetCmd := &cobra.Command{}
+	configSetCmd := &cobra.Command{}
+	configUnsetCmd := &cobra.Command{}
+	configDiffCmd := &cobra.Command{}
+	configUpgradeCmd := &cobra.Command{}
+	configValidateCmd := &cobra.Command{}
 	versionCmd := &cobra.Command{}
 
 	cfg := BaseConfig{
 		Verbosity:    NewDefaultIntValue("Verbosity", 1, 0, 3),
 		CfgLocation:  NewDefaultEnumValue("CfgLocation", "cwd", "cwd", "user", "system"),
+		CfgFormat:    NewDefaultEnumValue("CfgFormat", "toml", "toml", "yaml", "json"),
+		CfgOutput:    NewDefaultEnumValue("CfgOutput", "text", "text", "json", "toml", "yaml"),
+		CfgExport:    NewCustomStringValue("CfgExport", validateShell, []string{"bash", "zsh", "fish", "powershell"}),
+		ErrorFormat:  NewDefaultEnumValue("ErrorFormat", "text", "text", "json"),
 		LogLevel:     NewDefaultEnumValue("LogLevel", "error", "debug", "info", "warn", "error"),
 		VersionMajor: "0",
 		VersionMinor: "0",
 
 		s_cmds: map[string]*cobra.Command{
-			rootCommandID:        rootCmd,
-			doc.ConfigCmd:        configCmd,
-			doc.ConfigDisplayCmd: configDisplayCmd,
-			doc.ConfigEnvCmd:     configEnvCmd,
-			doc.ConfigInitCmd:    configInitCmd,
-			doc.VersionCmd:       versionCmd,
+			rootCommandID:         rootCmd,
+			doc.ConfigCmd:         configCmd,
+			doc.ConfigDisplayCmd:  configDisplayCmd,
+			doc.ConfigProfilesCmd: configProfilesCmd,
+			doc.ConfigEnvCmd:      configEnvCmd,
+			doc.ConfigInitCmd:     configInitCmd,
+			doc.ConfigGetCmd:      configGetCmd,
+			doc.ConfigSetCmd:      configSetCmd,
+			doc.ConfigUnsetCmd:    configUnsetCmd,
+			doc.ConfigDiffCmd:     configDiffCmd,
+			doc.ConfigUpgradeCmd:  configUpgradeCmd,
+			doc.ConfigValidateCmd: configValidateCmd,
+			doc.VersionCmd:        versionCmd,
 		},
 		s_cobrabuf:        new(bytes.Buffer),
 		s_defaultLanguage: "en",
 		s_env:             make(map[string]string),
 		s_v:               viper.New(),
-		s_w:               os.Stderr,
 		s_filesToClose:    make([]afero.File, 0),
 		s_filesToRemove:   make([]string, 0),
 		s_fmap:            fmap,
+		s_reloadMtx:       &sync.Mutex{},
+		s_cfgMtx:          &sync.RWMutex{},
 		s_appName:         appname,
 		s_ucAppName:       strings.ToUpper(appname),
 
@@ -629,23 +1000,52 @@ func NewBaseConfig(appname string, fmap map[string]Handler) *BaseConfig {
 	cfg.SetFs(afero.NewOsFs())
 
 	configInitCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
-		return runWrapper(cmd, &cfg, configInitCmdRunner, args)
+		return runWrapper(cmd, &cfg, configInitCmdRunner, args, false)
 	}
 
 	configEnvCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
-		return runWrapper(cmd, &cfg, configEnvCmdRunner, args)
+		return runWrapper(cmd, &cfg, configEnvCmdRunner, args, false)
 	}
 
 	configDisplayCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
-		return runWrapper(cmd, &cfg, configDisplayCmdRunner, args)
+		return runWrapper(cmd, &cfg, configDisplayCmdRunner, args, false)
+	}
+
+	configProfilesCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		return runWrapper(cmd, &cfg, configProfilesCmdRunner, args, false)
+	}
+
+	configGetCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		return runWrapper(cmd, &cfg, configGetCmdRunner, args, false)
+	}
+
+	configSetCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		return runWrapper(cmd, &cfg, configSetCmdRunner, args, false)
+	}
+
+	configUnsetCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		return runWrapper(cmd, &cfg, configUnsetCmdRunner, args, false)
+	}
+
+	configDiffCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		return runWrapper(cmd, &cfg, configDiffCmdRunner, args, false)
+	}
+
+	configUpgradeCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		return runWrapper(cmd, &cfg, configUpgradeCmdRunner, args, false)
+	}
+
+	configValidateCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
+		return runWrapper(cmd, &cfg, configValidateCmdRunner, args, false)
 	}
 
 	versionCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
-		return runWrapper(cmd, &cfg, versionCmdRunner, args)
+		return runWrapper(cmd, &cfg, versionCmdRunner, args, false)
 	}
 
 	cfg.s_cl = &cfg
 	cfg.s_inited = true
+	cfg.s_bindings = newViperBindings(cfg.s_v)
 	return &cfg
 }
 
@@ -679,6 +1079,36 @@ func (cfg *BaseConfig) StopTracing() {
 // configuration struct, as well as the documentation strings to be used for
 // the program.
 func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) error {
+	cfg.s_errorWritten = false
+	err := cfg.execute(icfg, userDocList)
+	cfg.s_exitCode = exitCode(err)
+	return err
+}
+
+// Main executes the program as Execute does, printing any error returned
+// unless it was already written in the json error format, then calls Cleanup
+// and exits with the exit code for the error. This means it does not return,
+// so it should be the last thing called by main().
+func (cfg *BaseConfig) Main(icfg Config, userDocList map[string]*DocSet) {
+	if err := cfg.Execute(icfg, userDocList); err != nil && !cfg.s_errorWritten {
+		fmt.Fprintf(cfg.Err(), "Error executing: %s\n", err)
+	}
+
+	cfg.Cleanup()
+	os.Exit(cfg.s_exitCode)
+}
+
+// ExitCode returns the exit code Main would exit with for the error returned
+// by the last Execute: ExitOK if there was no error, ExitUsageError for
+// problems with the command line, ExitConfigError for problems with the
+// configuration and ExitHandlerError for anything else, unless the error
+// implements ExitCoder.
+func (cfg *BaseConfig) ExitCode() int {
+	return cfg.s_exitCode
+}
+
+// execute is the implementation of Execute
+func (cfg *BaseConfig) execute(icfg Config, userDocList map[string]*DocSet) error {
 	if !cfg.s_inited {
 		return fmt.Errorf("The configuration struct passed to execute was not initialized properly")
 	}
@@ -720,7 +1150,7 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 		// needed in the handler.
 		rootCmd.Args = cobra.ArbitraryArgs
 		rootCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
-			return runWrapper(cmd, cfg, cfg.s_rootHandler, args)
+			return runWrapper(cmd, cfg, cfg.s_rootHandler, args, true)
 		}
 	} else {
 		rootCmd.Args = cobra.NoArgs
@@ -729,7 +1159,7 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 	if cfg.s_configHandler != nil {
 		configCmd.Args = cobra.ArbitraryArgs
 		configCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
-			return runWrapper(cmd, cfg, cfg.s_configHandler, args)
+			return runWrapper(cmd, cfg, cfg.s_configHandler, args, true)
 		}
 	} else {
 		configCmd.Args = cobra.NoArgs
@@ -739,7 +1169,7 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 	versionCmd.Args = cobra.ArbitraryArgs
 	if cfg.s_versionHandler != nil {
 		versionCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
-			return runWrapper(cmd, cfg, cfg.s_versionHandler, args)
+			return runWrapper(cmd, cfg, cfg.s_versionHandler, args, true)
 		}
 	}
 
@@ -759,7 +1189,7 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 		if len(cfg.s_fmap) != 0 {
 			// This usually means the caller did not provide a default
 			// documentation or had issues in it.
-			return fmt.Errorf("Cannot load the documentation: %s", err.Error())
+			return fmt.Errorf("%w: %v", ErrMissingDocs, err)
 		}
 	}
 
@@ -940,6 +1370,15 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 	if userDocs.ConfigEnvMsg3 != "" {
 		defaultDoc.ConfigEnvMsg3 = userDocs.ConfigEnvMsg3
 	}
+	if userDocs.DidYouMean != "" {
+		defaultDoc.DidYouMean = userDocs.DidYouMean
+	}
+	if userDocs.PromptAborted != "" {
+		defaultDoc.PromptAborted = userDocs.PromptAborted
+	}
+	if userDocs.PromptInvalid != "" {
+		defaultDoc.PromptInvalid = userDocs.PromptInvalid
+	}
 
 	var b bytes.Buffer
 	err = t.Execute(&b, usagePreprocess)
@@ -950,7 +1389,7 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 	cfg.s_cmds[rootCommandID].SetUsageTemplate(b.String())
 
 	seenFields := map[string]bool{}
-	cfg.s_additionalEnv, err = createBindings(cfg.TraceSkipf, cfg, cfg.s_v, cfg.s_cmds, cfg.s_env, defaultDoc.CmdLine, cfg.s_ucAppName, seenFields)
+	cfg.s_additionalEnv, err = createBindings(cfg.TraceSkipf, cfg, cfg.s_bindings, cfg.s_cmds, cfg.s_env, defaultDoc.CmdLine, cfg.s_ucAppName, seenFields)
 	if err != nil {
 		// Should not happen
 		return err
@@ -988,6 +1427,13 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 			k == doc.VersionCmd ||
 			k == doc.ConfigInitCmd ||
 			k == doc.ConfigDisplayCmd ||
+			k == doc.ConfigProfilesCmd ||
+			k == doc.ConfigGetCmd ||
+			k == doc.ConfigSetCmd ||
+			k == doc.ConfigUnsetCmd ||
+			k == doc.ConfigDiffCmd ||
+			k == doc.ConfigUpgradeCmd ||
+			k == doc.ConfigValidateCmd ||
 			k == doc.ConfigEnvCmd {
 			if k == rootCommandID {
 				k = "root"
@@ -1009,23 +1455,23 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 		parent, cname := getParentChild(k)
 
 		if strings.ContainsAny(parent, invalid) {
-			return fmt.Errorf("Command name %s contains an invalid character, any of%s or space is not permitted", parent, invalid)
+			return fmt.Errorf("%w: Command name %s contains an invalid character, any of%s or space is not permitted", ErrInvalidCommand, parent, invalid)
 		}
 
 		if strings.ContainsAny(cname, invalid) {
-			return fmt.Errorf("Command name %s contains an invalid character, any of%s or space is not permitted", cname, invalid)
+			return fmt.Errorf("%w: Command name %s contains an invalid character, any of%s or space is not permitted", ErrInvalidCommand, cname, invalid)
 		}
 
 		newCmd := &cobra.Command{}
 		if argsok {
 			newCmd.Args = cobra.ArbitraryArgs
 		} else {
-			newCmd.Args = cobra.NoArgs
+			newCmd.Args = cfg.noArgs
 		}
 
 		docEntry, ok := userDocs.Usage[k]
 		if !ok {
-			return fmt.Errorf("Cannot find a Usage entry in the docs for command %s", k)
+			return fmt.Errorf("%w: Cannot find a Usage entry in the docs for command %s", ErrMissingDocs, k)
 		}
 
 		if docEntry.Use == "" {
@@ -1040,7 +1486,7 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 		cfg.Tracef("Setting %v to %v", k, v)
 		if v != nil {
 			newCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
-				return runWrapper(cmd, cfg, v, args)
+				return runWrapper(cmd, cfg, v, args, true)
 			}
 		} else {
 			cfg.Tracef("Was nil")
@@ -1053,7 +1499,7 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 			newCmd.RunE = func(cmd *cobra.Command, args []string) (err error) {
 				return runWrapper(cmd, cfg, func(Config, []string) error {
 					return cmd.Help()
-				}, args)
+				}, args, false)
 			}
 		}
 		cfg.s_cmds[k] = newCmd
@@ -1070,7 +1516,7 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 		pcmd.AddCommand(cfg.s_cmds[b.c])
 	}
 
-	additional, err := createBindings(cfg.TraceSkipf, icfg, cfg.s_v, cfg.s_cmds, cfg.s_env, defaultDoc.CmdLine, cfg.s_ucAppName, seenFields)
+	additional, err := createBindings(cfg.TraceSkipf, icfg, cfg.s_bindings, cfg.s_cmds, cfg.s_env, defaultDoc.CmdLine, cfg.s_ucAppName, seenFields)
 	if err != nil {
 		return err
 	}
@@ -1082,24 +1528,47 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 	// We are giving errors back to the user to do as they see fit, so do not
 	// double-print them via cobra.
 	rootCmd.SilenceErrors = true
+	rootCmd.SetFlagErrorFunc(cfg.flagError)
 
 	// This is required because cobra does not expose [flags] but hardcodes it
 	// both for help and usage. First make sure we save if help was requested
 	// by the user.
 	helpRequested := false
+	var unknownErr error
 	defaultHelpFunc := rootCmd.HelpFunc()
 	rootCmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		// Cobra shows the help for commands without a handler when they are
+		// passed extra arguments, report the likely mistyped subcommands
+		// instead.
+		if extra := cmd.Flags().Args(); len(extra) != 0 && !cmd.Runnable() && !cmd.Flags().Changed("help") {
+			if unknownErr = cfg.mistypedCmd(cmd, extra[0]); unknownErr != nil {
+				return
+			}
+		}
+
 		helpRequested = true
 		defaultHelpFunc(cmd, args)
 	})
 
+	// Keep the default values, as set by the configuration constructor, for
+	// config diff.
+	cfg.s_defaults, _ = copyConfig(icfg)
+
 	rootCmd.SetOutput(cfg.s_cobrabuf)
 	rootCmd.SilenceUsage = true
 	execCmd, err := rootCmd.ExecuteC()
+	if err == nil && unknownErr != nil {
+		err = unknownErr
+	}
 
 	// Now make sure we massage any help/usage output so we can localize
 	// [flags] as needed.
-	if err != nil {
+	if err != nil && cfg.errorFormat(execArgs) == "json" {
+		// Other programs reading the error would not want the usage
+		if werr := writeJSONError(cfg.Err(), err); werr == nil {
+			cfg.s_errorWritten = true
+		}
+	} else if err != nil {
 		// Cobra seems to use a temporary output buffer in UsageString, so we
 		// can't get to it same way we do for HelpFunc below, given this
 		// by default we disable usage and instead print it out ourselves in
@@ -1107,7 +1576,7 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 		usage := execCmd.UsageString()
 		fl := fixMagicTemplate(usage, userDocs.CmdFlags, defaultDoc.CmdFlags)
 		for _, s := range fl {
-			fmt.Fprintf(os.Stderr, "%s\n", s)
+			fmt.Fprintf(cfg.Err(), "%s\n", s)
 		}
 	} else {
 		if helpRequested {
@@ -1115,12 +1584,12 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 			// before printing it out (to stdout)
 			fl := fixMagicTemplate(cfg.s_cobrabuf.String(), userDocs.CmdFlags, defaultDoc.CmdFlags)
 			for _, s := range fl {
-				fmt.Printf("%s\n", s)
+				fmt.Fprintf(cfg.Out(), "%s\n", s)
 			}
 		} else {
 			// If no help was requested assume this was a cobra error and just
 			// print it out.
-			_, err = io.Copy(os.Stderr, cfg.s_cobrabuf)
+			_, err = io.Copy(cfg.Err(), cfg.s_cobrabuf)
 			if err != nil {
 				// Should not happen
 				return err
@@ -1133,6 +1602,8 @@ func (cfg *BaseConfig) Execute(icfg Config, userDocList map[string]*DocSet) erro
 // Cleanup should be called at the end of the program, it will close and
 // remove any temporary files, and sync the log.
 func (cfg *BaseConfig) Cleanup() {
+	cfg.stopWatching()
+
 	for _, f := range cfg.s_filesToClose {
 		if err := f.Close(); err != nil {
 			cfg.Errorf("Cannot close %v (%s)\n", f.Name(), err.Error())
@@ -1345,8 +1816,8 @@ func (cfg *BaseConfig) Errors(s string, vals ...LogField) {
 // The trace logger does not support structured logging, only pretty console
 // logging, so no need to have Traces
 
-// Trace is used to debug log to the trace logger, this is a separate stderr
-// non-redirectable / non-configurable logger
+// Trace is used to debug log to the trace logger, this is a separate logger
+// writing to the output writer, not affected by the log file or level
 func (cfg *BaseConfig) Trace(s string) {
 	if cfg.s_tracing && cfg.s_trace != nil {
 		cfg.s_trace.DebugStructured(s)
@@ -51,6 +51,12 @@ ofay ethay ogrampray ependingday onay ommandcay inelay argumentsay:`,
 	"Onay ariablesvay",
 	greenery.DocConfigEnvMsg3,
 	"Ethay ollowingfay environmentay ariablesvay areay availableay orfay isthay ogrampray:",
+	greenery.DocDidYouMean,
+	"idday ouyay eanmay %s?",
+	greenery.DocPromptAborted,
+	"Interactiveay onfigurationcay aborteday",
+	greenery.DocPromptInvalid,
+	"%s. Easeplay ytray againay",
 	greenery.DocBaseDelimiter,
 
 	// General help template strings
@@ -105,6 +111,58 @@ Implysay ypetay appnameay elphay [path otay ommandcay] orfay ullfay etailsday.`,
 	`Howsay hetay urrentcay onfigurationcay aluesvay akingtay intoay accountay allay environmentay
 "anday onfigurationcay ilefay values. Command-line agsflay invaliday orfay hetay onfigcay isplayday
 "ommandcay ouldway otnay ebay displayed`,
+	"",
+	greenery.DocConfigProfilesCmd,
+	"",
+	"Istslay hetay onfigurationcay ofilespray",
+	`Istslay hetay ofilespray availableay inay hetay onfigurationcay ilefay, hetay activeay ofilepray,
+ifay anyay, isay arkedmay ithway anay asteriskay`,
+	"",
+	greenery.DocConfigGetCmd,
+	"[ectionsay.eykay]",
+	"Intspray hetay aluevay ofay aay onfigurationcay ariablevay",
+	`Intspray hetay urrentcay aluevay ofay hetay onfigurationcay ariablevay, akingtay intoay accountay
+allay environmentay anday onfigurationcay ilefay aluesvay. Ecretsay aluesvay illway ebay askedmay
+unlessay --reveal isay ecifiedspay`,
+	"",
+	greenery.DocConfigSetCmd,
+	"[ectionsay.eykay] [aluevay]",
+	"Etssay aay onfigurationcay ariablevay inay hetay onfigurationcay ilefay",
+	`Etssay hetay onfigurationcay ariablevay otay hetay aluevay inay hetay onfigurationcay ilefay hattay
+asway oadedlay, afteray alidatingvay itay. Anyay ommentscay inay hetay ilefay areay eservedpray`,
+	"",
+	greenery.DocConfigUnsetCmd,
+	"[ectionsay.eykay]",
+	"Emovesray aay onfigurationcay ariablevay omfray hetay onfigurationcay ilefay",
+	`Emovesray hetay onfigurationcay ariablevay omfray hetay onfigurationcay ilefay hattay asway
+oadedlay, osay hattay itsay efaultday aluevay illway ebay useday. Anyay ommentscay inay hetay ilefay areay
+eservedpray`,
+	"",
+	greenery.DocConfigDiffCmd,
+	"[otheray-ilefay]",
+	"Owsshay owhay hetay onfigurationcay iffersday omfray hetay efaultsday oray anotheray ilefay",
+	`Owsshay hetay onfigurationcay ariablesvay hosewhay urrentcay aluevay iffersday omfray hetay
+efaultday, oray ifay aay onfigurationcay ilefay isay ecifiedspay, omfray hetay aluevay inay hattay ilefay.
+Ithway --defaults hetay ecifiedspay ilefay isay omparedcay againstay hetay efaultsday insteaday.
+Hetay ommandcay ailsfay ifay anyay ifferencesday areay oundfay`,
+	"",
+	greenery.DocConfigUpgradeCmd,
+	"",
+	"Upgradesay hetay onfigurationcay ilefay addingay anyay ewnay ariablesvay",
+	`Egeneratesray hetay onfigurationcay ilefay hattay asway oadedlay, eepingkay hetay aluesvay itay
+etssay, addingay anyay ewnay ariablesvay ithway heirtay efaultday aluevay anday ocumentationday anday
+ommentingcay outay anyay ariablesvay hattay areay onay longeray useday. Hetay angeschay areay ownshay
+eforebay hetay ilefay isay rittenway, anday hetay eviouspray ilefay isay eptkay asay aay ackupbay, amednay
+afteray hetay ilefay ithway aay .bak uffixsay anday aay umbernay ifay hattay ackupbay alreadyay existsay.
+Ithway --dry-run hetay angeschay areay onlyay ownshay`,
+	"",
+	greenery.DocConfigValidateCmd,
+	"[ilefay]",
+	"Alidatesvay hetay onfigurationcay ilefay eportingray everyay oblempray oundfay",
+	`Eckschay hetay onfigurationcay ilefay hattay ouldway ebay oadedlay, oray hetay ecifiedspay oneay,
+eportingray everyay unknownay ariablevay anday invaliday aluevay inay itay ogethertay ithway itsay
+ositionpay inay hetay ilefay henway availableay. Hetay ommandcay ailsfay ifay anyay oblemspray areay
+oundfay`,
 	"",
 	greenery.DocVersionCmd,
 	"",
@@ -128,6 +186,8 @@ Implysay ypetay appnameay elphay [path otay ommandcay] orfay ullfay etailsday.`,
 	"Hetay oglay evellay ofay hetay ogrampray. Alidvay aluesvay areay \"error\", \"warn\", \"info\" anday \"debug\"",
 	greenery.DocConfFile,
 	"Hetay onfigurationcay ilefay ocationlay",
+	greenery.DocProfile,
+	"Hetay onfigurationcay ofilepray otay useay",
 	greenery.DocLogFile,
 	"Hetay oglay ilefay ocationlay",
 	greenery.DocPretty,
@@ -138,12 +198,30 @@ Implysay ypetay appnameay elphay [path otay ommandcay] orfay ullfay etailsday.`,
 	"Fiay etsay hetay environmentay ariablesvay illway otnay ebay onsideredcay",
 	greenery.DocVerbosity,
 	"Hetay erbosityvay ofay hetay ogrampray, anay integeray etweenbay 0 anday 3 inclusiveay.",
+	greenery.DocErrorFormat,
+	"Hetay ormatfay ofay hetay erroray essagesmay, oneay ofay \"text\" oray \"json\"",
 	greenery.DocDoTrace,
 	"Enablesay acingtray",
 	greenery.DocCfgLocation,
 	"Hereway otay itewray hetay onfigurationcay ilefay, oneay ofay \"cwd\", \"user\" oray \"system\"",
 	greenery.DocCfgForce,
 	"Fiay specified, anyay existingay onfigurationcay ilesfay illway ebay overwrittenay",
+	greenery.DocCfgInteractive,
+	"Fiay specified, hetay onfigurationcay aluesvay illway ebay askeday orfay onay hetay erminaltay eforebay hetay ilefay isay rittenway",
+	greenery.DocCfgFormat,
+	"Hetay ormatfay ofay hetay onfigurationcay ilefay, oneay ofay \"toml\", \"yaml\" oray \"json\"",
+	greenery.DocCfgOutput,
+	"Hetay outputay ormatfay, oneay ofay \"text\", \"json\", \"toml\" oray \"yaml\"",
+	greenery.DocCfgReveal,
+	"Fiay specified, hetay aluesvay ofay ecretsay onfigurationcay ariablesvay illway ebay isplayedday",
+	greenery.DocCfgExport,
+	"Utputoay hetay environmentay ariablesvay asay aay criptsay orfay hetay ellshay, oneay ofay \"bash\", \"zsh\", \"fish\" oray \"powershell\"",
+	greenery.DocCfgAll,
+	"Fiay specified, allay hetay environmentay ariablesvay illway ebay exporteday, otnay onlyay hetay onesay urrentlycay etsay",
+	greenery.DocCfgDryRun,
+	"Fiay specified, hetay angeschay illway ebay ownshay utbay hetay onfigurationcay ilefay illway otnay ebay rittenway",
+	greenery.DocCfgDefaults,
+	"Fiay specified, hetay onfigurationcay ilefay assedpay illway ebay omparedcay againstay hetay efaultsday atherray hantay hetay urrentcay onfigurationcay",
 	// our flag
 	"Timeout",
 	"Hetay imeouttay otay useay orfay hetay ETGay operationay",
@@ -277,14 +355,16 @@ func Example_localized() {
 	//   -t, --timeout int   the timeout, in milliseconds, to use for the fetch (default 400)
 	//
 	// Global Flags:
-	//   -c, --config string      The configuration file location
-	//       --help               help information for the application.
-	//       --log-file string    The log file location
-	//   -l, --log-level string   The log level of the program. Valid values are "error", "warn", "info" and "debug" (default "error")
-	//       --no-cfg             If set no configuration file will be loaded
-	//       --no-env             If set the environment variables will not be considered
-	//       --pretty             If set the console output of the logging calls will be prettified
-	//   -v, --verbosity int      The verbosity of the program, an integer between 0 and 3 inclusive. (default 1)
+	//   -c, --config string         The configuration file location
+	//       --error-format string   The format of the error messages, one of "text" or "json" (default "text")
+	//       --help                  help information for the application.
+	//       --log-file string       The log file location
+	//   -l, --log-level string      The log level of the program. Valid values are "error", "warn", "info" and "debug" (default "error")
+	//       --no-cfg                If set no configuration file will be loaded
+	//       --no-env                If set the environment variables will not be considered
+	//       --pretty                If set the console output of the logging calls will be prettified
+	//       --profile string        The configuration profile to use
+	//   -v, --verbosity int         The verbosity of the program, an integer between 0 and 3 inclusive. (default 1)
 	//
 	// Default command output
 	//
@@ -307,14 +387,16 @@ func Example_localized() {
 	//   -t, --timeout int   Hetay imeouttay otay useay orfay hetay ETGay operationay (default 400)
 	//
 	// Lobalgay Lagsfay:
-	//   -c, --config string      Hetay onfigurationcay ilefay ocationlay
-	//       --help               Elphay informationay orfay ethay applicationay.
-	//       --log-file string    Hetay oglay ilefay ocationlay
-	//   -l, --log-level string   Hetay oglay evellay ofay hetay ogrampray. Alidvay aluesvay areay "error", "warn", "info" anday "debug" (default "error")
-	//       --no-cfg             Fiay etsay onay onfigurationay ilefay illway ebay oadedlay
-	//       --no-env             Fiay etsay hetay environmentay ariablesvay illway otnay ebay onsideredcay
-	//       --pretty             Fiay etsay hetay onsolecay outputay ofay hetay ogginglay allscay illway ebay ettifiedpray
-	//   -v, --verbosity int      Hetay erbosityvay ofay hetay ogrampray, anay integeray etweenbay 0 anday 3 inclusiveay. (default 1)
+	//   -c, --config string         Hetay onfigurationcay ilefay ocationlay
+	//       --error-format string   Hetay ormatfay ofay hetay erroray essagesmay, oneay ofay "text" oray "json" (default "text")
+	//       --help                  Elphay informationay orfay ethay applicationay.
+	//       --log-file string       Hetay oglay ilefay ocationlay
+	//   -l, --log-level string      Hetay oglay evellay ofay hetay ogrampray. Alidvay aluesvay areay "error", "warn", "info" anday "debug" (default "error")
+	//       --no-cfg                Fiay etsay onay onfigurationay ilefay illway ebay oadedlay
+	//       --no-env                Fiay etsay hetay environmentay ariablesvay illway otnay ebay onsideredcay
+	//       --pretty                Fiay etsay hetay onsolecay outputay ofay hetay ogginglay allscay illway ebay ettifiedpray
+	//       --profile string        Hetay onfigurationcay ofilepray otay useay
+	//   -v, --verbosity int         Hetay erbosityvay ofay hetay ogrampray, anay integeray etweenbay 0 anday 3 inclusiveay. (default 1)
 	//
 	// Localized command output
 	//
@@ -17,6 +17,9 @@ var docsetStruct = &DocSet{
 	ConfigEnvMsg1: "ConfigEnvMsg1",
 	ConfigEnvMsg2: "ConfigEnvMsg2",
 	ConfigEnvMsg3: "ConfigEnvMsg3",
+	DidYouMean:    "DidYouMean",
+	PromptAborted: "PromptAborted",
+	PromptInvalid: "PromptInvalid",
 	Help: &HelpStrings{
 		Usage:                                "HUsage",
 		Aliases:                              "HAliases",
@@ -75,6 +78,12 @@ var baseSection = []string{
 	"ConfigEnvMsg2",
 	DocConfigEnvMsg3,
 	"ConfigEnvMsg3",
+	DocDidYouMean,
+	"DidYouMean",
+	DocPromptAborted,
+	"PromptAborted",
+	DocPromptInvalid,
+	"PromptInvalid",
 	DocBaseDelimiter,
 }
 
@@ -395,7 +404,7 @@ func TestConvertDocset(t *testing.T) {
 				baseSection[:len(baseSection)-1],
 				[]string{DocUse, "Use", DocBaseDelimiter},
 			),
-			Error: "duplicate Use declaration at line 20 (previously line 2)",
+			Error: "duplicate Use declaration at line 26 (previously line 2)",
 		},
 		docTest{
 			Name: "Duplicate base 2",
@@ -404,7 +413,7 @@ func TestConvertDocset(t *testing.T) {
 				baseSection[:len(baseSection)-1],
 				[]string{DocShort, "Short", DocBaseDelimiter},
 			),
-			Error: "duplicate Short declaration at line 20 (previously line 4)",
+			Error: "duplicate Short declaration at line 26 (previously line 4)",
 		},
 		docTest{
 			Name: "Duplicate base 3",
@@ -413,7 +422,7 @@ func TestConvertDocset(t *testing.T) {
 				baseSection[:len(baseSection)-1],
 				[]string{DocLong, "Long", DocBaseDelimiter},
 			),
-			Error: "duplicate Long declaration at line 20 (previously line 6)",
+			Error: "duplicate Long declaration at line 26 (previously line 6)",
 		},
 		docTest{
 			Name: "Duplicate base 4",
@@ -422,7 +431,7 @@ func TestConvertDocset(t *testing.T) {
 				baseSection[:len(baseSection)-1],
 				[]string{DocExample, "Example", DocBaseDelimiter},
 			),
-			Error: "duplicate Example declaration at line 20 (previously line 8)",
+			Error: "duplicate Example declaration at line 26 (previously line 8)",
 		},
 		docTest{
 			Name: "Duplicate base 5",
@@ -431,7 +440,7 @@ func TestConvertDocset(t *testing.T) {
 				baseSection[:len(baseSection)-1],
 				[]string{DocHelpFlag, "HelpFlag", DocBaseDelimiter},
 			),
-			Error: "duplicate HelpFlag declaration at line 20 (previously line 10)",
+			Error: "duplicate HelpFlag declaration at line 26 (previously line 10)",
 		},
 		docTest{
 			Name: "Duplicate base 6",
@@ -440,7 +449,7 @@ func TestConvertDocset(t *testing.T) {
 				baseSection[:len(baseSection)-1],
 				[]string{DocConfigEnvMsg1, "ConfigEnvMsg1", DocBaseDelimiter},
 			),
-			Error: "duplicate ConfigEnvMsg1 declaration at line 20 (previously line 14)",
+			Error: "duplicate ConfigEnvMsg1 declaration at line 26 (previously line 14)",
 		},
 		docTest{
 			Name: "Duplicate base 7",
@@ -449,7 +458,7 @@ func TestConvertDocset(t *testing.T) {
 				baseSection[:len(baseSection)-1],
 				[]string{DocConfigEnvMsg2, "ConfigEnvMsg2", DocBaseDelimiter},
 			),
-			Error: "duplicate ConfigEnvMsg2 declaration at line 20 (previously line 16)",
+			Error: "duplicate ConfigEnvMsg2 declaration at line 26 (previously line 16)",
 		},
 		docTest{
 			Name: "Duplicate base 8",
@@ -458,7 +467,34 @@ func TestConvertDocset(t *testing.T) {
 				baseSection[:len(baseSection)-1],
 				[]string{DocConfigEnvMsg3, "ConfigEnvMsg3", DocBaseDelimiter},
 			),
-			Error: "duplicate ConfigEnvMsg3 declaration at line 20 (previously line 18)",
+			Error: "duplicate ConfigEnvMsg3 declaration at line 26 (previously line 18)",
+		},
+		docTest{
+			Name: "Duplicate base 9",
+			Strings: appender(
+				[]string{"1.0"},
+				baseSection[:len(baseSection)-1],
+				[]string{DocDidYouMean, "DidYouMean", DocBaseDelimiter},
+			),
+			Error: "duplicate DidYouMean declaration at line 26 (previously line 20)",
+		},
+		docTest{
+			Name: "Duplicate base 10",
+			Strings: appender(
+				[]string{"1.0"},
+				baseSection[:len(baseSection)-1],
+				[]string{DocPromptAborted, "PromptAborted", DocBaseDelimiter},
+			),
+			Error: "duplicate PromptAborted declaration at line 26 (previously line 22)",
+		},
+		docTest{
+			Name: "Duplicate base 11",
+			Strings: appender(
+				[]string{"1.0"},
+				baseSection[:len(baseSection)-1],
+				[]string{DocPromptInvalid, "PromptInvalid", DocBaseDelimiter},
+			),
+			Error: "duplicate PromptInvalid declaration at line 26 (previously line 24)",
 		},
 		docTest{
 			Name: "Duplicate base 3",
@@ -467,7 +503,7 @@ func TestConvertDocset(t *testing.T) {
 				baseSection[:len(baseSection)-1],
 				[]string{DocCmdFlags, "CmdFlags", DocBaseDelimiter},
 			),
-			Error: "duplicate CmdFlags declaration at line 20 (previously line 12)",
+			Error: "duplicate CmdFlags declaration at line 26 (previously line 12)",
 		},
 		docTest{
 			Name: "Duplicate help 1",
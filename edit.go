@@ -0,0 +1,398 @@
+package greenery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// cfgField is a configuration struct field together with the value of the
+// struct containing it, which is either the user or the base struct.
+type cfgField struct {
+	x reflect.StructField
+	v reflect.Value
+}
+
+// keyFields returns the fields corresponding to the passed configuration
+// file variable, in section.key form or just key for the root section, as
+// well as its section and key as they are spelled in the tags. Custom
+// variables are not included given they have no value of their own.
+func (cfg *BaseConfig) keyFields(icfg Config, key string) (fields []cfgField, parent, child string, err error) {
+	helper := func(x reflect.StructField, v reflect.Value) {
+		cobra, vipername, _, _ := parseTags(x)
+		if vipername == "" || strings.HasSuffix(cobra, sepCmdParts+"custom") ||
+			!strings.EqualFold(vipername, key) {
+			return
+		}
+
+		fields = append(fields, cfgField{x: x, v: v})
+		parent, child = splitKey(vipername)
+	}
+
+	t := reflect.TypeOf(icfg).Elem()
+	v := reflect.ValueOf(icfg).Elem()
+	tfields, _ := structFields(t)
+	for _, x := range tfields {
+		if x.Type == basePType {
+			for i2 := 0; i2 < baseType.NumField(); i2++ {
+				helper(baseType.Field(i2), v.FieldByIndex(x.Index).Elem())
+			}
+		} else {
+			helper(x, v)
+		}
+	}
+
+	if len(fields) == 0 {
+		err = fmt.Errorf("Unknown configuration variable %s", key)
+	}
+	return
+}
+
+// editedCfgFile returns the configuration file that config set and config
+// unset will modify, which is the one that was loaded.
+func (cfg *BaseConfig) editedCfgFile() (string, error) {
+	if !cfg.s_loaded || cfg.s_usedConf == "" {
+		return "", fmt.Errorf("No configuration file was loaded, create one with config init first")
+	}
+	return cfg.s_usedConf, nil
+}
+
+// editCfgFile sets the passed configuration variable to value, or removes it
+// if value is nil, in the passed configuration file. Value is expected to be
+// serialized as it would be in a generated file of the same format, see
+// lineValue. The file is modified in place, so any comments in it are
+// preserved.
+func (cfg *BaseConfig) editCfgFile(name, parent, child string, value *string) error {
+	fi, err := cfg.s_fs.Stat(name)
+	if err != nil {
+		return fmt.Errorf("Cannot access the config file %s: %w", name, err)
+	}
+
+	contents, err := afero.ReadFile(cfg.s_fs, name)
+	if err != nil {
+		return fmt.Errorf("Cannot read the config file %s: %w", name, err)
+	}
+
+	key := child
+	if parent != "" {
+		key = parent + sepKeyParts + child
+	}
+
+	format := cfgFormat(name)
+	if format == "json" {
+		contents, err = editJSON(contents, parent, child, value)
+		if err != nil {
+			return fmt.Errorf("%w %s: %v", ErrCfgParse, name, err)
+		}
+	} else {
+		lines := strings.Split(string(contents), "\n")
+		var found bool
+		if lines, found = editLines(lines, format, parent, child, value); !found {
+			return fmt.Errorf("Configuration variable %s is not set in %s", key, name)
+		}
+		contents = []byte(strings.Join(lines, "\n"))
+	}
+
+	cfg.Tracef("Writing the edited config file %s", name)
+	if err = afero.WriteFile(cfg.s_fs, name, contents, fi.Mode()); err != nil {
+		return fmt.Errorf("Cannot write the config file %s: %w", name, err)
+	}
+	return nil
+}
+
+// editJSON sets, or removes if value is nil, the passed variable in the
+// passed JSON configuration file contents. JSON files have no comments to
+// preserve, so the file is simply parsed and serialized again.
+func editJSON(contents []byte, parent, child string, value *string) ([]byte, error) {
+	settings := map[string]interface{}{}
+	d := json.NewDecoder(bytes.NewReader(contents))
+	d.UseNumber()
+	if err := d.Decode(&settings); err != nil {
+		return nil, err
+	}
+
+	// Keys are case insensitive, as they are for viper
+	lookup := func(m map[string]interface{}, k string) string {
+		for mk := range m {
+			if strings.EqualFold(mk, k) {
+				return mk
+			}
+		}
+		return k
+	}
+
+	section := settings
+	for _, part := range sectionParts(parent) {
+		p := lookup(section, part)
+		if s, ok := section[p].(map[string]interface{}); ok {
+			section = s
+		} else {
+			if value == nil {
+				return nil, fmt.Errorf("Configuration variable %s.%s is not set", parent, child)
+			}
+			s = map[string]interface{}{}
+			section[p] = s
+			section = s
+		}
+	}
+
+	c := lookup(section, child)
+	if value == nil {
+		if _, ok := section[c]; !ok {
+			if parent != "" {
+				return nil, fmt.Errorf("Configuration variable %s.%s is not set", parent, child)
+			}
+			return nil, fmt.Errorf("Configuration variable %s is not set", child)
+		}
+		delete(section, c)
+	} else {
+		var v interface{}
+		vd := json.NewDecoder(strings.NewReader(jsonValue(*value)))
+		vd.UseNumber()
+		if err := vd.Decode(&v); err != nil {
+			// Should not happen, jsonValue returns valid JSON
+			return nil, err
+		}
+		section[c] = v
+	}
+
+	b, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		// Should not happen, everything was just parsed from JSON
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+var (
+	tomlSectionRe = regexp.MustCompile(`^\s*\[\s*"?([^\[\]"]+?)"?\s*\]\s*(#.*)?$`)
+	tomlKeyRe     = regexp.MustCompile(`^(\s*)(#\s*)?"?([A-Za-z0-9_-]+)"?\s*=`)
+	yamlSectionRe = regexp.MustCompile(`^(\s*)(#\s*)?([A-Za-z0-9_-]+):\s*(#.*)?$`)
+	yamlKeyRe     = regexp.MustCompile(`^(\s*)(#\s*)?([A-Za-z0-9_-]+):(\s|$)`)
+)
+
+// splitComment splits a configuration file value from its trailing comment,
+// if any. A comment starts with a # outside of quotes preceded by a space.
+func splitComment(s string) (string, string) {
+	var quote rune
+	var escaped bool
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimRight(s[:i], " \t"), s[i:]
+		}
+	}
+	return strings.TrimRight(s, " \t"), ""
+}
+
+// editLines sets, or removes if value is nil, the passed variable in the
+// lines of a TOML or YAML configuration file, returning whether the
+// variable could be found when removing it. An existing value is replaced,
+// keeping any comment after it, otherwise a commented out value (as written
+// for secrets by config init) is uncommented, and failing that the value is
+// added at the end of its section, creating the section if needed. Nested
+// YAML sections are found via their indentation. Values spanning multiple
+// lines are not supported.
+func editLines(lines []string, format, parent, child string, value *string) ([]string, bool) {
+	sep := " = "
+	keyRe := tomlKeyRe
+	if format == "yaml" {
+		sep = ": "
+		keyRe = yamlKeyRe
+	}
+
+	// The YAML sections containing the current line, with their indentation
+	type yamlSection struct {
+		indent int
+		name   string
+	}
+	var open []yamlSection
+	closeSections := func(indent int) string {
+		for len(open) > 0 && open[len(open)-1].indent >= indent {
+			open = open[:len(open)-1]
+		}
+
+		names := make([]string, 0, len(open))
+		for _, o := range open {
+			names = append(names, o.name)
+		}
+		return strings.Join(names, sepKeyParts)
+	}
+
+	// The header line of each section, and the last line in each section
+	// including its nested ones, by lowercase section name.
+	headers := map[string]int{}
+	commentedHeaders := map[string]bool{}
+	ends := map[string]int{}
+	var section string
+	markEnd := func(i int) {
+		parts := sectionParts(strings.ToLower(section))
+		for n := range parts {
+			ends[strings.Join(parts[:n+1], sepKeyParts)] = i
+		}
+	}
+
+	header, firstHeader, last, active, commented := -1, -1, -1, -1, -1
+	indent := ""
+	if format == "yaml" {
+		indent = strings.Repeat("  ", len(sectionParts(parent)))
+	}
+
+	for i, l := range lines {
+		if format == "yaml" {
+			if m := yamlSectionRe.FindStringSubmatch(l); m != nil {
+				// Sections containing only commented out values have
+				// their header commented out as well
+				section = closeSections(len(m[1]))
+				open = append(open, yamlSection{indent: len(m[1]), name: m[3]})
+				section = cfgKey(section, m[3])
+				if firstHeader == -1 {
+					firstHeader = i
+				}
+				headers[strings.ToLower(section)] = i
+				commentedHeaders[strings.ToLower(section)] = m[2] != ""
+				markEnd(i)
+				if strings.EqualFold(section, parent) {
+					header, last = i, i
+				}
+				continue
+			}
+		} else if m := tomlSectionRe.FindStringSubmatch(l); m != nil {
+			section = m[1]
+			if firstHeader == -1 {
+				firstHeader = i
+			}
+			headers[strings.ToLower(section)] = i
+			markEnd(i)
+			if strings.EqualFold(section, parent) {
+				header, last = i, i
+			}
+			continue
+		}
+
+		m := keyRe.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		if format == "yaml" {
+			if m[2] == "" {
+				// Values are in the innermost section they are indented in
+				section = closeSections(len(m[1]))
+			}
+			if (len(open) == 0 && m[1] != "") || (len(open) != 0 && len(m[1]) <= open[len(open)-1].indent) {
+				continue
+			}
+		}
+
+		markEnd(i)
+		if !strings.EqualFold(section, parent) {
+			continue
+		}
+
+		if m[2] == "" {
+			last = i
+			if parent != "" {
+				indent = m[1]
+			}
+		}
+
+		if strings.EqualFold(m[3], child) {
+			if m[2] == "" && active == -1 {
+				active = i
+			} else if m[2] != "" && commented == -1 {
+				commented = i
+			}
+		}
+	}
+
+	if value == nil {
+		if active == -1 {
+			return lines, false
+		}
+		return append(lines[:active], lines[active+1:]...), true
+	}
+
+	// The value is going to be set, so the headers of its section and of the
+	// sections containing it can't be commented out anymore.
+	parts := sectionParts(parent)
+	for n := range parts {
+		p := strings.ToLower(strings.Join(parts[:n+1], sepKeyParts))
+		if h, ok := headers[p]; ok && commentedHeaders[p] {
+			m := yamlSectionRe.FindStringSubmatch(lines[h])
+			lines[h] = m[1] + m[3] + ":"
+		}
+	}
+
+	line := indent + child + sep + *value
+	switch {
+	case active != -1:
+		l := lines[active]
+		s := strings.IndexAny(l, strings.TrimSpace(sep))
+		_, comment := splitComment(l[s+1:])
+		lines[active] = l[:s+1] + " " + *value
+		if comment != "" {
+			lines[active] += " " + comment
+		}
+	case commented != -1:
+		m := keyRe.FindStringSubmatch(lines[commented])
+		lines[commented] = m[1] + child + sep + *value
+	case parent == "" && last != -1:
+		lines = insertLines(lines, last+1, line)
+	case parent == "" && firstHeader != -1:
+		// Before the first section, and its documentation
+		i := firstHeader
+		for i > 0 && strings.HasPrefix(strings.TrimSpace(lines[i-1]), "#") {
+			i--
+		}
+		lines = insertLines(lines, i, line, "")
+	case header != -1:
+		lines = insertLines(lines, last+1, line)
+	default:
+		// Keep the final newline at the end of the file
+		end := len(lines)
+		if end > 0 && lines[end-1] == "" {
+			end--
+		}
+
+		if parent == "" {
+			lines = insertLines(lines, end, line)
+		} else if format == "yaml" {
+			// The missing sections go after the innermost existing one
+			// containing them, if any.
+			at, n, add := end, 0, []string{""}
+			for k := len(parts) - 1; k > 0; k-- {
+				if e, ok := ends[strings.ToLower(strings.Join(parts[:k], sepKeyParts))]; ok {
+					at, n, add = e+1, k, nil
+					break
+				}
+			}
+			for k := n; k < len(parts); k++ {
+				add = append(add, strings.Repeat("  ", k)+parts[k]+":")
+			}
+			lines = insertLines(lines, at, append(add, line)...)
+		} else {
+			lines = insertLines(lines, end, "", "["+parent+"]", line)
+		}
+	}
+	return lines, true
+}
+
+// insertLines inserts the passed lines at index i.
+func insertLines(lines []string, i int, add ...string) []string {
+	return append(lines[:i], append(add, lines[i:]...)...)
+}
@@ -0,0 +1,286 @@
+package greenery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Sentinel errors wrapped by the errors returned by Execute, so that callers
+// can check for them via errors.Is rather than matching the error messages.
+var (
+	// ErrCfgFileNotFound is returned when a configuration file that was
+	// asked for, or included, does not exist
+	ErrCfgFileNotFound = errors.New("Could not load config file")
+
+	// ErrCfgParse is returned when a configuration file cannot be parsed
+	ErrCfgParse = errors.New("Could not parse config file")
+
+	// ErrUnknownKey is returned when a configuration file contains a
+	// variable that is not known to the application
+	ErrUnknownKey = errors.New("Invalid key(s) in the configuration file")
+
+	// ErrInvalidValue is returned when a configuration variable cannot be set
+	// to the passed value, the errors matching it are FieldError ones
+	ErrInvalidValue = errors.New("Invalid configuration value")
+
+	// ErrMissingDocs is returned when the documentation needed for a
+	// command or a command line parameter is missing
+	ErrMissingDocs = errors.New("Cannot load the documentation")
+
+	// ErrInvalidCommand is returned when a command in the handler map has an
+	// invalid name
+	ErrInvalidCommand = errors.New("Invalid command name")
+)
+
+// Sources of the configuration values that could not be set, as reported in
+// FieldError. Invalid flag values are reported by the command line parsing
+// as usage errors before the configuration is loaded, so SourceCmdline is
+// only used for the value passed to the config set command.
+const (
+	SourceCmdline = "cmdline"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+)
+
+// sourceText contains the description of each value source used in the
+// error messages.
+var sourceText = map[string]string{
+	SourceCmdline: "set on the command line",
+	SourceEnv:     "set in the environment",
+	SourceFile:    "set in the configuration file",
+}
+
+// FieldError is the error for a configuration variable whose value could not
+// be set while loading the configuration.
+type FieldError struct {
+	// Field is the name of the configuration struct field
+	Field string
+
+	// Source is where the value came from, one of SourceCmdline, SourceEnv
+	// or SourceFile
+	Source string
+
+	// Err is the error returned while setting the value
+	Err error
+}
+
+// Error returns the error message, including where the value came from
+func (e *FieldError) Error() string {
+	if text, ok := sourceText[e.Source]; ok {
+		return fmt.Sprintf("%v (%s)", e.Err, text)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the error returned while setting the value
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns whether the passed error is ErrInvalidValue
+func (e *FieldError) Is(target error) bool {
+	return target == ErrInvalidValue
+}
+
+// ConfigErrors is returned by Execute when one or more configuration
+// variables could not be set, it contains an error for each of them so that
+// they can all be reported at once rather than one per execution.
+type ConfigErrors struct {
+	Errors []*FieldError
+}
+
+// Error returns the messages of all the contained errors
+func (e *ConfigErrors) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("Found %d invalid configuration values: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Is returns whether the passed error is ErrInvalidValue
+func (e *ConfigErrors) Is(target error) bool {
+	return target == ErrInvalidValue
+}
+
+// As sets the passed target to the first of the contained errors, if it is a
+// **FieldError. Only that error is returned this way, use errors.As with a
+// **ConfigErrors target and its Errors field to get all of them.
+func (e *ConfigErrors) As(target interface{}) bool {
+	if fe, ok := target.(**FieldError); ok && len(e.Errors) != 0 {
+		*fe = e.Errors[0]
+		return true
+	}
+	return false
+}
+
+// add records a new field error
+func (e *ConfigErrors) add(field, source string, err error) {
+	e.Errors = append(e.Errors, &FieldError{Field: field, Source: source, Err: err})
+}
+
+// errorOrNil returns the aggregate error if any errors were recorded, and nil
+// otherwise.
+func (e *ConfigErrors) errorOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Exit codes used by Main, handlers can return errors implementing ExitCoder
+// to use different ones.
+const (
+	ExitOK           = 0
+	ExitHandlerError = 1
+	ExitUsageError   = 2
+	ExitConfigError  = 3
+)
+
+// ExitCoder is implemented by errors that want Main to exit with a specific
+// exit code.
+type ExitCoder interface {
+	ExitCode() int
+}
+
+// usageError is an error caused by the command line passed by the user, like
+// an unknown command or flag, or the wrong number of arguments.
+type usageError struct {
+	err error
+}
+
+// Error returns the error message
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// usageErrorf returns a new usage error with the passed formatted message
+func usageErrorf(format string, a ...interface{}) error {
+	return &usageError{err: fmt.Errorf(format, a...)}
+}
+
+// exitCode returns the exit code corresponding to the passed error
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var ec ExitCoder
+	if errors.As(err, &ec) {
+		return ec.ExitCode()
+	}
+
+	var ue *usageError
+	if errors.As(err, &ue) {
+		return ExitUsageError
+	}
+
+	for _, e := range []error{ErrCfgFileNotFound, ErrCfgParse, ErrUnknownKey, ErrInvalidValue} {
+		if errors.Is(err, e) {
+			return ExitConfigError
+		}
+	}
+	return ExitHandlerError
+}
+
+// jsonError is the representation of an error written when the json error
+// format is requested.
+type jsonError struct {
+	Code    int          `json:"code,omitempty"`
+	Message string       `json:"message"`
+	Field   string       `json:"field,omitempty"`
+	Source  string       `json:"source,omitempty"`
+	Causes  []*jsonError `json:"causes,omitempty"`
+}
+
+// jsonCauses returns the representation of the errors wrapped by the passed
+// one, wrapping errors that do not change the message are skipped.
+func jsonCauses(err error) []*jsonError {
+	var causes []error
+	if ce, ok := err.(*ConfigErrors); ok {
+		if len(ce.Errors) == 1 {
+			return jsonCauses(ce.Errors[0])
+		}
+		for _, fe := range ce.Errors {
+			causes = append(causes, fe)
+		}
+	} else if cause := errors.Unwrap(err); cause != nil {
+		if cause.Error() == err.Error() {
+			return jsonCauses(cause)
+		}
+		causes = append(causes, cause)
+	}
+
+	var jcauses []*jsonError
+	for _, c := range causes {
+		jc := &jsonError{Message: c.Error(), Causes: jsonCauses(c)}
+		if fe, ok := c.(*FieldError); ok {
+			jc.Field, jc.Source = fe.Field, fe.Source
+		}
+		jcauses = append(jcauses, jc)
+	}
+	return jcauses
+}
+
+// writeJSONError writes the passed error as a JSON object on a single line,
+// if the error is about a single configuration variable its field and
+// source are reported as well.
+func writeJSONError(w io.Writer, err error) error {
+	je := &jsonError{Code: exitCode(err), Message: err.Error(), Causes: jsonCauses(err)}
+
+	var ce *ConfigErrors
+	var fe *FieldError
+	if !errors.As(err, &ce) || len(ce.Errors) == 1 {
+		if errors.As(err, &fe) {
+			je.Field, je.Source = fe.Field, fe.Source
+		}
+	}
+
+	b, merr := json.Marshal(je)
+	if merr != nil {
+		// Should not happen
+		return merr
+	}
+	_, werr := fmt.Fprintf(w, "%s\n", b)
+	return werr
+}
+
+// errorFormat returns the format errors should be reported in. Errors can
+// happen before the configuration is loaded, in which case the command line
+// and the environment are looked at directly.
+func (cfg *BaseConfig) errorFormat(args []string) string {
+	if cfg.s_processed {
+		return cfg.ErrorFormat.Value
+	}
+
+	const flag = "--error-format"
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if a == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(a, flag+"=") {
+			return strings.TrimPrefix(a, flag+"=")
+		}
+	}
+
+	if e := os.Getenv(cfg.s_ucAppName + "_ERRORFORMAT"); e != "" {
+		return e
+	}
+	return cfg.ErrorFormat.Value
+}
@@ -0,0 +1,17 @@
+package greenery
+
+import "io"
+
+// SetInteractiveInput makes config init --interactive read its answers from
+// the passed reader as if it was a terminal, a nil reader simulates a
+// non-interactive session. The returned function restores the standard
+// input.
+func SetInteractiveInput(in io.Reader) func() {
+	previous := stdinTerminal
+	stdinTerminal = func() (io.Reader, bool) {
+		return in, in != nil
+	}
+	return func() {
+		stdinTerminal = previous
+	}
+}
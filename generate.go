@@ -1,9 +1,12 @@
 package greenery
 
 import (
+	"bufio"
 	"encoding"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -13,7 +16,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/shibukawa/configdir"
 	"github.com/woodensquares/greenery/internal/doc"
 )
@@ -30,26 +32,30 @@ func commentify(s, comment string) string {
 	return o
 }
 
-func fileName(dname, name, location string) (wanted string, err error) {
+func fileName(dname, name, location, format string) (wanted string, err error) {
+	ext := "." + format
 	if name == "" {
-		name = dname + ".toml"
+		name = dname + ext
+	} else {
+		// The configuration file that was loaded could have been in a
+		// different format, or found in one of the search paths, only its
+		// name matters here.
+		name = filepath.Base(name)
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + ext
 	}
 
-	if !strings.Contains(name, string(os.PathSeparator)) {
-		switch location {
-		case "cwd":
-			wanted, err = os.Getwd()
-			if err != nil {
-				err = errors.WithMessage(err,
-					"Cannot access the current directory")
-				return
-			}
-			wanted = path.Join(wanted, dname+".toml")
-		case "user":
-			wanted = configdir.New(dname, name).QueryFolders(configdir.Global)[0].Path
-		case "system":
-			wanted = configdir.New(dname, name).QueryFolders(configdir.System)[0].Path
+	switch location {
+	case "cwd":
+		wanted, err = os.Getwd()
+		if err != nil {
+			err = fmt.Errorf("Cannot access the current directory: %w", err)
+			return
 		}
+		wanted = path.Join(wanted, dname+ext)
+	case "user":
+		wanted = configdir.New(dname, name).QueryFolders(configdir.Global)[0].Path
+	case "system":
+		wanted = configdir.New(dname, name).QueryFolders(configdir.System)[0].Path
 	}
 
 	return
@@ -59,8 +65,15 @@ type cfgLine struct {
 	parent    string
 	doc       string
 	value     string
+	data      interface{}
 	child     string
 	skipvalue bool
+	base      bool
+	secret    bool
+	commented bool
+	example   bool
+	field     reflect.Value
+	names     []string
 }
 
 var marshalInterface = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
@@ -70,7 +83,7 @@ func serializeHelper(field reflect.Value, extra bool, name string) (string, erro
 	var rv string
 	switch field.Kind() {
 	case reflect.String:
-		rv = "\"" + field.String() + "\""
+		rv = inlineValue(field.String(), "toml")
 	case reflect.Bool:
 		rv = strconv.FormatBool(field.Bool())
 	case reflect.Float32, reflect.Float64:
@@ -89,7 +102,7 @@ func serializeHelper(field reflect.Value, extra bool, name string) (string, erro
 }
 
 func paramHelper(cfg Config, docs, fallback map[string]string, val reflect.Value, x reflect.StructField) (*cfgLine, error) {
-	field := val.FieldByName(x.Name)
+	field := val.FieldByIndex(x.Index)
 	extra := false
 
 	cobra, vipername, _, _ := parseTags(x)
@@ -120,22 +133,14 @@ func paramHelper(cfg Config, docs, fallback map[string]string, val reflect.Value
 		}
 	}
 
-	// All viper tags are parent.child. No support for grandparents yet.
-	vv := strings.Split(vipername, sepKeyParts)
-	var parent, child string
-	switch len(vv) {
-	case 1:
-		parent = ""
-		child = vv[0]
-	case 2:
-		parent = vv[0]
-		child = vv[1]
-	default:
-		// Should not happen, bind should have caught this
-		return nil, fmt.Errorf("Internal error, malformed cfgfile tag %s", vipername)
-	}
+	// All viper tags are parent.child, where parent can be a nested section
+	// itself.
+	parent, child := splitKey(vipername)
 
+	// Strings, lists and tables are kept as they are in data as well, so that
+	// they can be serialized with the quoting rules of each format.
 	var rv string
+	var data interface{}
 	if marshaler.Kind() != reflect.Invalid {
 		res := marshaler.Call([]reflect.Value{})
 		rve := res[1]
@@ -145,34 +150,51 @@ func paramHelper(cfg Config, docs, fallback map[string]string, val reflect.Value
 		}
 		rv = string(res[0].Bytes())
 	} else if stringer.Kind() != reflect.Invalid {
-		rv = "\"" + stringer.Call([]reflect.Value{})[0].String() + "\""
+		data = stringer.Call([]reflect.Value{})[0].String()
+		rv = inlineValue(data, "toml")
 	} else {
 		var err error
 		if field.Kind() == reflect.Slice {
-			rv = "[ "
-			var rve []string
+			items := make([]interface{}, 0, field.Len())
 			for ie := 0; ie < field.Len(); ie++ {
-				var s string
-				if s, err = serializeHelper(field.Index(ie), extra, x.Name); err != nil {
+				if d, ok := field.Index(ie).Interface().(time.Duration); ok {
+					// Readable durations in lists, rather than nanoseconds
+					items = append(items, d.String())
+					continue
+				}
+				if _, err = serializeHelper(field.Index(ie), extra, x.Name); err != nil {
 					// Should not happen, bind should have caught this
-					return nil, errors.WithMessage(err, "in a slice context only base types are supported")
+					return nil, fmt.Errorf("in a slice context only base types are supported: %w", err)
+				}
+				item := field.Index(ie)
+				switch item.Kind() {
+				case reflect.Float32, reflect.Float64:
+					items = append(items, item.Float())
+				default:
+					items = append(items, item.Interface())
 				}
-				rve = append(rve, s)
 			}
-			rv = rv + strings.Join(rve, ", ") + " ]"
+			data = items
+			rv = inlineValue(data, "toml")
+		} else if field.Kind() == reflect.Map {
+			data = field.Interface()
+			rv = inlineValue(data, "toml")
 		} else {
 			if rv, err = serializeHelper(field, extra, x.Name); err != nil {
 				return nil, err
 			}
+			if field.Kind() == reflect.String {
+				data = field.String()
+			}
 		}
 	}
 
 	// If the user did not provide any config file specific docs, use the
 	// cmdline docs as a default. Allow not having config docs for specific
 	// variables if the user so decides.
-	d, ok := docs[x.Name]
+	d, ok := fieldDoc(docs, x.Name)
 	if !ok {
-		d, ok = fallback[x.Name]
+		d, ok = fieldDoc(fallback, x.Name)
 		if !ok {
 			return nil, fmt.Errorf("Config file variable %s has no documentation, set it to empty if it's meant to not have it", x.Name)
 		}
@@ -193,16 +215,96 @@ func paramHelper(cfg Config, docs, fallback map[string]string, val reflect.Value
 		return nil, nil
 	}
 
-	cfg.Tracef("Init: Setting default for %s.%s to %s", parent, child, rv)
+	secret := isSecret(x)
+	cfg.Tracef("Init: Setting default for %s.%s to %s", parent, child, maskValue(secret, rv))
 	return &cfgLine{
 		parent: parent,
 		child:  child,
 		doc:    d,
 		value:  rv,
+		data:   data,
+		secret: secret,
+		field:  field,
+		names:  []string{x.Name},
 	}, nil
 }
 
-func fileContents(cfg Config) (confText string, err error) {
+// fileContents returns the default configuration file contents in the
+// requested format, one of "toml", "yaml" or "json". Note JSON does not
+// support comments, so no documentation will be present in that case.
+func fileContents(cfg Config, format string) (string, error) {
+	return cfgContents(cfg, format, true)
+}
+
+// cfgContents returns the current configuration values as a configuration
+// file in the requested format. If withDocs is not set the documentation and
+// custom blocks are omitted, and the base configuration values are listed
+// before the user ones in each section.
+func cfgContents(cfg Config, format string, withDocs bool) (confText string, err error) {
+	var lines []*cfgLine
+	if lines, err = cfgLines(cfg, withDocs); err != nil {
+		return
+	}
+
+	// Secret values are never written unless they are to be revealed, the
+	// masked values are commented out, or omitted in JSON, so that the output
+	// can be loaded back without setting them to the mask.
+	bcfg, err := getCfg(cfg)
+	if err != nil {
+		// Should never happen given the interface
+		return
+	}
+
+	for _, v := range lines {
+		if v.secret && !bcfg.CfgReveal {
+			v.value = inlineValue(maskedValue, "toml")
+			v.data = maskedValue
+			v.commented = true
+		}
+	}
+
+	return linesContents(cfg, format, withDocs, lines), nil
+}
+
+// linesContents returns the passed configuration lines as a configuration
+// file in the requested format, see cfgContents.
+func linesContents(cfg Config, format string, withDocs bool, lines []*cfgLine) (confText string) {
+	_, d := cfg.GetDocs()
+	docs := d.ConfigFile
+	if t, ok := docs[doc.ConfigHeader]; ok && format != "json" && withDocs {
+		confText = commentify(t, "# ") + "\n"
+	}
+
+	if !withDocs {
+		docs = nil
+		values := make([]*cfgLine, 0, len(lines))
+		for _, v := range lines {
+			if !v.skipvalue {
+				v.doc = ""
+				values = append(values, v)
+			}
+		}
+		lines = values
+	}
+
+	switch format {
+	case "yaml":
+		confText += yamlContents(docs, lines)
+	case "json":
+		confText += jsonContents(lines)
+	default:
+		confText += tomlContents(docs, lines)
+	}
+
+	return
+}
+
+// cfgLines returns the configuration file variables with their current
+// values, sorted by section in the order they appear in a configuration
+// file. If withDocs is not set the base configuration values are listed
+// before the user ones in each section. Fields corresponding to the same
+// configuration file variable are returned as a single line.
+func cfgLines(cfg Config, withDocs bool) (lines []*cfgLine, err error) {
 	// Add everything to the template, we are interested only in viper
 	// fields here, so only anything with a viper tag set. All default values
 	// are already set by cfg.Initialize()
@@ -212,16 +314,16 @@ func fileContents(cfg Config) (confText string, err error) {
 	_, d := cfg.GetDocs()
 	docs := d.ConfigFile
 	fallback := d.CmdLine
-	if t, ok := docs[doc.ConfigHeader]; ok {
-		confText = commentify(t, "# ") + "\n"
+
+	fields, err := structFields(t)
+	if err != nil {
+		return
 	}
 
 	cfgVars := make([]*cfgLine, 0)
-	for i := 0; i < val.NumField(); i++ {
-		x := t.Field(i)
-
+	for _, x := range fields {
 		if x.Type == basePType {
-			v2 := val.Field(i).Elem()
+			v2 := val.FieldByIndex(x.Index).Elem()
 			for i2 := 0; i2 < baseType.NumField(); i2++ {
 				x2 := baseType.Field(i2)
 
@@ -232,12 +334,22 @@ func fileContents(cfg Config) (confText string, err error) {
 				}
 
 				if n != nil {
+					n.base = true
 					cfgVars = append(cfgVars, n)
 				}
 			}
 			continue
 		}
 
+		if isMapCfg(x) {
+			var instances []*cfgLine
+			if instances, err = mapLines(cfg, docs, fallback, x, val.FieldByIndex(x.Index), withDocs); err != nil {
+				return
+			}
+			cfgVars = append(cfgVars, instances...)
+			continue
+		}
+
 		var n *cfgLine
 		if n, err = paramHelper(cfg, docs, fallback, val, x); err != nil {
 			return
@@ -249,8 +361,9 @@ func fileContents(cfg Config) (confText string, err error) {
 	}
 
 	sort.Slice(cfgVars, func(l, r int) bool {
-		// Root section variables should always come first no matter what
-		switch strings.Compare(cfgVars[l].parent, cfgVars[r].parent) {
+		// Root section variables should always come first no matter what,
+		// nested sections follow their parent.
+		switch compareSections(cfgVars[l].parent, cfgVars[r].parent) {
 		case -1:
 			if cfgVars[r].parent == doc.ConfigHeader {
 				return false
@@ -263,6 +376,10 @@ func fileContents(cfg Config) (confText string, err error) {
 			return false
 		}
 
+		if !withDocs && cfgVars[l].base != cfgVars[r].base {
+			return cfgVars[l].base
+		}
+
 		// skipvalue always come last, as they usually are blocks of
 		// documentation / sample
 		if cfgVars[l].skipvalue && !cfgVars[r].skipvalue {
@@ -280,9 +397,10 @@ func fileContents(cfg Config) (confText string, err error) {
 		return true
 	})
 
-	var cparent string
 	seen := map[string]string{}
 	seenDoc := map[string]string{}
+	seenLine := map[string]*cfgLine{}
+	lines = make([]*cfgLine, 0, len(cfgVars))
 	for _, v := range cfgVars {
 		cand := v.parent + "!" + v.child
 		if pValue, next := seen[cand]; next {
@@ -295,48 +413,365 @@ func fileContents(cfg Config) (confText string, err error) {
 				err = fmt.Errorf("More than one configuration variable corresponds to config file variable %s with different doc lines: \"%s\" and \"%s\" for example", v.parent+"."+v.child, v.doc, seenDoc[cand])
 				return
 			}
+			seenLine[cand].names = append(seenLine[cand].names, v.names...)
 			continue
 		}
 		seen[cand] = v.value
 		seenDoc[cand] = v.doc
+		seenLine[cand] = v
+		lines = append(lines, v)
+	}
+
+	return
+}
+
+// exampleInstance is the name of the commented out instance written for map
+// configuration fields.
+const exampleInstance = "example"
+
+// mapLines returns the configuration lines for the instances of the passed
+// map configuration field. If withDocs is set these are followed by the lines
+// of a commented out example instance, documenting the instance variables.
+func mapLines(cfg Config, docs, fallback map[string]string, x reflect.StructField,
+	field reflect.Value, withDocs bool) ([]*cfgLine, error) {
+	keys := field.MapKeys()
+	sort.Slice(keys, func(l, r int) bool { return keys[l].String() < keys[r].String() })
+
+	// Fields need to be addressable to be serialized
+	names := make([]string, 0, len(keys)+1)
+	instances := map[string]reflect.Value{}
+	for _, k := range keys {
+		iv := reflect.New(field.Type().Elem()).Elem()
+		iv.Set(field.MapIndex(k))
+		names = append(names, k.String())
+		instances[k.String()] = iv
+	}
+
+	example := false
+	if _, ok := instances[exampleInstance]; withDocs && !ok {
+		example = true
+		names = append(names, exampleInstance)
+		instances[exampleInstance] = reflect.New(field.Type().Elem()).Elem()
+	}
+
+	var lines []*cfgLine
+	for _, name := range names {
+		prefix := x.Name + sepKeyParts + name + sepKeyParts
+		for _, ix := range instanceFields(x, name) {
+			// Instance variables are documented once for all the instances
+			ix.Name = x.Name + sepKeyParts + strings.TrimPrefix(ix.Name, prefix)
+			n, err := paramHelper(cfg, docs, fallback, instances[name], ix)
+			if err != nil {
+				return nil, err
+			}
 
+			if n != nil {
+				// Instances cannot be set by field name
+				n.names = nil
+				if example && name == exampleInstance {
+					n.commented = true
+					n.example = true
+				}
+				lines = append(lines, n)
+			}
+		}
+	}
+	return lines, nil
+}
+
+// sectionParts returns the names of the nested sections in the passed
+// section, the root section has none.
+func sectionParts(section string) []string {
+	if section == "" {
+		return nil
+	}
+	return strings.Split(section, sepKeyParts)
+}
+
+// compareSections compares the passed sections name by name, so that nested
+// sections sort right after their parent one.
+func compareSections(l, r string) int {
+	lp, rp := sectionParts(l), sectionParts(r)
+	for i := 0; i < len(lp) && i < len(rp); i++ {
+		if c := strings.Compare(lp[i], rp[i]); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(lp) < len(rp):
+		return -1
+	case len(lp) > len(rp):
+		return 1
+	}
+	return 0
+}
+
+// tomlContents returns the TOML representation of the passed configuration
+// lines, which are expected to be sorted by section. Nested sections are
+// written as dotted tables, any parent section without variables of its own
+// is written only if it is documented. The header of any section containing
+// only example instances is commented out, so that they are not loaded.
+func tomlContents(docs map[string]string, lines []*cfgLine) (confText string) {
+	examples := map[string]bool{}
+	live := map[string]bool{}
+	for _, v := range lines {
+		parts := sectionParts(v.parent)
+		for i := range parts {
+			if v.example {
+				examples[strings.Join(parts[:i+1], sepKeyParts)] = true
+			} else {
+				live[strings.Join(parts[:i+1], sepKeyParts)] = true
+			}
+		}
+	}
+
+	var cparent string
+	written := map[string]bool{}
+	for _, v := range lines {
 		if cparent != v.parent {
 			cparent = v.parent
 
-			confText += "\n"
-			if t, ok := docs[cparent+sepKeyParts]; ok {
-				confText += commentify(t, "# ")
-			}
+			parts := sectionParts(cparent)
+			for i := range parts {
+				section := strings.Join(parts[:i+1], sepKeyParts)
+				t, ok := docs[section+sepKeyParts]
+				if written[section] || (!ok && section != cparent) {
+					continue
+				}
+				written[section] = true
 
-			confText += "[" + cparent + "]\n"
+				confText += "\n"
+				if ok {
+					confText += commentify(t, "# ")
+				}
+				if examples[section] && !live[section] {
+					confText += "# "
+				}
+				confText += "[" + section + "]\n"
+			}
 		}
 
 		if v.skipvalue {
 			confText += commentify(v.doc, "")
 		} else {
 			confText += commentify(v.doc, "# ")
-			confText += v.child + " = " + v.value + "\n"
+			if v.commented {
+				confText += "# "
+			}
+			confText += v.child + " = " + lineValue(v, "toml") + "\n"
+		}
+	}
+
+	return
+}
+
+// lineValue returns the value of the passed configuration line in the passed
+// format. Strings, lists and tables are serialized with the quoting rules of
+// the format, other values are written the same way for all of them.
+func lineValue(v *cfgLine, format string) string {
+	if v.data == nil {
+		return v.value
+	}
+	switch rv := reflect.ValueOf(v.data); rv.Kind() {
+	case reflect.Map:
+		if rv.Len() == 0 {
+			return "{}"
+		}
+	case reflect.Slice:
+		// Lists are spaced out the same way in all formats
+		items := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, inlineValue(rv.Index(i).Interface(), format))
+		}
+		return "[ " + strings.Join(items, ", ") + " ]"
+	}
+	return inlineValue(v.data, format)
+}
+
+// yamlComment comments out a custom documentation block for a YAML file,
+// these blocks are typically TOML samples so any line that is already a
+// comment is left as-is.
+func yamlComment(s, indent string) string {
+	var o string
+	for _, l := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		switch {
+		case l == "":
+			o = o + indent + "#\n"
+		case strings.HasPrefix(l, "#"):
+			o = o + indent + l + "\n"
+		default:
+			o = o + indent + "# " + l + "\n"
+		}
+	}
+	return o
+}
+
+// yamlContents returns the YAML representation of the passed configuration
+// lines, which are expected to be sorted by section. Values are serialized
+// the same way as for TOML, given the scalar and flow sequence syntax we
+// generate is valid YAML as well. Custom documentation blocks are commented
+// out, as is the header of any section containing only those or commented
+// out secret values, given an empty section would not be valid.
+func yamlContents(docs map[string]string, lines []*cfgLine) (confText string) {
+	hasValues := map[string]bool{}
+	for _, v := range lines {
+		if !v.skipvalue && !v.commented {
+			// A section has values if any of its nested sections do
+			parts := sectionParts(v.parent)
+			for i := range parts {
+				hasValues[strings.Join(parts[:i+1], sepKeyParts)] = true
+			}
+		}
+	}
+
+	var cparent, indent string
+	var cparts []string
+	for _, v := range lines {
+		if cparent != v.parent {
+			cparent = v.parent
+
+			// Only the sections that are not already open need a header
+			parts := sectionParts(cparent)
+			open := 0
+			for open < len(parts) && open < len(cparts) && parts[open] == cparts[open] {
+				open++
+			}
+
+			for i := open; i < len(parts); i++ {
+				section := strings.Join(parts[:i+1], sepKeyParts)
+				hindent := strings.Repeat("  ", i)
+
+				// Sections opened together are not separated
+				if i == open {
+					confText += "\n"
+				}
+				if t, ok := docs[section+sepKeyParts]; ok {
+					confText += commentify(t, hindent+"# ")
+				}
+
+				confText += hindent
+				if !hasValues[section] {
+					confText += "# "
+				}
+				confText += parts[i] + ":\n"
+			}
+			cparts = parts
+			indent = strings.Repeat("  ", len(parts))
+		}
+
+		if v.skipvalue {
+			confText += yamlComment(v.doc, indent)
+		} else {
+			confText += commentify(v.doc, indent+"# ")
+			if v.commented {
+				confText += indent + "# " + v.child + ": " + lineValue(v, "yaml") + "\n"
+			} else {
+				confText += indent + v.child + ": " + lineValue(v, "yaml") + "\n"
+			}
 		}
 	}
 
 	return
 }
 
+// jsonValue returns the JSON representation of a value returned by lineValue
+// for JSON, values that are not valid JSON, like the text of the values
+// implementing encoding.TextMarshaler, are quoted.
+func jsonValue(v string) string {
+	if json.Valid([]byte(v)) {
+		return v
+	}
+	return strconv.Quote(v)
+}
+
+// jsonContents returns the JSON representation of the passed configuration
+// lines, which are expected to be sorted by section. Nested sections are
+// written as nested objects.
+func jsonContents(lines []*cfgLine) string {
+	var b strings.Builder
+	b.WriteString("{")
+
+	// The sections currently open, and whether each open object, including
+	// the outermost one, already has any members.
+	var open []string
+	members := []bool{false}
+	member := func() {
+		if members[len(open)] {
+			b.WriteString(",")
+		}
+		members[len(open)] = true
+		b.WriteString("\n" + strings.Repeat("  ", len(open)+1))
+	}
+
+	for _, v := range lines {
+		if v.skipvalue || v.commented {
+			continue
+		}
+
+		parts := sectionParts(v.parent)
+		same := 0
+		for same < len(parts) && same < len(open) && parts[same] == open[same] {
+			same++
+		}
+
+		for len(open) > same {
+			open = open[:len(open)-1]
+			members = members[:len(members)-1]
+			b.WriteString("\n" + strings.Repeat("  ", len(open)+1) + "}")
+		}
+
+		for _, p := range parts[same:] {
+			member()
+			b.WriteString(strconv.Quote(p) + ": {")
+			open = append(open, p)
+			members = append(members, false)
+		}
+
+		member()
+		b.WriteString(strconv.Quote(v.child) + ": " + jsonValue(lineValue(v, "json")))
+	}
+
+	for len(open) > 0 {
+		open = open[:len(open)-1]
+		b.WriteString("\n" + strings.Repeat("  ", len(open)+1) + "}")
+	}
+	if !members[0] {
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n}\n")
+	return b.String()
+}
+
 // initCfgFile creates a new default config file in the specified location, it
-// will refuse to overwrite an already existing configuration file.
-func initCfgFile(icfg Config, cfg *BaseConfig) (used string, err error) {
+// will refuse to overwrite an already existing configuration file. If in is
+// not nil the values are first asked for interactively, reading the answers
+// from it.
+func initCfgFile(icfg Config, cfg *BaseConfig, in io.Reader) (used string, err error) {
 	var wanted string
-	if wanted, err = fileName(cfg.s_appName, icfg.GetConfigFile(), cfg.CfgLocation.Value); err != nil {
+	if wanted, err = fileName(cfg.s_appName, icfg.GetConfigFile(), cfg.CfgLocation.Value, cfg.CfgFormat.Value); err != nil {
 		return
 	}
 
 	used = wanted
+	if in != nil {
+		// Don't have the user answer everything only to fail afterwards
+		if _, serr := cfg.s_fs.Stat(wanted); serr == nil && !cfg.CfgForce {
+			return "", fmt.Errorf("Cannot create config file %s, the file already exists", wanted)
+		}
+
+		if err = promptCfgFile(icfg, cfg, in); err != nil {
+			return
+		}
+	}
+
 	cfgDir := filepath.Dir(wanted)
 
 	// The directory might not exist in the xdg case
 	err = cfg.s_fs.MkdirAll(cfgDir, 0700)
 	if err != nil {
-		return "", errors.WithMessage(err, fmt.Sprintf("Cannot create config directory %s", cfgDir))
+		return "", fmt.Errorf("Cannot create config directory %s: %w", cfgDir, err)
 	}
 
 	perms := os.O_WRONLY | os.O_CREATE
@@ -348,7 +783,7 @@ func initCfgFile(icfg Config, cfg *BaseConfig) (used string, err error) {
 
 	f, err := cfg.s_fs.OpenFile(wanted, perms, 0644)
 	if err != nil {
-		return "", errors.WithMessage(err, fmt.Sprintf("Cannot create config file %s", wanted))
+		return "", fmt.Errorf("Cannot create config file %s: %w", wanted, err)
 	}
 	defer func() {
 		cerr := f.Close()
@@ -358,25 +793,154 @@ func initCfgFile(icfg Config, cfg *BaseConfig) (used string, err error) {
 	}()
 
 	var confText string
-	if confText, err = fileContents(icfg); err != nil {
+	if confText, err = fileContents(icfg, cfg.CfgFormat.Value); err != nil {
 		return
 	}
 
-	var tmpl *template.Template
-	if tmpl, err = template.New("initconfig").Funcs(template.FuncMap{
+	if err = executeCfgTemplate(f, cfg, confText); err != nil {
+		return "", err
+	}
+
+	return
+}
+
+// executeCfgTemplate writes the passed configuration file contents to w,
+// after executing them as a template.
+func executeCfgTemplate(w io.Writer, cfg *BaseConfig, confText string) error {
+	tmpl, err := template.New("initconfig").Funcs(template.FuncMap{
 		"date": func() string { return time.Now().Format(time.RFC3339) },
-	}).Parse(confText); err != nil {
+	}).Parse(confText)
+	if err != nil {
 		// Should never happen
-		return "", errors.WithMessage(err, fmt.Sprintf("Internal error parsing the template"))
+		return fmt.Errorf("Internal error parsing the template: %w", err)
 	}
 
 	// Our custom flags, like loglevel, define String() so they will be
 	// properly converted to strings without having to do anything else.
-	err = tmpl.Execute(f, cfg)
-	if err != nil {
+	if err = tmpl.Execute(w, cfg); err != nil {
 		// Should not happen
-		return "", errors.WithMessage(err, fmt.Sprintf("Internal error executing the template"))
+		return fmt.Errorf("Internal error executing the template: %w", err)
 	}
+	return nil
+}
 
-	return
+// stdinTerminal returns the standard input and whether it is a terminal, it
+// is a variable to allow tests to simulate an interactive session.
+var stdinTerminal = func() (io.Reader, bool) {
+	fi, err := os.Stdin.Stat()
+	return os.Stdin, err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// allowedValues returns a description of the values the passed field accepts,
+// if they are restricted.
+func allowedValues(field reflect.Value) string {
+	if field.Kind() != reflect.Ptr && field.CanAddr() {
+		field = field.Addr()
+	}
+
+	switch f := field.Interface().(type) {
+	case *EnumValue:
+		if valid, ok := f.data.([]string); ok {
+			return strings.Join(valid, ", ")
+		}
+	case *IntValue:
+		return fmt.Sprintf("%d-%d", f.min, f.max)
+	}
+	return ""
+}
+
+// promptCfgFile asks for the value of every configuration file variable, in
+// the order they appear in the configuration file, reading the answers from
+// in. An empty answer keeps the current value, invalid answers are reported
+// and asked for again. Secret values are not asked for, given they would not
+// be written to the file. The answers are checked against a copy of the
+// configuration and only set once all of them have been given, so that the
+// configuration is left untouched if the input ends early.
+func promptCfgFile(icfg Config, cfg *BaseConfig, in io.Reader) error {
+	lines, err := cfgLines(icfg, true)
+	if err != nil {
+		return err
+	}
+
+	aborted, invalid := "Interactive configuration aborted", "%s. Please try again"
+	if _, docs := cfg.GetDocs(); docs != nil {
+		if docs.PromptAborted != "" {
+			aborted = docs.PromptAborted
+		}
+		if docs.PromptInvalid != "" {
+			invalid = docs.PromptInvalid
+		}
+	}
+
+	type answer struct {
+		name, value string
+	}
+	var answers []answer
+	staged, sbase := copyConfig(icfg)
+
+	r := bufio.NewReader(in)
+	for _, v := range lines {
+		// Map instances cannot be set by field name
+		if v.skipvalue || v.secret || len(v.names) == 0 {
+			continue
+		}
+
+		prompt := v.child
+		if v.parent != "" {
+			prompt = v.parent + sepKeyParts + v.child
+		}
+		if allowed := allowedValues(v.field); allowed != "" {
+			prompt += " [" + allowed + "]"
+		}
+		current := v.value
+		if s, ok := v.data.(string); ok {
+			current = s
+		} else if s, qerr := strconv.Unquote(current); qerr == nil {
+			current = s
+		} else if v.field.Kind() == reflect.Map {
+			current = mapString(v.field)
+		}
+		prompt += " (" + current + "): "
+
+		fmt.Fprint(cfg.Out(), commentify(v.doc, "# "))
+		for {
+			fmt.Fprint(cfg.Out(), prompt)
+			text, rerr := r.ReadString('\n')
+			text = strings.TrimSpace(text)
+			if rerr != nil && text == "" {
+				fmt.Fprintln(cfg.Out())
+				return fmt.Errorf("%s: %w", aborted, rerr)
+			}
+
+			if text == "" {
+				break
+			}
+
+			var serr error
+			for _, name := range v.names {
+				if serr = sbase.setString(staged, name, text); serr != nil {
+					break
+				}
+			}
+			if serr == nil {
+				for _, name := range v.names {
+					answers = append(answers, answer{name, text})
+				}
+				break
+			}
+
+			cfg.Tracef("Invalid answer for %s: %v", v.child, serr)
+			fmt.Fprintf(cfg.Out(), invalid+"\n", strings.TrimSuffix(serr.Error(), "."))
+			if rerr != nil {
+				return fmt.Errorf("%s: %w", aborted, rerr)
+			}
+		}
+	}
+
+	for _, a := range answers {
+		if err = cfg.setString(icfg, a.name, a.value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/pkg/errors"
 	"github.com/woodensquares/greenery/internal/doc"
 )
 
@@ -62,6 +61,25 @@ const (
 	// list. The string following will be assigned to DocSet.ConfigEnvMsg3.
 	DocConfigEnvMsg3 = doc.ConfigEnvMsg3
 
+	// DocDidYouMean is the text suggesting the likely intended name for an
+	// unknown configuration variable, command or flag, %s will be replaced by
+	// the suggested name. The text to be used should follow in the next string
+	// in the list. The string following will be assigned to DocSet.DidYouMean.
+	DocDidYouMean = doc.DidYouMean
+
+	// DocPromptAborted is the message of the error returned when the input
+	// ends during an interactive configuration. The text to be used should
+	// follow in the next string in the list. The string following will be
+	// assigned to DocSet.PromptAborted.
+	DocPromptAborted = doc.PromptAborted
+
+	// DocPromptInvalid is the message shown when an invalid value is entered
+	// during an interactive configuration, %s will be replaced by the reason
+	// the value is invalid. The text to be used should follow in the next
+	// string in the list. The string following will be assigned to
+	// DocSet.PromptInvalid.
+	DocPromptInvalid = doc.PromptInvalid
+
 	// DocHelpDelimiter is the delimiter of the help section. This will map to the
 	// Help field in the DocSet struct, which is a HelpStrings struct. These
 	// strings will be used to localize the overall common headers/footers/... in
@@ -140,6 +158,28 @@ const (
 	// DocConfigDisplayCmd is the identifier for the "config display" command.
 	DocConfigDisplayCmd = doc.ConfigDisplayCmd
 
+	// DocConfigProfilesCmd is the identifier for the "config profiles"
+	// command.
+	DocConfigProfilesCmd = doc.ConfigProfilesCmd
+
+	// DocConfigGetCmd is the identifier for the "config get" command.
+	DocConfigGetCmd = doc.ConfigGetCmd
+
+	// DocConfigSetCmd is the identifier for the "config set" command.
+	DocConfigSetCmd = doc.ConfigSetCmd
+
+	// DocConfigUnsetCmd is the identifier for the "config unset" command.
+	DocConfigUnsetCmd = doc.ConfigUnsetCmd
+
+	// DocConfigDiffCmd is the identifier for the "config diff" command.
+	DocConfigDiffCmd = doc.ConfigDiffCmd
+
+	// DocConfigUpgradeCmd is the identifier for the "config upgrade" command.
+	DocConfigUpgradeCmd = doc.ConfigUpgradeCmd
+
+	// DocConfigValidateCmd is the identifier for the "config validate" command.
+	DocConfigValidateCmd = doc.ConfigValidateCmd
+
 	// DocHelpCmd is the identifier for the "help" command.
 	DocHelpCmd = doc.HelpCmd
 
@@ -163,6 +203,9 @@ const (
 	// DocConfFile is the help information for the ConfFile flag.
 	DocConfFile = doc.ConfFile
 
+	// DocProfile is the help information for the Profile flag.
+	DocProfile = doc.Profile
+
 	// DocLogFile is the help information for the LogFile flag.
 	DocLogFile = doc.LogFile
 
@@ -178,6 +221,9 @@ const (
 	// DocVerbosity is the help information for the Verbosity flag.
 	DocVerbosity = doc.Verbosity
 
+	// DocErrorFormat is the help information for the ErrorFormat flag.
+	DocErrorFormat = doc.ErrorFormat
+
 	// DocDoTrace is the help information for the DoTrace flag.
 	DocDoTrace = doc.DoTrace
 
@@ -187,6 +233,30 @@ const (
 	// DocCfgForce is the help information for the CfgForce flag.
 	DocCfgForce = doc.CfgForce
 
+	// DocCfgInteractive is the help information for the CfgInteractive flag.
+	DocCfgInteractive = doc.CfgInteractive
+
+	// DocCfgFormat is the help information for the CfgFormat flag.
+	DocCfgFormat = doc.CfgFormat
+
+	// DocCfgOutput is the help information for the CfgOutput flag.
+	DocCfgOutput = doc.CfgOutput
+
+	// DocCfgReveal is the help information for the CfgReveal flag.
+	DocCfgReveal = doc.CfgReveal
+
+	// DocCfgExport is the help information for the CfgExport flag.
+	DocCfgExport = doc.CfgExport
+
+	// DocCfgAll is the help information for the CfgAll flag.
+	DocCfgAll = doc.CfgAll
+
+	// DocCfgDryRun is the help information for the CfgDryRun flag.
+	DocCfgDryRun = doc.CfgDryRun
+
+	// DocCfgDefaults is the help information for the CfgDefaults flag.
+	DocCfgDefaults = doc.CfgDefaults
+
 	// DocConfigDelimiter is the delimiter for the config file section, this will
 	// map to the ConfigFile field in the DocSet struct, which is a map of
 	// strings. This section has the exact same structure as the Cmdline section
@@ -281,6 +351,9 @@ func getDocset(documentation map[string]*DocSet, lang, defLang string) (*DocSet,
 		ConfigEnvMsg1: docs.ConfigEnvMsg1,
 		ConfigEnvMsg2: docs.ConfigEnvMsg2,
 		ConfigEnvMsg3: docs.ConfigEnvMsg3,
+		DidYouMean:    docs.DidYouMean,
+		PromptAborted: docs.PromptAborted,
+		PromptInvalid: docs.PromptInvalid,
 		Help:          &hs,
 		CmdLine:       cmds,
 		ConfigFile:    cf,
@@ -296,7 +369,7 @@ func convertDocset(unprocessedDocs map[string][]string) (map[string]*DocSet, err
 	for lang, udoc := range unprocessedDocs {
 		converted, err := ConvertDocs(udoc)
 		if err != nil {
-			return nil, errors.WithMessage(err, fmt.Sprintf("While processing language %s", lang))
+			return nil, fmt.Errorf("While processing language %s: %w", lang, err)
 		}
 
 		convertedDocs[lang] = converted
@@ -484,6 +557,7 @@ func helperBaseCmd(langDoc *DocSet, docs []string, idx, l int) (int, error) {
 
 	var foundUse, foundShort, foundLong, foundExample, foundHelpFlag int
 	var foundConfigEnvMsg1, foundConfigEnvMsg2, foundConfigEnvMsg3, foundCmdFlags int
+	var foundDidYouMean, foundPromptAborted, foundPromptInvalid int
 
 	for i = idx; i < l; i++ {
 		if docs[i] == doc.BaseDelimiter {
@@ -552,6 +626,27 @@ func helperBaseCmd(langDoc *DocSet, docs []string, idx, l int) (int, error) {
 			foundConfigEnvMsg3 = i
 			langDoc.ConfigEnvMsg3 = docs[i+1]
 			i++
+		case doc.DidYouMean:
+			if foundDidYouMean > 0 {
+				return 0, fmt.Errorf("%s duplicate DidYouMean declaration at line %d (previously line %d)", errText, i, foundDidYouMean)
+			}
+			foundDidYouMean = i
+			langDoc.DidYouMean = docs[i+1]
+			i++
+		case doc.PromptAborted:
+			if foundPromptAborted > 0 {
+				return 0, fmt.Errorf("%s duplicate PromptAborted declaration at line %d (previously line %d)", errText, i, foundPromptAborted)
+			}
+			foundPromptAborted = i
+			langDoc.PromptAborted = docs[i+1]
+			i++
+		case doc.PromptInvalid:
+			if foundPromptInvalid > 0 {
+				return 0, fmt.Errorf("%s duplicate PromptInvalid declaration at line %d (previously line %d)", errText, i, foundPromptInvalid)
+			}
+			foundPromptInvalid = i
+			langDoc.PromptInvalid = docs[i+1]
+			i++
 		case doc.CmdFlags:
 			if foundCmdFlags > 0 {
 				return 0, fmt.Errorf("%s duplicate CmdFlags declaration at line %d (previously line %d)", errText, i, foundCmdFlags)
@@ -22,6 +22,12 @@ of the program depending on command line arguments:`,
 	"No variables",
 	ConfigEnvMsg3,
 	"The following environment variables are available for this program:",
+	DidYouMean,
+	"did you mean %s?",
+	PromptAborted,
+	"Interactive configuration aborted",
+	PromptInvalid,
+	"%s. Please try again",
 	BaseDelimiter,
 	HelpDelimiter,
 	Usage,
@@ -72,6 +78,58 @@ all the supported variables with their default values`,
 	`Shows the current configuration values taking into account all environment
 and configuration file values. Command-line flags invalid for the config display
 command would not be displayed`,
+	"",
+	ConfigProfilesCmd,
+	"",
+	"Lists the configuration profiles",
+	`Lists the profiles available in the configuration file, the active profile,
+if any, is marked with an asterisk`,
+	"",
+	ConfigGetCmd,
+	"[section.key]",
+	"Prints the value of a configuration variable",
+	`Prints the current value of the configuration variable, taking into account
+all environment and configuration file values. Secret values will be masked
+unless --reveal is specified`,
+	"",
+	ConfigSetCmd,
+	"[section.key] [value]",
+	"Sets a configuration variable in the configuration file",
+	`Sets the configuration variable to the value in the configuration file that
+was loaded, after validating it. Any comments in the file are preserved`,
+	"",
+	ConfigUnsetCmd,
+	"[section.key]",
+	"Removes a configuration variable from the configuration file",
+	`Removes the configuration variable from the configuration file that was
+loaded, so that its default value will be used. Any comments in the file are
+preserved`,
+	"",
+	ConfigDiffCmd,
+	"[other-file]",
+	"Shows how the configuration differs from the defaults or another file",
+	`Shows the configuration variables whose current value differs from the
+default, or if a configuration file is specified, from the value in that file.
+With --defaults the specified file is compared against the defaults instead.
+The command fails if any differences are found`,
+	"",
+	ConfigUpgradeCmd,
+	"",
+	"Upgrades the configuration file adding any new variables",
+	`Regenerates the configuration file that was loaded, keeping the values it
+sets, adding any new variables with their default value and documentation and
+commenting out any variables that are no longer used. The changes are shown
+before the file is written, and the previous file is kept as a backup, named
+after the file with a .bak suffix and a number if that backup already exists.
+With --dry-run the changes are only shown`,
+	"",
+	ConfigValidateCmd,
+	"[file]",
+	"Validates the configuration file reporting every problem found",
+	`Checks the configuration file that would be loaded, or the specified one,
+reporting every unknown variable and invalid value in it together with its
+position in the file when available. The command fails if any problems are
+found`,
 	"",
 	VersionCmd,
 	"",
@@ -84,6 +142,8 @@ command would not be displayed`,
 	"The log level of the program. Valid values are \"error\", \"warn\", \"info\" and \"debug\"",
 	ConfFile,
 	"The configuration file location",
+	Profile,
+	"The configuration profile to use",
 	LogFile,
 	"The log file location",
 	Pretty,
@@ -94,12 +154,30 @@ command would not be displayed`,
 	"If set no configuration file will be loaded",
 	Verbosity,
 	"The verbosity of the program, an integer between 0 and 3 inclusive.",
+	ErrorFormat,
+	"The format of the error messages, one of \"text\" or \"json\"",
 	DoTrace,
 	"Enables tracing",
 	CfgLocation,
 	"Where to write the configuration file, one of \"cwd\", \"user\" or \"system\"",
 	CfgForce,
 	"If specified, any existing configuration files will be overwritten",
+	CfgInteractive,
+	"If specified, the configuration values will be asked for on the terminal before the file is written",
+	CfgFormat,
+	"The format of the configuration file, one of \"toml\", \"yaml\" or \"json\"",
+	CfgOutput,
+	"The output format, one of \"text\", \"json\", \"toml\" or \"yaml\"",
+	CfgReveal,
+	"If specified, the values of secret configuration variables will be displayed",
+	CfgExport,
+	"Output the environment variables as a script for the shell, one of \"bash\", \"zsh\", \"fish\" or \"powershell\"",
+	CfgAll,
+	"If specified, all the environment variables will be exported, not only the ones currently set",
+	CfgDryRun,
+	"If specified, the changes will be shown but the configuration file will not be written",
+	CfgDefaults,
+	"If specified, the configuration file passed will be compared against the defaults rather than the current configuration",
 	CmdlineDelimiter,
 	ConfigDelimiter,
 	ConfigHeader,
@@ -22,6 +22,12 @@ l'esecuzione del programma a seconda dei parametri passati al comando:`,
 	"Nessuna variabile",
 	ConfigEnvMsg3,
 	"Le variabili di sistema seguenti sono disponibili per questo programma:",
+	DidYouMean,
+	"forse intendevi %s?",
+	PromptAborted,
+	"Configurazione interattiva interrotta",
+	PromptInvalid,
+	"%s. Riprova",
 	BaseDelimiter,
 	HelpDelimiter,
 	Usage,
@@ -72,6 +78,59 @@ inizializzate al loro valore di default.`,
 	`Mostra la configurazione corrente tenendo in conto le variabili di sistema e
 il file di configurazione. Opzioni passate sulla linea di comando che non sono
 validi per il comando config display non saranno disponibili`,
+	"",
+	ConfigProfilesCmd,
+	"",
+	"Mostra i profili di configurazione",
+	`Mostra i profili disponibili nel file di configurazione, il profilo attivo,
+se presente, é indicato con un asterisco`,
+	"",
+	ConfigGetCmd,
+	"[sezione.chiave]",
+	"Mostra il valore di una variabile di configurazione",
+	`Mostra il valore corrente della variabile di configurazione, tenendo in conto
+le variabili di sistema e il file di configurazione. I valori segreti saranno
+nascosti a meno che --reveal sia presente`,
+	"",
+	ConfigSetCmd,
+	"[sezione.chiave] [valore]",
+	"Imposta una variabile di configurazione nel file di configurazione",
+	`Imposta la variabile di configurazione al valore nel file di configurazione
+caricato, dopo averlo validato. I commenti nel file saranno preservati`,
+	"",
+	ConfigUnsetCmd,
+	"[sezione.chiave]",
+	"Rimuove una variabile di configurazione dal file di configurazione",
+	`Rimuove la variabile di configurazione dal file di configurazione caricato,
+in modo che il suo valore di default sia usato. I commenti nel file saranno
+preservati`,
+	"",
+	ConfigDiffCmd,
+	"[altro-file]",
+	"Mostra le differenze della configurazione dai default o da un altro file",
+	`Mostra le variabili di configurazione il cui valore corrente é diverso dal
+default o, se un file di configurazione é specificato, dal valore in quel file.
+Con --defaults il file specificato é invece confrontato con i default.
+Il comando fallisce se ci sono differenze`,
+	"",
+	ConfigUpgradeCmd,
+	"",
+	"Aggiorna il file di configurazione aggiungendo le nuove variabili",
+	`Rigenera il file di configurazione che é stato caricato, mantenendo i valori
+che contiene, aggiungendo le nuove variabili con il loro valore di default e la
+loro documentazione e commentando le variabili che non sono piú usate. Le
+modifiche sono mostrate prima di scrivere il file, e il file precedente é
+mantenuto come copia di sicurezza, con il nome del file seguito da .bak e da
+un numero se quella copia esiste giá. Con --dry-run le modifiche sono solo
+mostrate`,
+	"",
+	ConfigValidateCmd,
+	"[file]",
+	"Verifica il file di configurazione riportando ogni problema trovato",
+	`Controlla il file di configurazione che verrebbe caricato, o quello
+specificato, riportando ogni variabile sconosciuta e valore non valido insieme
+alla sua posizione nel file se disponibile. Il comando fallisce se ci sono
+problemi`,
 	"",
 	VersionCmd,
 	"",
@@ -84,6 +143,8 @@ validi per il comando config display non saranno disponibili`,
 	"Il livello di logging del programma. Valori validi sono \"error\", \"warn\", \"info\" e \"debug\"",
 	ConfFile,
 	"Il file di configurazione da usare",
+	Profile,
+	"Il profilo di configurazione da usare",
 	LogFile,
 	"Il file dove stampare il log",
 	Pretty,
@@ -94,12 +155,30 @@ validi per il comando config display non saranno disponibili`,
 	"Se questa opzione é settata, nessun file di configurazione sará caricato",
 	Verbosity,
 	"La verbositá del programma, un numero da 0 a 3 inclusi",
+	ErrorFormat,
+	"Il formato dei messaggi di errore, \"text\" o \"json\"",
 	DoTrace,
 	"Attiva la modalitá di tracing",
 	CfgLocation,
 	"Dove scrivere il file di configurazione, uno di \"cwd\", \"user\" o \"system\"",
 	CfgForce,
 	"Se presente, se c'é un file di configurazione, sará sovrascritto",
+	CfgInteractive,
+	"Se presente, i valori di configurazione saranno chiesti sul terminale prima di scrivere il file",
+	CfgFormat,
+	"Il formato del file di configurazione, uno di \"toml\", \"yaml\" o \"json\"",
+	CfgOutput,
+	"Il formato dell'output, uno di \"text\", \"json\", \"toml\" o \"yaml\"",
+	CfgReveal,
+	"Se presente, i valori delle variabili di configurazione segrete saranno mostrati",
+	CfgExport,
+	"Mostra le variabili d'ambiente come uno script per la shell, una di \"bash\", \"zsh\", \"fish\" o \"powershell\"",
+	CfgAll,
+	"Se presente, tutte le variabili d'ambiente saranno esportate, non solo quelle attualmente impostate",
+	CfgDryRun,
+	"Se presente, le modifiche saranno mostrate ma il file di configurazione non sará scritto",
+	CfgDefaults,
+	"Se presente, il file di configurazione specificato sará confrontato con i default invece che con la configurazione corrente",
 	CmdlineDelimiter,
 	ConfigDelimiter,
 	ConfigHeader,
@@ -30,6 +30,15 @@ const ConfigEnvMsg2 = "ConfigEnvMsg2"
 // ConfigEnvMsg3 is documented as part of the non-internal class
 const ConfigEnvMsg3 = "ConfigEnvMsg3"
 
+// DidYouMean is documented as part of the non-internal class
+const DidYouMean = "DidYouMean"
+
+// PromptAborted is documented as part of the non-internal class
+const PromptAborted = "PromptAborted"
+
+// PromptInvalid is documented as part of the non-internal class
+const PromptInvalid = "PromptInvalid"
+
 // HelpDelimiter is documented as part of the non-internal class
 const HelpDelimiter = "------ DELIMITER:HELP ------"
 
@@ -73,6 +82,27 @@ const ConfigEnvCmd = "config>env"
 // ConfigDisplayCmd is documented as part of the non-internal class
 const ConfigDisplayCmd = "config>display"
 
+// ConfigProfilesCmd is documented as part of the non-internal class
+const ConfigProfilesCmd = "config>profiles"
+
+// ConfigGetCmd is documented as part of the non-internal class
+const ConfigGetCmd = "config>get"
+
+// ConfigSetCmd is documented as part of the non-internal class
+const ConfigSetCmd = "config>set"
+
+// ConfigUnsetCmd is documented as part of the non-internal class
+const ConfigUnsetCmd = "config>unset"
+
+// ConfigDiffCmd is documented as part of the non-internal class
+const ConfigDiffCmd = "config>diff"
+
+// ConfigUpgradeCmd is documented as part of the non-internal class
+const ConfigUpgradeCmd = "config>upgrade"
+
+// ConfigValidateCmd is documented as part of the non-internal class
+const ConfigValidateCmd = "config>validate"
+
 // HelpCmd is documented as part of the non-internal class
 const HelpCmd = "help"
 
@@ -88,6 +118,9 @@ const LogLevel = "LogLevel"
 // ConfFile is documented as part of the non-internal class
 const ConfFile = "ConfFile"
 
+// Profile is documented as part of the non-internal class
+const Profile = "Profile"
+
 // LogFile is documented as part of the non-internal class
 const LogFile = "LogFile"
 
@@ -103,6 +136,9 @@ const NoCfg = "NoCfg"
 // Verbosity is documented as part of the non-internal class
 const Verbosity = "Verbosity"
 
+// ErrorFormat is documented as part of the non-internal class
+const ErrorFormat = "ErrorFormat"
+
 // DoTrace is documented as part of the non-internal class
 const DoTrace = "DoTrace"
 
@@ -112,6 +148,30 @@ const CfgLocation = "CfgLocation"
 // CfgForce is documented as part of the non-internal class
 const CfgForce = "CfgForce"
 
+// CfgInteractive is documented as part of the non-internal class
+const CfgInteractive = "CfgInteractive"
+
+// CfgFormat is documented as part of the non-internal class
+const CfgFormat = "CfgFormat"
+
+// CfgOutput is documented as part of the non-internal class
+const CfgOutput = "CfgOutput"
+
+// CfgReveal is documented as part of the non-internal class
+const CfgReveal = "CfgReveal"
+
+// CfgExport is documented as part of the non-internal class
+const CfgExport = "CfgExport"
+
+// CfgAll is documented as part of the non-internal class
+const CfgAll = "CfgAll"
+
+// CfgDryRun is documented as part of the non-internal class
+const CfgDryRun = "CfgDryRun"
+
+// CfgDefaults is documented as part of the non-internal class
+const CfgDefaults = "CfgDefaults"
+
 // ConfigDelimiter is documented as part of the non-internal class
 const ConfigDelimiter = "------ DELIMITER:CONFIG ------"
 
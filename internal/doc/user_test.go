@@ -22,6 +22,9 @@ func TestUser(t *testing.T) {
 	require.Equal(t, ConfigEnvMsg1, "ConfigEnvMsg1")
 	require.Equal(t, ConfigEnvMsg2, "ConfigEnvMsg2")
 	require.Equal(t, ConfigEnvMsg3, "ConfigEnvMsg3")
+	require.Equal(t, DidYouMean, "DidYouMean")
+	require.Equal(t, PromptAborted, "PromptAborted")
+	require.Equal(t, PromptInvalid, "PromptInvalid")
 	require.Equal(t, HelpDelimiter, "------ DELIMITER:HELP ------")
 	require.Equal(t, Usage, "Usage")
 	require.Equal(t, Aliases, "Aliases")
@@ -36,19 +39,36 @@ func TestUser(t *testing.T) {
 	require.Equal(t, ConfigInitCmd, "config>init")
 	require.Equal(t, ConfigEnvCmd, "config>env")
 	require.Equal(t, ConfigDisplayCmd, "config>display")
+	require.Equal(t, ConfigProfilesCmd, "config>profiles")
+	require.Equal(t, ConfigGetCmd, "config>get")
+	require.Equal(t, ConfigSetCmd, "config>set")
+	require.Equal(t, ConfigUnsetCmd, "config>unset")
+	require.Equal(t, ConfigDiffCmd, "config>diff")
+	require.Equal(t, ConfigUpgradeCmd, "config>upgrade")
+	require.Equal(t, ConfigValidateCmd, "config>validate")
 	require.Equal(t, HelpCmd, "help")
 	require.Equal(t, VersionCmd, "version")
 	require.Equal(t, CmdlineDelimiter, "------ DELIMITER:COMMANDLINE ------")
 	require.Equal(t, LogLevel, "LogLevel")
 	require.Equal(t, ConfFile, "ConfFile")
+	require.Equal(t, Profile, "Profile")
 	require.Equal(t, LogFile, "LogFile")
 	require.Equal(t, Pretty, "Pretty")
 	require.Equal(t, NoEnv, "NoEnv")
 	require.Equal(t, NoCfg, "NoCfg")
 	require.Equal(t, Verbosity, "Verbosity")
+	require.Equal(t, ErrorFormat, "ErrorFormat")
 	require.Equal(t, DoTrace, "DoTrace")
 	require.Equal(t, CfgLocation, "CfgLocation")
 	require.Equal(t, CfgForce, "CfgForce")
+	require.Equal(t, CfgInteractive, "CfgInteractive")
+	require.Equal(t, CfgFormat, "CfgFormat")
+	require.Equal(t, CfgOutput, "CfgOutput")
+	require.Equal(t, CfgReveal, "CfgReveal")
+	require.Equal(t, CfgExport, "CfgExport")
+	require.Equal(t, CfgAll, "CfgAll")
+	require.Equal(t, CfgDryRun, "CfgDryRun")
+	require.Equal(t, CfgDefaults, "CfgDefaults")
 	require.Equal(t, ConfigDelimiter, "------ DELIMITER:CONFIG ------")
 	require.Equal(t, ConfigHeader, ".")
 	require.Equal(t, CustomDelimiter, "------ DELIMITER:CUSTOM ------")
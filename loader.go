@@ -4,16 +4,19 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 
-	"github.com/pkg/errors"
 	"github.com/shibukawa/configdir"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/woodensquares/greenery/internal/doc"
 )
 
 func getSetterStringer(field reflect.Value) (reflect.Value, reflect.Value) {
@@ -40,18 +43,19 @@ func (bcfg *BaseConfig) setString(cfg Config, k, v string) error {
 	userValue := reflect.ValueOf(cfg).Elem()
 
 	var field reflect.Value
+	var x reflect.StructField
 	var ok bool
 
-	if _, ok = userType.FieldByName(k); !ok {
+	if x, ok = fieldByName(userType, k); !ok {
 		// Current base config cannot exercise this as we don't have any
 		// non-cmd env fields
-		if _, ok = baseType.FieldByName(k); !ok {
+		if x, ok = baseType.FieldByName(k); !ok {
 			return fmt.Errorf(
-				"Internal error, cannot find field %s (setting value %s)", k, v)
+				"Internal error, cannot find field %s (setting value %s)", k, maskValue(isSecret(x), v))
 		}
 		field = baseValue.FieldByName(k)
 	} else {
-		field = userValue.FieldByName(k)
+		field = userValue.FieldByIndex(x.Index)
 	}
 
 	setter, _ := getSetterStringer(field)
@@ -72,8 +76,88 @@ func (bcfg *BaseConfig) setString(cfg Config, k, v string) error {
 		return nil
 	}
 
-	cfg.Tracef("Calling setField with %v", v)
-	return setField(cfg, field, nil, v, k)
+	// Environment lists are split on the separator of the field
+	if field.Kind() == reflect.Slice {
+		items, err := splitList(k, v, sliceOptions(x).sep)
+		if err != nil {
+			return err
+		}
+		cfg.Tracef("Calling setField with %v", maskValue(isSecret(x), items))
+		return setField(cfg, field, nil, items, k, isSecret(x))
+	}
+
+	cfg.Tracef("Calling setField with %v", maskValue(isSecret(x), v))
+	return setField(cfg, field, nil, v, k, isSecret(x))
+}
+
+// Descriptions of where a configuration value came from, as returned by
+// GetValueSource.
+const (
+	sourceDefault = "default"
+	sourceCfgFile = "config file "
+	sourceEnv     = "environment variable "
+	sourceFlag    = "flag --"
+)
+
+// recordValueSource records where the value of the passed field, which has
+// just been loaded from viper, came from. Values set on the command line are
+// not loaded from viper and are recorded separately.
+func (bcfg *BaseConfig) recordValueSource(vp *viper.Viper, x reflect.StructField) {
+	cobra, vipername, env, _ := parseTags(x)
+	if vipername == "" || strings.HasSuffix(cobra, sepCmdParts+"custom") || vp.Get(vipername) == nil {
+		return
+	}
+
+	// Viper also returns values set on the command line, which have been
+	// recorded already.
+	if _, ok := bcfg.s_valueSources[x.Name]; ok {
+		return
+	}
+
+	// Viper gives precedence to the environment over the configuration
+	// file, note that env variables are cleared if NoEnv is set.
+	if env != "" {
+		ename := bcfg.s_ucAppName + "_" + env
+		if os.Getenv(ename) != "" {
+			bcfg.s_valueSources[x.Name] = sourceEnv + ename
+			return
+		}
+	}
+
+	src, ok := bcfg.s_cfgSources[strings.ToLower(vipername)]
+	if !ok && x.Type.Kind() == reflect.Map {
+		src, ok = bcfg.tableSource(strings.ToLower(vipername))
+	}
+	if ok {
+		bcfg.s_valueSources[x.Name] = sourceCfgFile + src
+	}
+}
+
+// tableSource returns the configuration file the passed table came from,
+// tables are recorded as their keys, if keys came from different files the
+// first one in key order is returned.
+func (bcfg *BaseConfig) tableSource(vipername string) (string, bool) {
+	keys := []string{}
+	for k := range bcfg.s_cfgSources {
+		if strings.HasPrefix(k, vipername+sepKeyParts) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Strings(keys)
+	return bcfg.s_cfgSources[keys[0]], true
+}
+
+// viperSource returns where the value viper has for the passed field came
+// from, viper gives precedence to the environment over the configuration
+// file.
+func (bcfg *BaseConfig) viperSource(x reflect.StructField) string {
+	if _, _, env, _ := parseTags(x); env != "" && os.Getenv(bcfg.s_ucAppName+"_"+env) != "" {
+		return SourceEnv
+	}
+	return SourceFile
 }
 
 // loadHelper is the main load worker, which is executed both on the embedded
@@ -81,7 +165,11 @@ func (bcfg *BaseConfig) setString(cfg Config, k, v string) error {
 func loadHelper(cfg Config, vp *viper.Viper, viperKeys map[string]bool,
 	x reflect.StructField, v reflect.Value) error {
 
-	cobra, vipername, _, _ := parseTags(x)
+	if isMapCfg(x) {
+		return loadMap(cfg, vp, viperKeys, x, v.FieldByIndex(x.Index))
+	}
+
+	cobra, vipername, env, _ := parseTags(x)
 	if vipername != "" && !strings.HasSuffix(cobra, sepCmdParts+"custom") {
 		cfg.Tracef("Get value for %s", vipername)
 		if vp.Get(vipername) == nil {
@@ -92,7 +180,7 @@ func loadHelper(cfg Config, vp *viper.Viper, viperKeys map[string]bool,
 			return nil
 		}
 		viperKeys[vipername] = true
-		field := v.FieldByName(x.Name)
+		field := v.FieldByIndex(x.Index)
 		// Assume that anything that is a flag wants its .Set method to be
 		// called rather than assigning the variable directly (for
 		// validation purposes).
@@ -109,7 +197,7 @@ func loadHelper(cfg Config, vp *viper.Viper, viperKeys map[string]bool,
 			// indirection as we don't have the straight
 			// value, but a pointer to it, so dereference
 			vs := vp.GetString(vipername)
-			cfg.Tracef("Will set string: %v", vs)
+			cfg.Tracef("Will set string: %v", maskValue(isSecret(x), vs))
 			p := []reflect.Value{reflect.ValueOf(vs)}
 			rv := setter.Call(p)
 			rve := rv[len(rv)-1]
@@ -130,12 +218,488 @@ func loadHelper(cfg Config, vp *viper.Viper, viperKeys map[string]bool,
 				return fmt.Errorf("Trying to set value %v to a non-exported field %s", vp.Get(vipername), x.Name)
 			}
 
-			return setField(cfg, field, vp, nil, vipername)
+			// Viper returns environment lists as strings, split them on the
+			// separator of the field
+			if s, ok := vp.Get(vipername).(string); ok && env != "" && field.Kind() == reflect.Slice {
+				items, err := splitList(vipername, s, sliceOptions(x).sep)
+				if err != nil {
+					return err
+				}
+				if err := setField(cfg, field, nil, items, vipername, isSecret(x)); err != nil {
+					return err
+				}
+			} else if err := setField(cfg, field, vp, nil, vipername, isSecret(x)); err != nil {
+				return err
+			}
+
+			// The keys of a table in the configuration file are read as
+			// variables themselves
+			if field.Kind() == reflect.Map {
+				for _, k := range field.MapKeys() {
+					viperKeys[vipername+sepKeyParts+strings.ToLower(k.String())] = true
+				}
+			}
 		}
 	}
 	return nil
 }
 
+// loadMap loads the instances of a map configuration field from the
+// subsections of its section, replacing the current ones if the section is
+// present. Every instance is loaded like the other configuration variables,
+// and all the invalid values are reported at once.
+func loadMap(cfg Config, vp *viper.Viper, viperKeys map[string]bool,
+	x reflect.StructField, field reflect.Value) error {
+
+	section := mapSection(x)
+	if vp.Get(section) == nil {
+		cfg.Tracef("No instances found in %s, skipping", section)
+		return nil
+	}
+
+	instances := vp.GetStringMap(section)
+	names := make([]string, 0, len(instances))
+	for name := range instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs ConfigErrors
+	m := reflect.MakeMapWithSize(field.Type(), len(names))
+	for _, name := range names {
+		if _, ok := instances[name].(map[string]interface{}); !ok {
+			errs.add(x.Name+sepKeyParts+name, "", fmt.Errorf("%s%s%s is not a section", section, sepKeyParts, name))
+			continue
+		}
+
+		cfg.Tracef("Loading instance %s of %s", name, x.Name)
+		iv := reflect.New(field.Type().Elem()).Elem()
+		for _, ix := range instanceFields(x, name) {
+			if lerr := loadHelper(cfg, vp, viperKeys, ix, iv); lerr != nil {
+				errs.add(ix.Name, "", lerr)
+			}
+		}
+		m.SetMapIndex(reflect.ValueOf(name), iv)
+	}
+
+	if err := errs.errorOrNil(); err != nil {
+		return err
+	}
+
+	field.Set(m)
+	return nil
+}
+
+// cfgExtensions contains the configuration file extensions that are looked
+// for when searching for a configuration file, in order of preference.
+var cfgExtensions = []string{".toml", ".yaml", ".yml", ".json"}
+
+// cfgFormat returns the configuration file format, as understood by viper,
+// for the passed file name based on its extension. Anything that is not
+// recognized is assumed to be TOML.
+func cfgFormat(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	}
+	return "toml"
+}
+
+// cfgCandidates returns the configuration file names to look for, if name
+// is empty this will be any appname.{toml,yaml,yml,json} file.
+func (bcfg *BaseConfig) cfgCandidates(name string) []string {
+	if name != "" {
+		return []string{name}
+	}
+
+	candidates := make([]string, 0, len(cfgExtensions))
+	for _, ext := range cfgExtensions {
+		candidates = append(candidates, bcfg.s_appName+ext)
+	}
+	return candidates
+}
+
+// findInDirs returns the first of the candidate configuration files that
+// exists in the passed directories, or an empty string if none does.
+func (bcfg *BaseConfig) findInDirs(dirs, candidates []string) string {
+	for _, d := range dirs {
+		for _, c := range candidates {
+			candidate := filepath.Join(d, c)
+			bcfg.Tracef("Looking for a configuration file at %s", candidate)
+			if fi, err := bcfg.s_fs.Stat(candidate); err == nil && !fi.IsDir() {
+				return candidate
+			}
+		}
+	}
+
+	return ""
+}
+
+// findCfgFile looks for the configuration file in the current directory and
+// in the user and system configuration directories, returning the first one
+// that exists or an empty string if none does. If name is empty, any
+// appname.{toml,yaml,yml,json} file will be looked for.
+func (bcfg *BaseConfig) findCfgFile(name string) string {
+	var dirs []string
+	if cwd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, cwd)
+	}
+	for _, x := range configdir.New("", bcfg.s_appName).QueryFolders(configdir.All) {
+		dirs = append(dirs, x.Path)
+	}
+
+	return bcfg.findInDirs(dirs, bcfg.cfgCandidates(name))
+}
+
+// resolveCfgFile returns the location of the passed configuration file. A
+// name without a directory, or no name at all, is looked for via
+// findCfgFile, and returned unchanged if it cannot be found.
+func (bcfg *BaseConfig) resolveCfgFile(name string) string {
+	if name == "" || !strings.Contains(name, string(os.PathSeparator)) {
+		if found := bcfg.findCfgFile(name); found != "" {
+			bcfg.Tracef("Found configuration file %s", found)
+			return found
+		}
+	}
+	return name
+}
+
+// cfgLayers returns the configuration files to be merged, in order of
+// increasing precedence: the system configuration file, the user
+// configuration file and the project configuration file. The project
+// configuration file is the passed cfgFile if set, resolved as it is when
+// not merging, otherwise it is looked for in the current directory.
+func (bcfg *BaseConfig) cfgLayers(cfgFile string) []string {
+	var layers []string
+	add := func(f string) {
+		if f == "" {
+			return
+		}
+		for _, l := range layers {
+			if l == f {
+				return
+			}
+		}
+		layers = append(layers, f)
+	}
+
+	candidates := bcfg.cfgCandidates("")
+	cdir := configdir.New("", bcfg.s_appName)
+
+	// System folders are returned in order of preference, so the most
+	// preferred one should be merged last.
+	system := cdir.QueryFolders(configdir.System)
+	for i := len(system) - 1; i >= 0; i-- {
+		add(bcfg.findInDirs([]string{system[i].Path}, candidates))
+	}
+
+	for _, x := range cdir.QueryFolders(configdir.Global) {
+		add(bcfg.findInDirs([]string{x.Path}, candidates))
+	}
+
+	if cfgFile != "" {
+		add(bcfg.resolveCfgFile(cfgFile))
+	} else if cwd, err := os.Getwd(); err == nil {
+		add(bcfg.findInDirs([]string{cwd}, candidates))
+	}
+
+	return layers
+}
+
+// normalizeSetting converts the map[interface{}]interface{} values the YAML
+// parser returns for nested structures into map[string]interface{}, so that
+// they can be merged and serialized.
+func normalizeSetting(v interface{}) interface{} {
+	switch tv := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(tv))
+		for k, vv := range tv {
+			m[strings.ToLower(fmt.Sprintf("%v", k))] = normalizeSetting(vv)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(tv))
+		for k, vv := range tv {
+			m[strings.ToLower(k)] = normalizeSetting(vv)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(tv))
+		for i, vv := range tv {
+			s[i] = normalizeSetting(vv)
+		}
+		return s
+	}
+	return v
+}
+
+// mergeSettings merges the src settings into dst key by key, values in src
+// override the ones in dst no matter their type.
+func mergeSettings(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sm, sok := v.(map[string]interface{})
+		dm, dok := dst[k].(map[string]interface{})
+		if sok && dok {
+			mergeSettings(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// cfgIncludeKey is the top-level configuration file key containing the list
+// of additional configuration files to include.
+const cfgIncludeKey = "include"
+
+// fragments returns the configuration files in the fragment directory
+// (appname.d) next to the passed configuration file, sorted by name.
+func (bcfg *BaseConfig) fragments(file string) []string {
+	dir := filepath.Join(filepath.Dir(file), bcfg.s_appName+".d")
+
+	var found []string
+	for _, ext := range cfgExtensions {
+		if m, err := afero.Glob(bcfg.s_fs, filepath.Join(dir, "*"+ext)); err == nil {
+			found = append(found, m...)
+		}
+	}
+
+	sort.Strings(found)
+	return found
+}
+
+// includes returns the files included by the passed configuration file,
+// removing the include directive from its settings. Non-absolute names and
+// patterns are relative to the directory of the including file.
+func (bcfg *BaseConfig) includes(file string, settings map[string]interface{}) ([]string, error) {
+	v, ok := settings[cfgIncludeKey]
+	if !ok {
+		return nil, nil
+	}
+	delete(settings, cfgIncludeKey)
+
+	var patterns []string
+	switch tv := v.(type) {
+	case string:
+		patterns = []string{tv}
+	case []interface{}:
+		for _, p := range tv {
+			ps, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid %s directive in %s, it should be a list of file names", cfgIncludeKey, file)
+			}
+			patterns = append(patterns, ps)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid %s directive in %s, it should be a list of file names", cfgIncludeKey, file)
+	}
+
+	var files []string
+	for _, p := range patterns {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(file), p)
+		}
+
+		m, err := afero.Glob(bcfg.s_fs, p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid include pattern %s in %s: %w", p, file, err)
+		}
+
+		// A pattern not matching anything is fine, but a missing file
+		// likely is a mistake.
+		if len(m) == 0 && !strings.ContainsAny(p, "*?[") {
+			return nil, fmt.Errorf("%w: Cannot find configuration file %s, included by %s", ErrCfgFileNotFound, p, file)
+		}
+
+		sort.Strings(m)
+		files = append(files, m...)
+	}
+
+	return files, nil
+}
+
+// readCfgFile reads the passed configuration file, returning its settings.
+func (bcfg *BaseConfig) readCfgFile(file string) (map[string]interface{}, error) {
+	lv := viper.New()
+	lv.SetFs(bcfg.s_fs)
+	lv.SetConfigFile(file)
+	lv.SetConfigType(cfgFormat(file))
+
+	if err := lv.ReadInConfig(); err != nil {
+		if _, golangNotFound := err.(*os.PathError); golangNotFound {
+			return nil, fmt.Errorf("%w %s: %v", ErrCfgFileNotFound, file, err)
+		}
+		return nil, fmt.Errorf("%w %s: %v", ErrCfgParse, file, err)
+	}
+
+	return normalizeSetting(lv.AllSettings()).(map[string]interface{}), nil
+}
+
+// recordSources records the passed file as the source of all the settings
+// keys.
+func (bcfg *BaseConfig) recordSources(file, prefix string, settings map[string]interface{}) {
+	for k, v := range settings {
+		if m, ok := v.(map[string]interface{}); ok {
+			bcfg.recordSources(file, prefix+k+sepKeyParts, m)
+			continue
+		}
+		bcfg.s_cfgSources[prefix+k] = file
+	}
+}
+
+// includeCfgFile merges the passed configuration file in settings. Any files
+// it includes are merged first, so the values in the including file take
+// precedence over them, and if fragments is set the files in its fragment
+// directory are merged last, taking precedence over it. stack contains the
+// files currently being included, and is used to detect cycles.
+func (bcfg *BaseConfig) includeCfgFile(file string, fragments bool, stack []string,
+	settings map[string]interface{}) error {
+	file = filepath.Clean(file)
+	for _, s := range stack {
+		if s == file {
+			return fmt.Errorf("Configuration file include cycle: %s",
+				strings.Join(append(stack, file), " -> "))
+		}
+	}
+	stack = append(stack, file)
+
+	bcfg.Tracef("Reading configuration file %s", file)
+	current, err := bcfg.readCfgFile(file)
+	if err != nil {
+		return err
+	}
+
+	included, err := bcfg.includes(file, current)
+	if err != nil {
+		return err
+	}
+
+	for _, inc := range included {
+		if err = bcfg.includeCfgFile(inc, false, stack, settings); err != nil {
+			return err
+		}
+	}
+
+	mergeSettings(settings, current)
+	bcfg.recordSources(file, "", current)
+	bcfg.s_usedConfs = append(bcfg.s_usedConfs, file)
+
+	if fragments {
+		for _, f := range bcfg.fragments(file) {
+			if err = bcfg.includeCfgFile(f, false, stack, settings); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// cfgProfileKey is the top-level configuration file key containing the
+// configuration profiles tables.
+const cfgProfileKey = "profile"
+
+// profileName returns the name of the requested configuration profile, if
+// any. The profile needs to be known before the configuration files are
+// loaded, so the environment is checked here directly.
+func (bcfg *BaseConfig) profileName() string {
+	if bcfg.Profile != "" || bcfg.NoEnv {
+		return bcfg.Profile
+	}
+	return os.Getenv(bcfg.s_ucAppName + "_PROFILE")
+}
+
+// applyProfile removes the profile tables from the settings, recording the
+// available profiles, and overlays the requested profile, if any, on the
+// base settings.
+func (bcfg *BaseConfig) applyProfile(settings map[string]interface{}) error {
+	v, ok := settings[cfgProfileKey]
+	if !ok {
+		return nil
+	}
+	delete(settings, cfgProfileKey)
+
+	profiles, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Invalid %s table in the configuration file, it should contain only profile tables", cfgProfileKey)
+	}
+
+	bcfg.s_profiles = make([]string, 0, len(profiles))
+	for k := range profiles {
+		bcfg.s_profiles = append(bcfg.s_profiles, k)
+	}
+	sort.Strings(bcfg.s_profiles)
+
+	name := strings.ToLower(bcfg.profileName())
+	if name == "" {
+		return nil
+	}
+
+	p, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	bcfg.Tracef("Applying configuration profile %s", name)
+	mergeSettings(settings, p)
+	bcfg.s_profile = name
+
+	// The profile values come from wherever the profile table was defined.
+	var sources func(prefix string, m map[string]interface{})
+	sources = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			if sm, ok := v.(map[string]interface{}); ok {
+				sources(prefix+k+sepKeyParts, sm)
+				continue
+			}
+			pk := cfgProfileKey + sepKeyParts + name + sepKeyParts + prefix + k
+			bcfg.s_cfgSources[prefix+k] = bcfg.s_cfgSources[pk]
+		}
+	}
+	sources("", p)
+	return nil
+}
+
+// readCfgFiles reads the passed configuration files, together with the files
+// they include and their fragment directories, each one overriding the
+// values of the previous ones, and sets the result as the configuration in
+// viper. Viper's own merging refuses to merge values of different types,
+// which happens easily when mixing formats (say integers in TOML and YAML),
+// so the files are merged here instead and passed to viper as YAML, which
+// unlike JSON keeps integers as such rather than converting them to floats.
+func (bcfg *BaseConfig) readCfgFiles(vp *viper.Viper, files []string) error {
+	settings := map[string]interface{}{}
+	bcfg.s_cfgSources = map[string]string{}
+	bcfg.s_usedConfs = nil
+
+	for _, f := range files {
+		if err := bcfg.includeCfgFile(f, true, nil, settings); err != nil {
+			return err
+		}
+
+		bcfg.s_usedConf = f
+		bcfg.s_loaded = true
+		bcfg.s_cfgDir = path.Dir(f)
+	}
+
+	if !bcfg.s_loaded {
+		return nil
+	}
+
+	if err := bcfg.applyProfile(settings); err != nil {
+		return err
+	}
+
+	vp.SetConfigType("yaml")
+	if err := vp.ReadConfig(strings.NewReader(inlineValue(settings, "yaml"))); err != nil {
+		// Should not happen, everything read from a configuration file
+		// should be serializable.
+		return fmt.Errorf("Internal error, cannot merge the configuration files: %w", err)
+	}
+	return nil
+}
+
 // load will load configuration values from file and environment and set in
 // the configuration structure implementing the Config interface.
 func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.Command,
@@ -144,33 +708,37 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 	var cfgFile = bcfg.ConfFile
 	var noCfg = bcfg.NoCfg
 
+	// config validate reports the problems in the configuration files
+	// itself, so it should not fail on the first one here.
+	validating := ccmd != nil && ccmd == bcfg.s_cmds[doc.ConfigValidateCmd]
+	if validating {
+		noCfg = true
+	}
+
+	bcfg.s_cfgSources = map[string]string{}
+	bcfg.s_valueSources = map[string]string{}
+
 	// No cfg has precedence over -c no matter what, if it is set via env or
 	// cmdline don't even try to find a cfg file.
-	if !noCfg {
-		// Either no passed config file, or non-absolute name, so allow viper to
-		// search in the places it should
-		if cfgFile == "" || !strings.Contains(cfgFile, string(os.PathSeparator)) {
-			for _, x := range configdir.New("", bcfg.s_appName).QueryFolders(configdir.All) {
-				bcfg.Tracef("Adding path %s", x.Path)
-				vp.AddConfigPath(x.Path)
-			}
-
-			if cwd, lerr := os.Getwd(); lerr == nil {
-				bcfg.Tracef("Adding path %s", cwd)
-				vp.AddConfigPath(cwd)
-			}
+	if !noCfg && bcfg.s_mergeCfg {
+		if err = bcfg.readCfgFiles(vp, bcfg.cfgLayers(cfgFile)); err != nil {
+			return
 		}
+	} else if !noCfg {
+		// Either no passed config file, or non-absolute name, so look for it
+		// in the places it should be: the current directory first, then the
+		// user and system configuration directories.
+		cfgFile = bcfg.resolveCfgFile(cfgFile)
 
 		if cfgFile == "" {
 			bcfg.Tracef("Cfg file not set, trying with the default %s", defaultCfgFileName)
 			vp.SetConfigFile(defaultCfgFileName)
+			vp.SetConfigType(cfgFormat(defaultCfgFileName))
 		} else {
 			vp.SetConfigFile(cfgFile)
+			vp.SetConfigType(cfgFormat(cfgFile))
 		}
 
-		// Only TOML is supported for now
-		vp.SetConfigType("toml")
-
 		// In case viper/cobra debugging is needed
 		// jwalterweatherman.SetLogThreshold(jwalterweatherman.LevelTrace)
 		// jwalterweatherman.SetStdoutThreshold(jwalterweatherman.LevelTrace)
@@ -178,6 +746,18 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 		if err = vp.ReadInConfig(); err == nil {
 			bcfg.Tracef("Loaded a valid configuration file from %s", vp.ConfigFileUsed())
 			bcfg.s_usedConf = vp.ConfigFileUsed()
+			bcfg.s_usedConfs = []string{bcfg.s_usedConf}
+
+			// Includes, fragments and profiles require merging, which is
+			// not needed otherwise.
+			if vp.Get(cfgIncludeKey) != nil || vp.Get(cfgProfileKey) != nil ||
+				len(bcfg.fragments(bcfg.s_usedConf)) != 0 {
+				if err = bcfg.readCfgFiles(vp, []string{bcfg.s_usedConf}); err != nil {
+					return
+				}
+			} else if settings, rerr := bcfg.readCfgFile(bcfg.s_usedConf); rerr == nil {
+				bcfg.recordSources(bcfg.s_usedConf, "", settings)
+			}
 			bcfg.s_loaded = true
 			bcfg.s_cfgDir = path.Dir(vp.ConfigFileUsed())
 		} else {
@@ -191,7 +771,7 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 			if viperNotFound || golangNotFound {
 				// Not an error unless the user did actually want a config file
 				if cfgFile != "" {
-					err = errors.WithMessage(err, fmt.Sprintf("Could not load config file."))
+					err = fmt.Errorf("%w %s: %v", ErrCfgFileNotFound, cfgFile, err)
 					return
 				}
 				err = nil
@@ -200,12 +780,20 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 
 			if !noCfg {
 				bcfg.Trace("Parse issue")
-				err = errors.WithMessage(err, fmt.Sprintf("Could not parse config file %s", cfgFile))
+				err = fmt.Errorf("%w %s: %v", ErrCfgParse, cfgFile, err)
 				return
 			}
 		}
 	}
 
+	// A requested profile that is not in the configuration is most likely a
+	// mistake, so fail rather than silently using the base configuration.
+	if name := bcfg.profileName(); name != "" && !bcfg.NoCfg && !validating && bcfg.s_profile == "" {
+		err = fmt.Errorf("Unknown configuration profile %s, available profiles: %s",
+			name, strings.Join(bcfg.s_profiles, ", "))
+		return
+	}
+
 	// Viper has all the up-to-date values, need to put them in cfg, viper
 	// already does the correct overrides if the user sets them on cmdline vs
 	// env vs config file. Note the assumption is we have at most one level of
@@ -237,20 +825,30 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 	// that were set on the cmdline first and their cmdline values using the
 	// annotations we set up when binding.
 	noclobber := map[string]string{}
+	appended := map[string]*sliceValue{}
 	ccmd.Flags().Visit(func(fl *pflag.Flag) {
 		if fl.Changed {
 			if ann, ok := fl.Annotations["greeneryVar"]; ok {
 				noclobber[ann[0]] = fl.Value.String()
+				bcfg.s_valueSources[ann[0]] = sourceFlag + fl.Name
+				if sv, ok := fl.Value.(*sliceValue); ok {
+					appended[ann[0]] = sv
+				}
 			}
 		}
 	})
 
-	for i := 0; i < t.NumField(); i++ {
-		x := t.Field(i)
-
+	// Keep going on errors, so that all the invalid values are reported at
+	// once.
+	var errs ConfigErrors
+	fields, err := structFields(t)
+	if err != nil {
+		return
+	}
+	for _, x := range fields {
 		if x.Type == basePType {
 			bcfg.Trace("Get values for our base struct")
-			v2 := v.Field(i).Elem()
+			v2 := v.FieldByIndex(x.Index).Elem()
 			for i2 := 0; i2 < baseType.NumField(); i2++ {
 				x2 := baseType.Field(i2)
 
@@ -258,29 +856,55 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 					// Not going to happen for now as we don't have shared
 					// base values at the moment, everything is a straight
 					// flag.
-					cfg.Tracef("%s is on cmdline, not touching it as it's already set to %s", x2.Name, clb)
+					cfg.Tracef("%s is on cmdline, not touching it as it's already set to %s", x2.Name, maskValue(isSecret(x2), clb))
 					if _, vipername, _, _ := parseTags(x2); vipername != "" {
 						viperKeys[vipername] = true
 					}
 					continue
 				}
 
-				if err = loadHelper(cfg, vp, viperKeys, x2, v2); err != nil {
-					return
+				if lerr := loadHelper(cfg, vp, viperKeys, x2, v2); lerr != nil {
+					errs.add(x2.Name, bcfg.viperSource(x2), lerr)
+					continue
 				}
+				bcfg.recordValueSource(vp, x2)
 			}
 		} else {
+			// Command line values of slices with the append option are
+			// added to the configuration file or environment ones.
+			if sv, ok := appended[x.Name]; ok && sliceOptions(x).append {
+				cfg.Tracef("%s is on cmdline, appending %s", x.Name, maskValue(isSecret(x), noclobber[x.Name]))
+				field := v.FieldByIndex(x.Index)
+				field.Set(reflect.Zero(field.Type()))
+				if lerr := loadHelper(cfg, vp, viperKeys, x, v); lerr != nil {
+					errs.add(x.Name, bcfg.viperSource(x), lerr)
+					continue
+				}
+				values := reflect.MakeSlice(field.Type(), 0, field.Len()+sv.values.Len())
+				field.Set(reflect.AppendSlice(reflect.AppendSlice(values, field), sv.values))
+				continue
+			}
+
 			if clb, ok := noclobber[x.Name]; ok {
-				cfg.Tracef("%s is on cmdline, not touching it as it's already set to %s", x.Name, clb)
+				cfg.Tracef("%s is on cmdline, not touching it as it's already set to %s", x.Name, maskValue(isSecret(x), clb))
 				if _, vipername, _, _ := parseTags(x); vipername != "" {
 					viperKeys[vipername] = true
 				}
 				continue
 			}
 
-			if err = loadHelper(cfg, vp, viperKeys, x, v); err != nil {
-				return
+			if lerr := loadHelper(cfg, vp, viperKeys, x, v); lerr != nil {
+				// Map instances report each of their invalid values
+				if merrs, ok := lerr.(*ConfigErrors); ok {
+					for _, fe := range merrs.Errors {
+						errs.add(fe.Field, SourceFile, fe.Err)
+					}
+					continue
+				}
+				errs.add(x.Name, bcfg.viperSource(x), lerr)
+				continue
 			}
+			bcfg.recordValueSource(vp, x)
 		}
 	}
 
@@ -291,9 +915,9 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 
 	for _, vv := range baseConf.s_additionalEnv {
 		cfg.Tracef("Processing env overrides for %v", vv)
-		var evalue string
+		var evalue, ename string
 		if vv.Env != "" {
-			ename := baseConf.s_ucAppName + "_" + vv.Env
+			ename = baseConf.s_ucAppName + "_" + vv.Env
 			if evalue = os.Getenv(ename); evalue != "" {
 				baseConf.s_env[ename] = evalue
 			}
@@ -305,9 +929,11 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 			// env depending on which is set, env takes precedence.
 			if evalue != "" {
 				if _, ok := noclobber[vv.Name]; !ok {
-					cfg.Tracef("Assign env %s to %s", evalue, vv.Name)
-					if err = bcfg.setString(cfg, vv.Name, evalue); err != nil {
-						return
+					cfg.Tracef("Assign env %s to %s", maskValue(bcfg.isSecretField(vv.Name), evalue), vv.Name)
+					if serr := bcfg.setString(cfg, vv.Name, evalue); serr != nil {
+						errs.add(vv.Name, SourceEnv, serr)
+					} else {
+						bcfg.s_valueSources[vv.Name] = sourceEnv + ename
 					}
 				}
 			} else {
@@ -317,9 +943,11 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 			// If it has a cobra name (cmdline) and no viper (conf), if there was no
 			// cmdline the env, if present, takes precedence
 			if _, ok := noclobber[vv.Name]; evalue != "" && !ok {
-				cfg.Tracef("Assign env %s to %s", evalue, vv.Name)
-				if err = bcfg.setString(cfg, vv.Name, evalue); err != nil {
-					return
+				cfg.Tracef("Assign env %s to %s", maskValue(bcfg.isSecretField(vv.Name), evalue), vv.Name)
+				if serr := bcfg.setString(cfg, vv.Name, evalue); serr != nil {
+					errs.add(vv.Name, SourceEnv, serr)
+				} else {
+					bcfg.s_valueSources[vv.Name] = sourceEnv + ename
 				}
 			}
 		} else {
@@ -328,6 +956,10 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 		}
 	}
 
+	if err = errs.errorOrNil(); err != nil {
+		return
+	}
+
 	// No config file, nothing else to do
 	if noCfg {
 		cfg.Trace("No config file, nothing else to do")
@@ -339,6 +971,10 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 	readKeys := vp.AllKeys()
 	otherKeys := map[string]interface{}{}
 
+	// config upgrade comments out the keys that are no longer valid, so it
+	// has to be able to load a file containing them.
+	upgrading := ccmd != nil && ccmd == bcfg.s_cmds[doc.ConfigUpgradeCmd]
+
 	for _, v := range readKeys {
 		cfg.Tracef("Extra looking at %s", v)
 		if _, ok := viperKeys[v]; !ok && v != "" {
@@ -346,9 +982,18 @@ func (bcfg *BaseConfig) load(cfg Config, defaultCfgFileName string, ccmd *cobra.
 			if _, ok := extraKeys[v]; ok {
 				bcfg.Tracef("----- Extra key found %s", v)
 				otherKeys[v] = vp.Get(v)
+			} else if upgrading {
+				cfg.Tracef("Ignoring %s, the configuration file is being upgraded", v)
 			} else {
 				cfg.Trace("Possible syntax error")
-				err = fmt.Errorf("Invalid key(s) in the configuration file: %v", v)
+				// If more than one file was read, name the one the key came
+				// from.
+				hint := bcfg.didYouMean(v, cfgFileKeys(cfg))
+				if src, ok := bcfg.s_cfgSources[v]; ok && len(bcfg.s_usedConfs) > 1 {
+					err = fmt.Errorf("%w %s: %v%s", ErrUnknownKey, src, v, hint)
+				} else {
+					err = fmt.Errorf("%w: %v%s", ErrUnknownKey, v, hint)
+				}
 				return
 			}
 		}
@@ -7,7 +7,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -62,22 +61,38 @@ const rootCommandID = ""
 const sepKeyParts = "."
 const sepCmdParts = "|"
 const sepTag = ","
+
+// The optional fourth part of our tag, marking values that should not be
+// displayed or logged, and what is displayed instead of them.
+const tagSecret = "secret"
+const maskedValue = "********"
+
+// The options of the fourth part of our tag for slice fields: whether the
+// flag takes comma separated values rather than one value each time it is
+// repeated, whether command line values are appended to the configuration
+// file and environment ones rather than replacing them, and the separator of
+// the environment values, a comma by default.
+const tagCSV = "csv"
+const tagAppend = "append"
+const tagSep = "sep="
+const defaultSep = ','
 const sepCmdLevels = ">"
 const sepCmdArgs = "<"
 const sepMultipleCmds = "&"
 
 // process will take an initialized configuration and do anything that needs
 // to be done to make it ready for execution by the current command. Currently
-// it's only setting up the normal/pretty logging. This is called after the
-// configuration is loaded so all variables in cfg can be assumed to be
+// it's only setting up the normal/pretty logging, which goes to the log file if
+// one is configured and to the error writer otherwise. This is called after
+// the configuration is loaded so all variables in cfg can be assumed to be
 // correct.
 func (cfg *BaseConfig) process() error {
+	cfg.s_w = cfg.Err()
 	if cfg.LogFile != "" {
 		f, err := cfg.s_fs.OpenFile(cfg.LogFile,
 			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 		if err != nil {
-			return errors.WithMessage(err,
-				fmt.Sprintf("Cannot open log file %s", cfg.LogFile))
+			return fmt.Errorf("Cannot open log file %s: %w", cfg.LogFile, err)
 		}
 
 		cfg.s_filesToClose = append(cfg.s_filesToClose, f)
@@ -100,11 +115,13 @@ func (cfg *BaseConfig) process() error {
 
 // runWrapper executes the user command after loading the configuration and
 // processing it. It is possible for commands to run some last-minute
-// initializations by setting a pre-exec handler.
+// initializations by setting a pre-exec handler. The configuration is watched
+// only if watch is set, which it is for user commands and handlers but not
+// for the built-in commands, as they return right away.
 func runWrapper(ccmd *cobra.Command,
 	cfg *BaseConfig,
 	xcmd func(Config, []string) error,
-	args []string) (err error) {
+	args []string, watch bool) (err error) {
 
 	// NoEnv is only a cmdline parameter, and will be set already, if it is
 	// let's remove our environmental variables
@@ -136,6 +153,7 @@ func runWrapper(ccmd *cobra.Command,
 	if err = cfg.process(); err != nil {
 		return
 	}
+	cfg.checkEnv(cfg.s_cl)
 
 	if cfg.s_preExecHandler != nil {
 		if err = cfg.s_preExecHandler(cfg.s_cl, args); err != nil {
@@ -143,6 +161,12 @@ func runWrapper(ccmd *cobra.Command,
 		}
 	}
 
+	// Reloads happen only while the command is running
+	if cfg.s_watchCfg && watch {
+		cfg.watch(ccmd)
+		defer cfg.stopWatching()
+	}
+
 	cfg.Trace("runWrapper end, calling the command")
 	return xcmd(cfg.s_cl, args)
 }
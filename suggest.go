@@ -0,0 +1,206 @@
+package greenery
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// suggestDistance is the maximum edit distance for a name to be suggested as
+// the one likely intended.
+const suggestDistance = 2
+
+// editDistance returns the Levenshtein distance between the passed strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// suggest returns the candidate closest to the passed name, ignoring case, if
+// it is close enough to be a likely typo. Ties are broken alphabetically.
+func suggest(name string, candidates []string) string {
+	sorted := append([]string{}, candidates...)
+	sort.Strings(sorted)
+
+	best, bestDistance := "", suggestDistance+1
+	lname := strings.ToLower(name)
+	for _, c := range sorted {
+		if d := editDistance(lname, strings.ToLower(c)); d < bestDistance && d != 0 {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// didYouMean returns the localized suggestion for the passed unknown name,
+// to be appended to an error message, or an empty string if none of the
+// candidates is close enough.
+func (cfg *BaseConfig) didYouMean(name string, candidates []string) string {
+	s := suggest(name, candidates)
+	if s == "" {
+		return ""
+	}
+
+	text := "did you mean %s?"
+	if _, docs := cfg.GetDocs(); docs != nil && docs.DidYouMean != "" {
+		text = docs.DidYouMean
+	}
+	return ", " + strings.Replace(text, "%s", s, 1)
+}
+
+// cfgFileKeys returns the names of all the configuration file variables,
+// including the custom ones and the ones processed by the custom parser.
+func cfgFileKeys(icfg Config) []string {
+	var keys []string
+	helper := func(x reflect.StructField) {
+		if _, vipername, _, _ := parseTags(x); vipername != "" {
+			keys = append(keys, vipername)
+		}
+	}
+
+	t := reflect.TypeOf(icfg).Elem()
+	fields, _ := structFields(t)
+	for _, x := range fields {
+		if x.Type == basePType {
+			for i2 := 0; i2 < baseType.NumField(); i2++ {
+				helper(baseType.Field(i2))
+			}
+		} else {
+			helper(x)
+		}
+	}
+
+	if bcfg, err := getCfg(icfg); err == nil {
+		keys = append(keys, bcfg.s_extraWanted...)
+	}
+	return keys
+}
+
+// subcommandNames returns the names of the available subcommands of the
+// passed command.
+func subcommandNames(cmd *cobra.Command) []string {
+	var names []string
+	for _, c := range cmd.Commands() {
+		if c.IsAvailableCommand() {
+			names = append(names, c.Name())
+		}
+	}
+	return names
+}
+
+// noArgs is the positional arguments validator for commands that take no
+// arguments, any argument is assumed to be a mistyped subcommand.
+func (cfg *BaseConfig) noArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	return usageErrorf("unknown command %q for %q%s", args[0], cmd.CommandPath(), cfg.didYouMean(args[0], subcommandNames(cmd)))
+}
+
+// mistypedCmd returns an unknown command error if the passed argument is
+// close to the name of one of the subcommands of the passed command, and nil
+// otherwise.
+func (cfg *BaseConfig) mistypedCmd(cmd *cobra.Command, arg string) error {
+	s := cfg.didYouMean(arg, subcommandNames(cmd))
+	if s == "" {
+		return nil
+	}
+	return usageErrorf("unknown command %q for %q%s", arg, cmd.CommandPath(), s)
+}
+
+// flagError is the flag error handler for all the commands, adding a
+// suggestion to unknown flag errors. Flag errors are all usage errors.
+func (cfg *BaseConfig) flagError(cmd *cobra.Command, err error) error {
+	// Cobra needs this unchanged to show the help
+	if err == pflag.ErrHelp {
+		return err
+	}
+
+	const unknown = "unknown flag: --"
+	msg := err.Error()
+	if !strings.HasPrefix(msg, unknown) {
+		return &usageError{err: err}
+	}
+
+	var names []string
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if !f.Hidden {
+			names = append(names, "--"+f.Name)
+		}
+	})
+	return usageErrorf("%s%s", msg, cfg.didYouMean("--"+strings.TrimPrefix(msg, unknown), names))
+}
+
+// checkEnv warns about any environment variables with the application
+// prefix that are not used by it but look like one that is, as they are
+// likely to be mistyped.
+func (cfg *BaseConfig) checkEnv(icfg Config) {
+	if cfg.NoEnv {
+		return
+	}
+
+	prefix := cfg.s_ucAppName + "_"
+	known := map[string]bool{prefix + "PROFILE": true}
+	helper := func(x reflect.StructField) {
+		if _, _, viperenv, _ := parseTags(x); viperenv != "" {
+			known[prefix+viperenv] = true
+		}
+	}
+
+	t := reflect.TypeOf(icfg).Elem()
+	fields, _ := structFields(t)
+	for _, x := range fields {
+		if x.Type == basePType {
+			for i2 := 0; i2 < baseType.NumField(); i2++ {
+				helper(baseType.Field(i2))
+			}
+		} else {
+			helper(x)
+		}
+	}
+
+	names := make([]string, 0, len(known))
+	for k := range known {
+		names = append(names, k)
+	}
+
+	for _, e := range os.Environ() {
+		name := strings.SplitN(e, "=", 2)[0]
+		if !strings.HasPrefix(name, prefix) || known[name] {
+			continue
+		}
+
+		if s := cfg.didYouMean(name, names); s != "" {
+			cfg.Warnf("Unknown environment variable %s%s", name, s)
+		}
+	}
+}
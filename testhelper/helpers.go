@@ -1,6 +1,8 @@
 package testhelper
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"io/ioutil"
 	"os"
@@ -72,6 +74,11 @@ type TestCase struct {
 	// matched as a regex
 	ExecErrorRegex string
 
+	// ExecErrorIs is the expected exec function error (if any) this will be
+	// matched via errors.Is, so it can be used in addition to ExecError or
+	// ExecErrorRegex to check the wrapped greenery sentinel errors
+	ExecErrorIs error
+
 	// ExecErrorOutput will cause the output of the program (stdout/err/logs)
 	// to be checked even if the execution had an error
 	ExecErrorOutput bool
@@ -129,6 +136,11 @@ type TestCase struct {
 	UserDocList map[string]*greenery.DocSet
 	CfgFile     string
 
+	// CfgFileExtension is the extension of the temporary configuration file
+	// created from CfgContents / CfgFile, ".toml" if not set. This allows
+	// testing the other supported configuration file formats.
+	CfgFileExtension string
+
 	// GoldStdOut contains the gold expected standard output for this test case
 	GoldStdOut *TestFile
 
@@ -214,9 +226,9 @@ type TestCase struct {
 	// Pretty is set to true if the expected logging output is 'pretty'
 	// otherwise it's assumed to be standard JSON
 	Pretty bool
-	// Parallel makes this test case run parallel, parallel tests do not
-	// have their stdout/stderr captured, and only OutLog directives are taken
-	// into account.
+	// Parallel makes this test case run parallel, parallel tests capture
+	// only the output written via the configuration Out and Err writers,
+	// rather than everything written to stdout/stderr.
 	Parallel bool
 
 	// Internals
@@ -401,6 +413,10 @@ func RunTestCases(t *testing.T, tcs []TestCase, global TestRunnerOptions) error
 			var err error
 			compFuncs := map[string]CompareFunc{
 				"CfgLocation": CompareGetterToGetter,
+				"CfgFormat":   CompareGetterToGetter,
+				"CfgOutput":   CompareGetterToGetter,
+				"CfgExport":   CompareGetterToGetter,
+				"ErrorFormat": CompareGetterToGetter,
 				"Verbosity":   CompareGetterToGetter,
 				"LogLevel":    CompareGetterToGetter,
 			}
@@ -560,7 +576,12 @@ func RunTestCases(t *testing.T, tcs []TestCase, global TestRunnerOptions) error
 					cts = string(ctsb)
 				}
 
-				f, err = TempFileT(t, tc.af, "tcfg", ".toml", cts, removeList, mtx, tc.RealFilesystem)
+				ext := tc.CfgFileExtension
+				if ext == "" {
+					ext = ".toml"
+				}
+
+				f, err = TempFileT(t, tc.af, "tcfg", ext, cts, removeList, mtx, tc.RealFilesystem)
 				require.NoError(t, err)
 
 				if tc.CmdlineCfgName != "" {
@@ -588,10 +609,15 @@ func RunTestCases(t *testing.T, tcs []TestCase, global TestRunnerOptions) error
 			// Set up the final command line
 			cfg.TestHelper("set-root-args", cmdLine)
 
-			// Start the stdout/stderr grabbers if needed
+			// Start the stdout/stderr grabbers if needed, parallel tests
+			// cannot swap the process stdout/stderr so they use the
+			// configuration writers instead.
 			grabberOut := NewGrabber()
 			grabberErr := NewGrabber()
-			if !tc.Parallel {
+			var bufOut, bufErr bytes.Buffer
+			if tc.Parallel {
+				cfg.SetOutput(&bufOut, &bufErr)
+			} else {
 				require.NoError(t, grabberOut.Start(&os.Stdout))
 				defer func() {
 					_, _ = grabberOut.Stop()
@@ -641,6 +667,14 @@ func RunTestCases(t *testing.T, tcs []TestCase, global TestRunnerOptions) error
 
 			// Validate any errors expected in Execute
 			execHadErr := execErr != nil
+			if tc.ExecErrorIs != nil {
+				require.True(t, execErr != nil, "Was expecting error %v, but Execute operated successfully", tc.ExecErrorIs)
+				require.True(t, errors.Is(execErr, tc.ExecErrorIs), "Error \"%s\" is not \"%v\"", execErr.Error(), tc.ExecErrorIs)
+				if tc.ExecError == "" && tc.ExecErrorRegex == "" {
+					execErr = nil
+				}
+			}
+
 			if tc.ExecError != "" {
 				require.True(t, execErr != nil, "Was expecting error substring %s, but Execute operated successfully", tc.ExecError)
 				require.True(t, strings.Contains(execErr.Error(), tc.ExecError), "Error \"%s\" did not contain the error substring \"%s\"", execErr.Error(), tc.ExecError)
@@ -660,19 +694,21 @@ func RunTestCases(t *testing.T, tcs []TestCase, global TestRunnerOptions) error
 			}
 
 			// Validate stdout/stderr
+			outS, errS := bufOut.String(), bufErr.String()
 			if !tc.Parallel {
-				outS, errOut := grabberOut.Stop()
-				errS, errErr := grabberErr.Stop()
+				var errOut, errErr error
+				outS, errOut = grabberOut.Stop()
+				errS, errErr = grabberErr.Stop()
 
 				require.NoError(t, errOut)
 				require.NoError(t, errErr)
+			}
 
-				if tc.Trace {
-					t.Logf("Tracing stdout: %s\n", outS)
-					t.Logf("Tracing stderr: %s\n", errS)
-				} else {
-					verifyStdOutErr(t, &tc, outS, errS)
-				}
+			if tc.Trace {
+				t.Logf("Tracing stdout: %s\n", outS)
+				t.Logf("Tracing stderr: %s\n", errS)
+			} else {
+				verifyStdOutErr(t, &tc, outS, errS)
 			}
 
 			// Validate the logfile if required
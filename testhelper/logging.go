@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-
-	"github.com/pkg/errors"
 )
 
 type outGrabber struct {
@@ -82,7 +80,7 @@ func (sc *outGrabber) Stop() (string, error) {
 	}
 
 	if err := sc.outWriter.Close(); err != nil {
-		return "", errors.WithMessage(err, "error closing the writer")
+		return "", fmt.Errorf("error closing the writer: %w", err)
 	}
 
 	sc.outWriter = nil
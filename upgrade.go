@@ -0,0 +1,387 @@
+package greenery
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// obsoleteDoc is the documentation added to variables that are commented out
+// by config upgrade.
+const obsoleteDoc = "This variable is no longer used"
+
+// upgradeCfgFile returns the passed configuration file contents, and the
+// contents of the same file regenerated as config init would, keeping the
+// values it sets. Custom variables are kept as well, while variables that are
+// no longer used are commented out, which means they are dropped for JSON
+// files. Files with includes or profiles are not supported, given they would
+// have to be flattened.
+func (cfg *BaseConfig) upgradeCfgFile(name string) (old, upgraded []byte, err error) {
+	if old, err = afero.ReadFile(cfg.s_fs, name); err != nil {
+		return nil, nil, fmt.Errorf("Cannot read the config file %s: %w", name, err)
+	}
+
+	format := cfgFormat(name)
+	vp := viper.New()
+	vp.SetConfigType(format)
+	if err = vp.ReadConfig(bytes.NewReader(old)); err != nil {
+		return nil, nil, fmt.Errorf("%w %s: %v", ErrCfgParse, name, err)
+	}
+
+	if vp.Get(cfgIncludeKey) != nil || vp.Get(cfgProfileKey) != nil {
+		return nil, nil, fmt.Errorf("Cannot upgrade config file %s, files with includes or profiles are not supported", name)
+	}
+
+	// Start from the defaults, so that only the values in the file are kept
+	ocfg, _ := copyConfig(cfg.s_defaults)
+	viperKeys := map[string]bool{}
+	custom := append([]string{}, cfg.s_extraWanted...)
+	helper := func(x reflect.StructField, v reflect.Value) error {
+		cobra, vipername, _, _ := parseTags(x)
+		if vipername != "" && strings.HasSuffix(cobra, sepCmdParts+"custom") {
+			custom = append(custom, vipername)
+			return nil
+		}
+		if lerr := loadHelper(ocfg, vp, viperKeys, x, v); lerr != nil {
+			return &FieldError{Field: x.Name, Source: SourceFile, Err: lerr}
+		}
+		return nil
+	}
+
+	t := reflect.TypeOf(ocfg).Elem()
+	v := reflect.ValueOf(ocfg).Elem()
+	fields, _ := structFields(t)
+	for _, x := range fields {
+		if x.Type == basePType {
+			for i2 := 0; i2 < baseType.NumField(); i2++ {
+				if err = helper(baseType.Field(i2), v.FieldByIndex(x.Index).Elem()); err != nil {
+					return nil, nil, fmt.Errorf("Invalid value in config file %s: %w", name, err)
+				}
+			}
+		} else if err = helper(x, v); err != nil {
+			return nil, nil, fmt.Errorf("Invalid value in config file %s: %w", name, err)
+		}
+	}
+
+	lines, err := cfgLines(ocfg, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Secret values are kept as they are if they were set in the file,
+	// otherwise they are written the same way config init would.
+	for _, l := range lines {
+		if l.secret && !vp.IsSet(cfgKey(l.parent, l.child)) {
+			l.value = inlineValue(maskedValue, "toml")
+			l.data = maskedValue
+			l.commented = true
+		}
+	}
+
+	known := map[string]bool{}
+	for k := range viperKeys {
+		known[strings.ToLower(k)] = true
+	}
+
+	var added []*cfgLine
+	sort.Strings(custom)
+	for _, k := range custom {
+		lk := strings.ToLower(k)
+		if known[lk] || !vp.IsSet(lk) {
+			continue
+		}
+		known[lk] = true
+
+		parent, child := splitKey(k)
+		added = append(added, &cfgLine{
+			parent: parent,
+			child:  child,
+			value:  inlineValue(vp.Get(lk), format),
+		})
+	}
+
+	readKeys := vp.AllKeys()
+	sort.Strings(readKeys)
+outer:
+	for _, k := range readKeys {
+		for kk := range known {
+			if k == kk || strings.HasPrefix(k, kk+sepKeyParts) {
+				continue outer
+			}
+		}
+
+		cfg.Tracef("Commenting out %s, it is no longer used", k)
+		parent, child := splitKey(k)
+		added = append(added, &cfgLine{
+			parent:    parent,
+			child:     child,
+			doc:       obsoleteDoc,
+			value:     inlineValue(vp.Get(k), format),
+			commented: true,
+		})
+	}
+
+	for _, a := range added {
+		lines = insertCfgLine(lines, a)
+	}
+
+	var b bytes.Buffer
+	if err = executeCfgTemplate(&b, cfg, linesContents(ocfg, format, true, lines)); err != nil {
+		return nil, nil, err
+	}
+	return old, b.Bytes(), nil
+}
+
+// cfgKey returns the configuration file variable name for the passed section
+// and key.
+func cfgKey(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + sepKeyParts + child
+}
+
+// splitKey returns the section and key of the passed configuration file
+// variable name, the section can be a nested one.
+func splitKey(k string) (parent, child string) {
+	if i := strings.LastIndex(k, sepKeyParts); i != -1 {
+		return k[:i], k[i+1:]
+	}
+	return "", k
+}
+
+// insertCfgLine inserts the passed line after the last line in its section,
+// or creates a new section if there are no lines in it yet. New sections go
+// after the last line in the closest parent section, including its nested
+// sections, or at the end if there is none.
+func insertCfgLine(lines []*cfgLine, l *cfgLine) []*cfgLine {
+	at := -1
+	for i, v := range lines {
+		if v.parent == l.parent {
+			at = i + 1
+		}
+	}
+
+	parts := sectionParts(l.parent)
+	for n := len(parts) - 1; at == -1 && n > 0; n-- {
+		ancestor := strings.Join(parts[:n], sepKeyParts)
+		for i, v := range lines {
+			if v.parent == ancestor || strings.HasPrefix(v.parent, ancestor+sepKeyParts) {
+				at = i + 1
+			}
+		}
+	}
+
+	if at == -1 {
+		at = len(lines)
+	}
+	return append(lines[:at], append([]*cfgLine{l}, lines[at:]...)...)
+}
+
+var bareKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// yamlScalarRe matches the bare keys that YAML would read as something other
+// than a string, like numbers, booleans and null.
+var yamlScalarRe = regexp.MustCompile(`^([-+]?[0-9].*|(?i:y|n|yes|no|true|false|on|off|null))$`)
+
+// inlineValue serializes a value read from a configuration file so that it
+// can be written on a single line of a configuration file in the passed
+// format, tables are written as inline tables.
+func inlineValue(v interface{}, format string) string {
+	if format == "json" {
+		if b, err := json.Marshal(v); err == nil {
+			return string(b)
+		}
+	}
+
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+
+	if v == nil {
+		return "null"
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		if format == "toml" {
+			return tomlString(rv.String())
+		}
+		return strconv.Quote(rv.String())
+	case reflect.Slice, reflect.Array:
+		items := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, inlineValue(rv.Index(i).Interface(), format))
+		}
+		return "[ " + strings.Join(items, ", ") + " ]"
+	case reflect.Map:
+		sep := " = "
+		if format == "yaml" {
+			sep = ": "
+		}
+
+		keys := make([]string, 0, rv.Len())
+		values := map[string]interface{}{}
+		for _, k := range rv.MapKeys() {
+			ks := fmt.Sprint(k.Interface())
+			keys = append(keys, ks)
+			values[ks] = rv.MapIndex(k).Interface()
+		}
+		sort.Strings(keys)
+
+		items := make([]string, 0, len(keys))
+		for _, k := range keys {
+			qk := k
+			if !bareKeyRe.MatchString(k) || (format == "yaml" && yamlScalarRe.MatchString(k)) {
+				qk = strconv.Quote(k)
+			}
+			items = append(items, qk+sep+inlineValue(values[k], format))
+		}
+		if len(items) == 0 {
+			return "{}"
+		}
+		return "{ " + strings.Join(items, ", ") + " }"
+	}
+	return fmt.Sprint(v)
+}
+
+// tomlString returns the passed string as a TOML basic string. TOML has
+// fewer escape sequences than Go, so strconv.Quote cannot be used.
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// diffContext is the number of unchanged lines shown around each change by
+// lineDiff.
+const diffContext = 2
+
+// lineDiff returns the changes needed to obtain the to lines from the from
+// lines, in the unified diff format: removed lines are prefixed by -, added
+// ones by + and each group of changes is preceded by a @@ -a,b +c,d @@ line
+// with the line each side starts at and its number of lines, and shown with
+// some unchanged lines of context.
+func lineDiff(from, to []string) []string {
+	// Longest common subsequence, configuration files are small
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type op struct {
+		line string
+		i, j int
+	}
+
+	var ops []op
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			ops = append(ops, op{" " + from[i], i, j})
+			i++
+			j++
+		case j < len(to) && (i == len(from) || lcs[i][j+1] > lcs[i+1][j]):
+			ops = append(ops, op{"+" + to[j], i, j})
+			j++
+		default:
+			ops = append(ops, op{"-" + from[i], i, j})
+			i++
+		}
+	}
+
+	// Only show the changed lines and their context
+	show := make([]bool, len(ops))
+	for k, o := range ops {
+		if o.line[0] == ' ' {
+			continue
+		}
+		for c := k - diffContext; c <= k+diffContext; c++ {
+			if c >= 0 && c < len(ops) {
+				show[c] = true
+			}
+		}
+	}
+
+	var out []string
+	for k := 0; k < len(ops); k++ {
+		if !show[k] {
+			continue
+		}
+
+		// Each hunk header has the line ranges it covers
+		end := k
+		var fromCount, toCount int
+		for ; end < len(ops) && show[end]; end++ {
+			if ops[end].line[0] != '+' {
+				fromCount++
+			}
+			if ops[end].line[0] != '-' {
+				toCount++
+			}
+		}
+		out = append(out, fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(ops[k].i+1, fromCount), hunkRange(ops[k].j+1, toCount)))
+		for ; k < end; k++ {
+			out = append(out, ops[k].line)
+		}
+	}
+	return out
+}
+
+// hunkRange returns the line range of a hunk header, which starts at the
+// passed line and has the passed number of lines. As in diff an empty range
+// starts at the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
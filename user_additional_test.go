@@ -1,7 +1,10 @@
 package greenery_test
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -180,6 +183,278 @@ func TestHandlers(t *testing.T) {
 	require.NoError(t, err)
 }
 
+// exitCodeError is an error with its own exit code
+type exitCodeError struct {
+	code int
+}
+
+func (e exitCodeError) Error() string {
+	return fmt.Sprintf("exit with %d", e.code)
+}
+
+func (e exitCodeError) ExitCode() int {
+	return e.code
+}
+
+func TestExitCode(t *testing.T) {
+	exitCode := func(wanted int) func(*testing.T, greenery.Config) {
+		return func(t *testing.T, cfg greenery.Config) {
+			require.Equal(t, wanted, cfg.ExitCode())
+		}
+	}
+
+	handler := func(err error) map[string]greenery.Handler {
+		return map[string]greenery.Handler{
+			"version": func(cfg greenery.Config, args []string) error {
+				return err
+			},
+		}
+	}
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "success",
+			CmdLine: []string{
+				"version",
+			},
+			NoValidateConfigValues:  true,
+			OverrideBuiltinHandlers: true,
+			BuiltinHandlers:         handler(nil),
+			ValuesValidator:         exitCode(greenery.ExitOK),
+		},
+		testhelper.TestCase{
+			Name: "handler error",
+			CmdLine: []string{
+				"version",
+			},
+			NoValidateConfigValues:  true,
+			OutStdErrRegex:          "Usage:",
+			OverrideBuiltinHandlers: true,
+			BuiltinHandlers:         handler(fmt.Errorf("fail")),
+			ExecError:               "fail",
+			ExecErrorOutput:         true,
+			ValuesValidator:         exitCode(greenery.ExitHandlerError),
+		},
+		testhelper.TestCase{
+			Name: "custom exit code",
+			CmdLine: []string{
+				"version",
+			},
+			NoValidateConfigValues:  true,
+			OutStdErrRegex:          "Usage:",
+			OverrideBuiltinHandlers: true,
+			BuiltinHandlers:         handler(exitCodeError{42}),
+			ExecError:               "exit with 42",
+			ExecErrorOutput:         true,
+			ValuesValidator:         exitCode(42),
+		},
+		testhelper.TestCase{
+			Name: "unknown flag",
+			CmdLine: []string{
+				"version",
+				"--unknown",
+			},
+			NoValidateConfigValues: true,
+			OutStdErrRegex:         "Usage:",
+			ExecError:              "unknown flag: --unknown",
+			ExecErrorOutput:        true,
+			ValuesValidator:        exitCode(greenery.ExitUsageError),
+		},
+		testhelper.TestCase{
+			Name: "extra arguments",
+			CmdLine: []string{
+				"config",
+				"init",
+				"extra",
+			},
+			NoValidateConfigValues: true,
+			OutStdErrRegex:         "Usage:",
+			ExecError:              "The command does not support additional arguments",
+			ExecErrorOutput:        true,
+			ValuesValidator:        exitCode(greenery.ExitUsageError),
+		},
+		testhelper.TestCase{
+			Name:        "invalid configuration",
+			CfgContents: "verbosity = 7",
+			CmdLine: []string{
+				"version",
+			},
+			NoValidateConfigValues: true,
+			OutStdErrRegex:         "Usage:",
+			ExecError:              "Invalid value 7 for variable Verbosity",
+			ExecErrorOutput:        true,
+			ValuesValidator:        exitCode(greenery.ExitConfigError),
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: testhelper.NewSimpleConfig,
+	})
+	require.NoError(t, err)
+}
+
+func TestErrorFormat(t *testing.T) {
+	handler := map[string]greenery.Handler{
+		"version": func(cfg greenery.Config, args []string) error {
+			return fmt.Errorf("fail")
+		},
+	}
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "handler error",
+			CmdLine: []string{
+				"--error-format",
+				"json",
+				"version",
+			},
+			NoValidateConfigValues:  true,
+			OverrideBuiltinHandlers: true,
+			BuiltinHandlers:         handler,
+			ExecError:               "fail",
+			ExecErrorOutput:         true,
+			OutStdErr:               "{\"code\":1,\"message\":\"fail\"}\n",
+		},
+		testhelper.TestCase{
+			Name: "format set in the configuration file",
+			CfgContents: `error-format = "json"
+verbosity = 3`,
+			CmdLine: []string{
+				"version",
+			},
+			NoValidateConfigValues:  true,
+			OverrideBuiltinHandlers: true,
+			BuiltinHandlers:         handler,
+			ExecError:               "fail",
+			ExecErrorOutput:         true,
+			OutStdErr:               "{\"code\":1,\"message\":\"fail\"}\n",
+		},
+		testhelper.TestCase{
+			Name: "unknown flag",
+			CmdLine: []string{
+				"version",
+				"--unknown",
+				"--error-format=json",
+			},
+			NoValidateConfigValues: true,
+			ExecError:              "unknown flag: --unknown",
+			ExecErrorOutput:        true,
+			OutStdErr:              "{\"code\":2,\"message\":\"unknown flag: --unknown\"}\n",
+		},
+		testhelper.TestCase{
+			Name:        "invalid configuration",
+			CfgContents: "verbosity = 7",
+			CmdLine: []string{
+				"version",
+			},
+			Env: map[string]string{
+				"SIMPLE_ERRORFORMAT": "json",
+			},
+			NoValidateConfigValues: true,
+			ExecError:              "Invalid value 7 for variable Verbosity",
+			ExecErrorOutput:        true,
+			OutStdErr: "{\"code\":3,\"message\":\"Invalid value 7 for variable Verbosity, should be between 0 and 3 (set in the configuration file)\"," +
+				"\"field\":\"Verbosity\",\"source\":\"file\",\"causes\":[{\"message\":\"Invalid value 7 for variable Verbosity, should be between 0 and 3\"}]}\n",
+		},
+		testhelper.TestCase{
+			Name:        "several invalid values",
+			CfgContents: "verbosity = 7\nlog-level = \"loud\"",
+			CmdLine: []string{
+				"--error-format",
+				"json",
+				"version",
+			},
+			NoValidateConfigValues: true,
+			ExecError:              "Found 2 invalid configuration values",
+			ExecErrorOutput:        true,
+			OutStdErrRegex: "^{\"code\":3,\"message\":\"Found 2 invalid configuration values: [^\"]+\",\"causes\":\\[" +
+				"{\"message\":\"[^\"]+\",\"field\":\"LogLevel\",\"source\":\"file\",\"causes\":\\[[^\\]]+\\]}," +
+				"{\"message\":\"[^\"]+\",\"field\":\"Verbosity\",\"source\":\"file\",\"causes\":\\[[^\\]]+\\]}\\]}\n$",
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: testhelper.NewSimpleConfig,
+	})
+	require.NoError(t, err)
+}
+
+func TestOutput(t *testing.T) {
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "built-in command",
+			CmdLine: []string{
+				"version",
+			},
+			Parallel:  true,
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "help",
+			CmdLine: []string{
+				"--help",
+			},
+			Parallel:       true,
+			OutStdOutRegex: "^Usage:\n",
+		},
+		testhelper.TestCase{
+			Name: "handler output",
+			CmdLine: []string{
+				"version",
+			},
+			NoValidateConfigValues:  true,
+			Parallel:                true,
+			OutStdOut:               "out\n",
+			OutStdErr:               "err\n",
+			OverrideBuiltinHandlers: true,
+			BuiltinHandlers: map[string]greenery.Handler{
+				"version": func(cfg greenery.Config, args []string) error {
+					fmt.Fprintln(cfg.Out(), "out")
+					fmt.Fprintln(cfg.Err(), "err")
+					return nil
+				},
+			},
+		},
+		testhelper.TestCase{
+			Name: "usage on errors",
+			CmdLine: []string{
+				"version",
+				"--unknown",
+			},
+			NoValidateConfigValues: true,
+			Parallel:               true,
+			ExecError:              "unknown flag: --unknown",
+			ExecErrorOutput:        true,
+			OutStdOut:              "",
+			OutStdErrRegex:         "^Usage:\n",
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: testhelper.NewSimpleConfig,
+	})
+	require.NoError(t, err)
+
+	// Without a log file the logs go to the error writer, and the traces
+	// always go to the output writer
+	cfg := testhelper.NewSimpleConfig()
+	defer cfg.Cleanup()
+	var out, errOut bytes.Buffer
+	cfg.SetOutput(&out, &errOut)
+	cfg.SetFs(afero.NewMemMapFs())
+	require.NoError(t, cfg.SetHandler(greenery.OverrideVersionHandler, func(cfg greenery.Config, args []string) error {
+		cfg.Error("logged")
+		cfg.StartTracing()
+		cfg.Trace("traced")
+		cfg.StopTracing()
+		return nil
+	}))
+	cfg.TestHelper("set-root-args", []string{"--no-cfg", "version"})
+	require.NoError(t, cfg.Execute(cfg, nil))
+	require.Equal(t, "TRACE: traced\n", out.String())
+	require.Equal(t, "logged\n", errOut.String())
+}
+
 func TestSetOptions(t *testing.T) {
 	tcs := []testhelper.TestCase{
 		testhelper.TestCase{
@@ -1021,3 +1296,335 @@ func TestGetFS(t *testing.T) {
 	})
 	require.NoError(t, err)
 }
+
+// --------------------------------------------
+type secretConfig struct {
+	*greenery.BaseConfig
+
+	Pin   int    `greenery:"||none,       pin.code,   PIN,   secret"`
+	Token string `greenery:"|token|,      auth.token, TOKEN, secret"`
+	User  string `greenery:"|user|,       auth.user,  USER"`
+}
+
+func newSecretConfig() greenery.Config {
+	return &secretConfig{
+		BaseConfig: greenery.NewBaseConfig("secrets", nil),
+	}
+}
+
+type badSecretConfig struct {
+	*greenery.BaseConfig
+
+	Token string `greenery:"|token|, auth.token, TOKEN, hidden"`
+}
+
+func newBadSecretConfig() greenery.Config {
+	return &badSecretConfig{
+		BaseConfig: greenery.NewBaseConfig("secrets", nil),
+	}
+}
+
+var secretDocs = &greenery.DocSet{
+	CmdLine: map[string]string{
+		"Pin":   "test parameter",
+		"Token": "test parameter",
+		"User":  "test parameter",
+	},
+}
+
+func TestSecrets(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+
+	secrets := `[auth]
+token = "hunter2"
+user = "bob"
+
+[pin]
+code = 1234
+`
+
+	initValidator := func(name string, wanted ...string) func(*testing.T, greenery.Config) {
+		return func(t *testing.T, cfg greenery.Config) {
+			b, err := afero.ReadFile(cfg.GetFs(), filepath.Join(cwd, name))
+			require.NoError(t, err)
+			require.NotContains(t, string(b), "hunter2")
+			require.NotContains(t, string(b), "1234")
+			for _, w := range wanted {
+				require.Contains(t, string(b), w)
+			}
+		}
+	}
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Secrets are masked when displayed",
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			CfgContents:            secrets,
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "(?m)^Pin: \\*{8} \\(config file [^)]+\\)\nToken: \\*{8} \\(config file [^)]+\\)\nUser: bob \\(config file",
+		},
+		testhelper.TestCase{
+			Name: "Secrets are shown when revealed",
+			CmdLine: []string{
+				"config",
+				"display",
+				"--reveal",
+			},
+			CfgContents:            secrets,
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "(?m)^Pin: 1234 \\(config file [^)]+\\)\nToken: hunter2 \\(config file [^)]+\\)\nUser: bob \\(config file",
+		},
+		testhelper.TestCase{
+			Name: "Secrets are masked when displayed as a configuration file",
+			CmdLine: []string{
+				"config",
+				"display",
+				"--output",
+				"toml",
+			},
+			CfgContents:            secrets,
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "(?m)^# token = \"\\*{8}\"\nuser = \"bob\"\n\n\\[pin\\]\n# code = \"\\*{8}\"\n$",
+		},
+		testhelper.TestCase{
+			Name: "Secrets are shown when revealed as a configuration file",
+			CmdLine: []string{
+				"config",
+				"display",
+				"--output",
+				"json",
+				"--reveal",
+			},
+			CfgContents:            secrets,
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "\"token\": \"hunter2\"(?s:.*)\"code\": 1234",
+		},
+		testhelper.TestCase{
+			Name: "Secret environment variables are masked",
+			CmdLine: []string{
+				"config",
+				"env",
+			},
+			Env: map[string]string{
+				"SECRETS_TOKEN": "hunter2",
+				"SECRETS_USER":  "bob",
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "(?m)^  SECRETS_TOKEN -> \\*{8}\n  SECRETS_USER -> bob$",
+		},
+		testhelper.TestCase{
+			Name: "Secrets are commented out in a generated TOML configuration",
+			CmdLine: []string{
+				"config",
+				"init",
+			},
+			CfgContents:            secrets,
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file generated at ",
+			ValuesValidator:        initValidator("secrets.toml", "# token = \"********\"\n# test parameter\nuser = \"bob\"", "[pin]\n# test parameter\n# code = \"********\""),
+		},
+		testhelper.TestCase{
+			Name: "Secrets are commented out in a generated YAML configuration",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--format",
+				"yaml",
+			},
+			CfgContents:            secrets,
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file generated at ",
+			ValuesValidator:        initValidator("secrets.yaml", "  # token: \"********\"\n", "# pin:\n  # test parameter\n  # code: \"********\""),
+		},
+		testhelper.TestCase{
+			Name: "Secrets are omitted in a generated JSON configuration",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--format",
+				"json",
+			},
+			CfgContents:            secrets,
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file generated at ",
+			ValuesValidator:        initValidator("secrets.json", "\"auth\": {\n    \"user\": \"bob\"\n  }\n"),
+		},
+		testhelper.TestCase{
+			Name: "A generated configuration with commented out secrets is valid",
+			CmdLine: []string{
+				"version",
+			},
+			CfgContents: "[auth]\n# token = \"********\"\nuser = \"bob\"\n\n[pin]\n# code = \"********\"\n",
+			ExpectedValues: map[string]testhelper.Comparer{
+				"User": testhelper.Comparer{Value: "bob"},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Secrets are not in the trace dump",
+			CmdLine: []string{
+				"--token",
+				"flag-secret",
+				"version",
+			},
+			Env: map[string]string{
+				"SECRETS_TOKEN": "env-secret",
+			},
+			CfgContents:            "[auth]\ntoken = \"file-secret\"\nuser = \"bob\"\n",
+			NoValidateConfigValues: true,
+			ValuesValidator: func(t *testing.T, icfg greenery.Config) {
+				cfg := icfg.(*secretConfig)
+				cfg.DoTrace = true
+				out, err := cfg.Dump(cfg)
+				require.NoError(t, err)
+				require.Contains(t, out, "bob")
+				for _, s := range []string{"flag-secret", "env-secret", "file-secret"} {
+					require.NotContains(t, out, s)
+				}
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Invalid tag option",
+			CmdLine: []string{
+				"version",
+			},
+			ConfigGen: newBadSecretConfig,
+			ExecError: "Invalid tag for Token, unknown option 'hidden'",
+		},
+	}
+
+	for _, format := range []string{"json", "toml", "yaml"} {
+		gold := filepath.Join("testdata", "additional_test.TestSecrets.display."+format)
+		tcs = append(tcs,
+			testhelper.TestCase{
+				Name: "Secrets are commented out when displayed as " + format,
+				CmdLine: []string{
+					"config",
+					"display",
+					"--output",
+					format,
+				},
+				CfgContents:            secrets,
+				NoValidateConfigValues: true,
+				GoldStdOut:             &testhelper.TestFile{Source: gold, Custom: testhelper.CompareIgnoreTmp},
+			},
+			testhelper.TestCase{
+				Name: "Secrets are not set when loading the " + format + " display output",
+				CmdLine: []string{
+					"version",
+				},
+				CfgFile:                gold,
+				CfgFileExtension:       "." + format,
+				NoValidateConfigValues: true,
+				ValuesValidator: func(t *testing.T, icfg greenery.Config) {
+					cfg := icfg.(*secretConfig)
+					require.Equal(t, "", cfg.Token)
+					require.Equal(t, 0, cfg.Pin)
+					require.Equal(t, "bob", cfg.User)
+				},
+				OutStdOut: "0.0\n",
+			})
+	}
+
+	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newSecretConfig,
+		UserDocList: map[string]*greenery.DocSet{
+			"": secretDocs},
+	})
+	require.NoError(t, err)
+}
+
+func TestEnvExport(t *testing.T) {
+	env := map[string]string{
+		"SECRETS_TOKEN": "hunter2",
+		"SECRETS_USER":  "bob's",
+	}
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Export for bash",
+			CmdLine: []string{
+				"config",
+				"env",
+				"--export",
+				"bash",
+			},
+			Env:                    env,
+			NoValidateConfigValues: true,
+			OutStdOut:              "# test parameter\n# export SECRETS_TOKEN='********'\n# test parameter\nexport SECRETS_USER='bob'\\''s'\n",
+		},
+		testhelper.TestCase{
+			Name: "Export for zsh",
+			CmdLine: []string{
+				"config",
+				"env",
+				"--export",
+				"zsh",
+			},
+			Env:                    env,
+			NoValidateConfigValues: true,
+			OutStdOut:              "# test parameter\n# export SECRETS_TOKEN='********'\n# test parameter\nexport SECRETS_USER='bob'\\''s'\n",
+		},
+		testhelper.TestCase{
+			Name: "Export for fish",
+			CmdLine: []string{
+				"config",
+				"env",
+				"--export",
+				"fish",
+			},
+			Env:                    env,
+			NoValidateConfigValues: true,
+			OutStdOut:              "# test parameter\n# set -x SECRETS_TOKEN '********'\n# test parameter\nset -x SECRETS_USER 'bob\\'s'\n",
+		},
+		testhelper.TestCase{
+			Name: "Export for PowerShell",
+			CmdLine: []string{
+				"config",
+				"env",
+				"--export",
+				"powershell",
+			},
+			Env:                    env,
+			NoValidateConfigValues: true,
+			OutStdOut:              "# test parameter\n# $env:SECRETS_TOKEN = '********'\n# test parameter\n$env:SECRETS_USER = 'bob''s'\n",
+		},
+		testhelper.TestCase{
+			Name: "Export all the variables",
+			CmdLine: []string{
+				"config",
+				"env",
+				"--export",
+				"bash",
+				"--all",
+			},
+			Env: map[string]string{
+				"SECRETS_USER": "bob",
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "(?m)^export SECRETS_LOGLEVEL='error'\n(?s:.*)^# export SECRETS_PIN='\\*{8}'\n(?s:.*)^export SECRETS_USER='bob'\n# [^\n]+\nexport SECRETS_VERBOSITY='1'\n$",
+		},
+		testhelper.TestCase{
+			Name: "Invalid shell",
+			CmdLine: []string{
+				"config",
+				"env",
+				"--export",
+				"csh",
+			},
+			ExecError: "invalid argument \"csh\" for \"--export\" flag: Invalid value csh for variable CfgExport, should be one of bash, zsh, fish, powershell",
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newSecretConfig,
+		UserDocList: map[string]*greenery.DocSet{
+			"": secretDocs},
+	})
+	require.NoError(t, err)
+}
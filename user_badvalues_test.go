@@ -1,7 +1,9 @@
 package greenery_test
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -74,7 +76,8 @@ func TestBadConfig(t *testing.T) {
 					},
 				},
 			},
-			ExecError: "Command name te&st contains an invalid character, any of <|&, or space is not permitted",
+			ExecError:   "Command name te&st contains an invalid character, any of <|&, or space is not permitted",
+			ExecErrorIs: greenery.ErrInvalidCommand,
 		},
 		testhelper.TestCase{
 			Name: "Bad command 2",
@@ -97,7 +100,8 @@ func TestBadConfig(t *testing.T) {
 					},
 				},
 			},
-			ExecError: "Command name te,st contains an invalid character, any of <|&, or space is not permitted",
+			ExecError:   "Command name te,st contains an invalid character, any of <|&, or space is not permitted",
+			ExecErrorIs: greenery.ErrInvalidCommand,
 		},
 		testhelper.TestCase{
 			Name: "Conf variable, no command",
@@ -164,12 +168,12 @@ func TestBadConfig(t *testing.T) {
 			ConfigGen: func() greenery.Config {
 				return &struct {
 					*greenery.BaseConfig
-					TestParam string `greenery:"test|testParam|, .more.than.two,"`
+					TestParam string `greenery:"test|testParam|, server..name,"`
 				}{
 					BaseConfig: greenery.NewBaseConfig("partial", fmap),
 				}
 			},
-			ExecError: "more than two . present in '.more.than.two'",
+			ExecError: "empty section or variable name in 'server..name'",
 		},
 		testhelper.TestCase{
 			Name: "Conf variable, bad cfg tag, no .",
@@ -282,7 +286,8 @@ func TestBadConfig(t *testing.T) {
 					BaseConfig: greenery.NewBaseConfig("partial", fmap),
 				}
 			},
-			ExecError: "No documentation for command line parameter testParam (variable TestParam3)",
+			ExecError:   "No documentation for command line parameter testParam (variable TestParam3)",
+			ExecErrorIs: greenery.ErrMissingDocs,
 		},
 	}
 
@@ -1041,7 +1046,8 @@ func TestBadFlagValuesCfg(t *testing.T) {
 				"int",
 			},
 			// In this case toml fails
-			ExecError: "Could not parse config file",
+			ExecError:   "Could not parse config file",
+			ExecErrorIs: greenery.ErrCfgParse,
 		},
 		testhelper.TestCase{
 			Name:        "Bad value int64 2",
@@ -1050,7 +1056,8 @@ func TestBadFlagValuesCfg(t *testing.T) {
 				"int",
 			},
 			// In this case toml fails
-			ExecError: "Could not parse config file",
+			ExecError:   "Could not parse config file",
+			ExecErrorIs: greenery.ErrCfgParse,
 		},
 		testhelper.TestCase{
 			Name:        "Max value int64",
@@ -1174,7 +1181,8 @@ func TestBadFlagValuesCfg(t *testing.T) {
 			},
 			// In this case toml fails, unfortunately TOML assumes int64 so we
 			// can't get the full range of uint64 values
-			ExecError: "Could not parse config file",
+			ExecError:   "Could not parse config file",
+			ExecErrorIs: greenery.ErrCfgParse,
 		},
 		testhelper.TestCase{
 			Name:        "Max value uint64",
@@ -1213,7 +1221,8 @@ func TestBadFlagValuesCfg(t *testing.T) {
 				"float",
 			},
 			// In this case toml fails
-			ExecError: "Could not parse config file",
+			ExecError:   "Could not parse config file",
+			ExecErrorIs: greenery.ErrCfgParse,
 		},
 		testhelper.TestCase{
 			Name:        "Max value float64",
@@ -1329,6 +1338,69 @@ func TestBadFlagValuesCfg(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestBadValuesAll(t *testing.T) {
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name:        "All bad values reported",
+			CfgContents: "[int]\nint16 = 40000",
+			Env: map[string]string{
+				"EXTRA_INT8": "128",
+				"EXTRA_TIME": "not a time",
+			},
+			CmdLine: []string{
+				"int",
+			},
+			ExecError: "Found 3 invalid configuration values: " +
+				"Cannot convert flag value int.int8: 128 would overflow an int8 (set in the environment); " +
+				"Cannot convert flag value int.int16: 40000 would overflow an int16 (set in the configuration file); " +
+				"Cannot convert flag value via unmarshaling: parsing time",
+			ExecErrorIs: greenery.ErrInvalidValue,
+		},
+		testhelper.TestCase{
+			Name: "One bad value",
+			Env: map[string]string{
+				"EXTRA_INT8": "128",
+			},
+			CmdLine: []string{
+				"int",
+			},
+			ExecErrorRegex: "^Cannot convert flag value int.int8: 128 would overflow an int8 \\(set in the environment\\)$",
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen:  testhelper.NewExtraConfig,
+		CompareMap: testhelper.ExtraConfigCustomComparers,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &testhelper.ExtraDocs}})
+	require.NoError(t, err)
+
+	// The individual errors are available to the caller
+	os.Setenv("EXTRA_INT8", "128")
+	os.Setenv("EXTRA_TIME", "not a time")
+	defer os.Unsetenv("EXTRA_INT8")
+	defer os.Unsetenv("EXTRA_TIME")
+
+	cfg := testhelper.NewExtraConfig()
+	defer cfg.Cleanup()
+	cfg.TestHelper("set-root-args", []string{"--no-cfg", "int"})
+	err = cfg.Execute(cfg, map[string]*greenery.DocSet{"en": &testhelper.ExtraDocs})
+
+	var cerr *greenery.ConfigErrors
+	require.True(t, errors.As(err, &cerr), "%v", err)
+	require.Len(t, cerr.Errors, 2)
+	require.Equal(t, "Int8", cerr.Errors[0].Field)
+	require.Equal(t, greenery.SourceEnv, cerr.Errors[0].Source)
+	require.Equal(t, "Time", cerr.Errors[1].Field)
+	require.Equal(t, greenery.SourceEnv, cerr.Errors[1].Source)
+	require.Contains(t, cerr.Errors[1].Unwrap().Error(), "parsing time")
+	require.True(t, errors.Is(err, greenery.ErrInvalidValue))
+
+	var ferr *greenery.FieldError
+	require.True(t, errors.As(err, &ferr))
+	require.Equal(t, "Int8", ferr.Field)
+}
+
 func TestBadConfFile(t *testing.T) {
 	tcs := []testhelper.TestCase{
 		testhelper.TestCase{
@@ -1371,7 +1443,8 @@ func TestNoCustomDeser(t *testing.T) {
 			CfgFile: goldDefault,
 			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.displayextracfg"),
 				Custom: testhelper.CompareIgnoreTmp},
-			ExecError: "Invalid key(s)",
+			ExecError:   "Invalid key(s)",
+			ExecErrorIs: greenery.ErrUnknownKey,
 		},
 		testhelper.TestCase{
 			Name: "Invalid keys",
@@ -1408,7 +1481,8 @@ value = "v3"
 `,
 			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.displayextracfg"),
 				Custom: testhelper.CompareIgnoreTmp},
-			ExecError: "Invalid key(s)",
+			ExecError:   "Invalid key(s)",
+			ExecErrorIs: greenery.ErrUnknownKey,
 		},
 		testhelper.TestCase{
 			Name: "Custom vars not all processed",
@@ -2,13 +2,17 @@ package greenery_test
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/shibukawa/configdir"
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
 	"github.com/woodensquares/greenery"
 	"github.com/woodensquares/greenery/testhelper"
@@ -196,6 +200,149 @@ func TestConfigInitDefault(t *testing.T) {
 			OutStdOutRegex: "^Configuration file generated at " + cwdConf + "\n$",
 			RealFilesystem: true,
 		},
+		testhelper.TestCase{
+			Name: "Default yaml conf file found in cwd",
+			CmdLine: []string{
+				"version",
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".yaml", Contents: []byte("verbosity: 2\n"), Perms: 0644},
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Default json conf file found in the user directory",
+			CmdLine: []string{
+				"version",
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: filepath.Join(filepath.Dir(userConf), cmdTestName+".json"),
+					Contents: []byte("{\"verbosity\": 3}\n"), Perms: 0644},
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 3, Accessor: "GetTyped"},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Merged conf files, system < user < cwd",
+			CmdLine: []string{
+				"version",
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: filepath.Join(filepath.Dir(systemConf), cmdTestName+".toml"),
+					Contents: []byte("verbosity = 2\nlog-level = \"info\"\n"), Perms: 0644},
+				testhelper.TestFile{Location: filepath.Join(filepath.Dir(userConf), cmdTestName+".yaml"),
+					Contents: []byte("verbosity: 3\n"), Perms: 0644},
+				testhelper.TestFile{Location: cmdTestName + ".json",
+					Contents: []byte("{\"log-level\": \"warn\"}\n"), Perms: 0644},
+			},
+			ConfigDefaults: &greenery.BaseConfigOptions{
+				VersionMajor:     "0",
+				VersionMinor:     "0",
+				MergeConfigFiles: true,
+			},
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				c := cfg.(*cmdsBaseConfig)
+				require.Equal(t, 3, c.Verbosity.GetTyped())
+				require.Equal(t, "warn", c.LogLevel.GetTyped())
+				require.Equal(t, []string{
+					filepath.Join(filepath.Dir(systemConf), cmdTestName+".toml"),
+					filepath.Join(filepath.Dir(userConf), cmdTestName+".yaml"),
+					filepath.Join(cwd, cmdTestName+".json"),
+				}, cfg.GetConfigFiles())
+				require.Equal(t, filepath.Join(cwd, cmdTestName+".json"), cfg.GetConfigFile())
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Merged conf files, system < user < --config",
+			CmdLine: []string{
+				"version",
+			},
+			CfgContents: "log-level = \"warn\"\n",
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: filepath.Join(filepath.Dir(systemConf), cmdTestName+".toml"),
+					Contents: []byte("verbosity = 2\nlog-level = \"info\"\n"), Perms: 0644},
+				testhelper.TestFile{Location: cmdTestName + ".json",
+					Contents: []byte("{\"verbosity\": 0}\n"), Perms: 0644},
+			},
+			ConfigDefaults: &greenery.BaseConfigOptions{
+				VersionMajor:     "0",
+				VersionMinor:     "0",
+				MergeConfigFiles: true,
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
+				"LogLevel":  testhelper.Comparer{Value: "warn", Accessor: "GetTyped"},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Merged conf files, --config without a directory is looked for",
+			CmdLine: []string{
+				"version",
+			},
+			CfgContents:    "verbosity = 0\n",
+			CmdlineCfgName: "other.toml",
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: filepath.Join(filepath.Dir(systemConf), cmdTestName+".toml"),
+					Contents: []byte("verbosity = 2\nlog-level = \"info\"\n"), Perms: 0644},
+				testhelper.TestFile{Location: filepath.Join(filepath.Dir(userConf), "other.toml"),
+					Contents: []byte("log-level = \"warn\"\n"), Perms: 0644},
+			},
+			ConfigDefaults: &greenery.BaseConfigOptions{
+				VersionMajor:     "0",
+				VersionMinor:     "0",
+				MergeConfigFiles: true,
+			},
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				c := cfg.(*cmdsBaseConfig)
+				require.Equal(t, 2, c.Verbosity.GetTyped())
+				require.Equal(t, "warn", c.LogLevel.GetTyped())
+				require.Equal(t, []string{
+					filepath.Join(filepath.Dir(systemConf), cmdTestName+".toml"),
+					filepath.Join(filepath.Dir(userConf), "other.toml"),
+				}, cfg.GetConfigFiles())
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Merged conf files, display",
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			CfgContents: "verbosity = 2\n",
+			ConfigDefaults: &greenery.BaseConfigOptions{
+				VersionMajor:     "0",
+				VersionMinor:     "0",
+				MergeConfigFiles: true,
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
+			},
+			OutStdOutRegex: "(?m)^Merged config files, if any \\(accessible via GetConfigFiles\\): /.*/tcfg[0-9]+\\.toml$",
+		},
+		testhelper.TestCase{
+			Name: "Not merged conf files, cwd has precedence",
+			CmdLine: []string{
+				"version",
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: filepath.Join(filepath.Dir(userConf), cmdTestName+".yaml"),
+					Contents: []byte("verbosity: 3\n"), Perms: 0644},
+				testhelper.TestFile{Location: cmdTestName + ".json",
+					Contents: []byte("{\"log-level\": \"warn\"}\n"), Perms: 0644},
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"LogLevel": testhelper.Comparer{Value: "warn", Accessor: "GetTyped"},
+			},
+			OutStdOut: "0.0\n",
+		},
 		testhelper.TestCase{
 			Name: "Display a generated config",
 			CmdLine: []string{
@@ -402,386 +549,939 @@ func TestConfigInitDefault(t *testing.T) {
 	require.NoError(t, err)
 }
 
-func TestConfigInitExtra(t *testing.T) {
-	goldDefault := filepath.Join("testdata", cmdTestName+".TestConfig.extracfg")
+type mergedValuesConfig struct {
+	*greenery.BaseConfig
+	Big    int64             `greenery:"|big|, .big, BIG"`
+	Labels map[string]string `greenery:"|label|, .labels, LABELS"`
+}
 
-	cwd, err := os.Getwd()
-	require.NoError(t, err)
-	cwdConf := filepath.Join(cwd, "extra.toml")
-	ptime := time.Date(2014, time.June, 3, 12, 8, 32, 454, time.UTC)
-	ptime2 := time.Date(1978, time.May, 27, 7, 32, 00, 00, time.UTC)
+func newMergedValuesConfig() greenery.Config {
+	return &mergedValuesConfig{
+		BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+	}
+}
+
+func TestConfigMergedValues(t *testing.T) {
+	cfDir := configdir.New(cmdTestName, cmdTestName+".toml")
+	systemConf := cfDir.QueryFolders(configdir.System)[0].Path
 
 	tcs := []testhelper.TestCase{
 		testhelper.TestCase{
-			Name: "Default extra config",
+			Name: "Integers are kept with a profile table",
 			CmdLine: []string{
-				"config",
-				"init",
+				"version",
 			},
-			GoldFiles: []testhelper.TestFile{
-				testhelper.TestFile{Location: "extra.toml", Source: goldDefault, Perms: 0644,
-					Custom: testhelper.CompareIgnoreTmp},
+			CfgContents: "big = 9007199254740993\n\n[profile.dev]\nverbosity = 2\n",
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Big": testhelper.Comparer{Value: int64(9007199254740993)},
 			},
-			OutStdOutRegex: "^Configuration file generated at " + cwdConf + "\n$",
+			OutStdOut: "0.0\n",
 		},
 		testhelper.TestCase{
-			Name: "help for custom flags",
+			Name: "Integers are kept in a profile",
 			CmdLine: []string{
-				"flag",
-				"-h",
+				"--profile",
+				"dev",
+				"version",
 			},
-			NoValidateConfigValues: true,
-			GoldStdOut:             &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestCommandsAdditional.helpflag.stdout")},
-		},
-		testhelper.TestCase{
-			Name: "Display a generated extra config with custom deserialization",
-			CmdLine: []string{
-				"config",
-				"display",
+			CfgContents: "big = 1\n\n[profile.dev]\nbig = 9007199254740995\n",
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Profile": testhelper.Comparer{Value: "dev"},
+				"Big":     testhelper.Comparer{Value: int64(9007199254740995)},
 			},
-			CustomVars:   testhelper.ExtraConfigCustomVars,
-			CustomParser: testhelper.ExtraConfigCustomParse,
-			CfgFile:      goldDefault,
-			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.displayextracfg"),
-				Custom: testhelper.CompareIgnoreTmp},
+			OutStdOut: "0.0\n",
 		},
 		testhelper.TestCase{
-			Name: "Display a generated extra config with custom deserialization, validate different values in config from default",
+			Name: "Integers are kept across layers",
 			CmdLine: []string{
-				"config",
-				"display",
+				"version",
+			},
+			CfgContents: "verbosity = 2\n",
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: filepath.Join(filepath.Dir(systemConf), cmdTestName+".toml"),
+					Contents: []byte("big = 9007199254740993\n"), Perms: 0644},
+			},
+			ConfigDefaults: &greenery.BaseConfigOptions{
+				VersionMajor:     "0",
+				VersionMinor:     "0",
+				MergeConfigFiles: true,
 			},
-			CustomVars:   testhelper.ExtraConfigCustomVars,
-			CustomParser: testhelper.ExtraConfigCustomParse,
-			CfgFile:      filepath.Join("testdata", cmdTestName+".TestConfig.extracustomcfg"),
-			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.displayextracustomcfg"),
-				Custom: testhelper.CompareIgnoreTmp},
 			ExpectedValues: map[string]testhelper.Comparer{
-				"LogLevel":  testhelper.Comparer{Value: "info", Accessor: "GetTyped"},
-				"NoEnv":     testhelper.Comparer{Value: true},
-				"NoCfg":     testhelper.Comparer{Value: false},
-				"Pretty":    testhelper.Comparer{Value: true},
 				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
-				"Bool":      testhelper.Comparer{Value: false},
-				"NameValue": testhelper.Comparer{Value: [][]string{
-					[]string{"j1", "v1"},
-					[]string{"j2", "v2"},
-					[]string{"j3", "v3"},
-				}},
-				"NameEnum": testhelper.Comparer{Value: [][]string{
-					[]string{"j1", "a"},
-					[]string{"j2", "b"},
-					[]string{"j3", "c"},
-				}},
-				"FlagIP":      testhelper.Comparer{Value: "192.168.1.1", Accessor: "GetTyped"},
-				"FlagPort":    testhelper.Comparer{Value: greenery.PortValue(443)},
-				"FlagCString": testhelper.Comparer{Value: "HIHIHI", Accessor: "GetTyped"},
-				"FlagEnum":    testhelper.Comparer{Value: "c", Accessor: "GetTyped"},
-				"FlagInt":     testhelper.Comparer{Value: 500, Accessor: "GetTyped"},
-				"Float32":     testhelper.Comparer{Value: float32(13.34)},
-				"Float64":     testhelper.Comparer{Value: float64(-57.78)},
-				"Int":         testhelper.Comparer{Value: -11},
-				"Int8":        testhelper.Comparer{Value: int8(-12)},
-				"Int16":       testhelper.Comparer{Value: int16(-13)},
-				"Int32":       testhelper.Comparer{Value: int32(-14)},
-				"Int64":       testhelper.Comparer{Value: int64(-15)},
-				"Uint":        testhelper.Comparer{Value: uint(11)},
-				"Uint8":       testhelper.Comparer{Value: uint8(12)},
-				"Uint16":      testhelper.Comparer{Value: uint16(13)},
-				"Uint32":      testhelper.Comparer{Value: uint32(14)},
-				"Uint64":      testhelper.Comparer{Value: uint64(15)},
-				"String":      testhelper.Comparer{Value: "other"},
-				"PTime":       testhelper.Comparer{Value: &ptime},
-				"Time":        testhelper.Comparer{Value: time.Date(2015, time.June, 3, 12, 8, 32, 454, time.UTC)},
-				"Duration":    testhelper.Comparer{Value: time.Hour*48 + time.Minute*16 + time.Second*32 + time.Millisecond*44},
-
-				"SliceString": testhelper.Comparer{Value: []string{"hi", "there"}},
-				"SliceInt":    testhelper.Comparer{Value: []int{5, 6, 7}},
+				"Big":       testhelper.Comparer{Value: int64(9007199254740993)},
 			},
+			OutStdOut: "0.0\n",
 		},
 		testhelper.TestCase{
-			Name: "Check env variable overrides for all extra variables",
+			Name: "Keys and values are kept as strings",
 			CmdLine: []string{
 				"version",
 			},
-			Env: map[string]string{
-				"EXTRA_BOOL": "false",
-
-				"EXTRA_FLAGIP":      "10.0.0.1",
-				"EXTRA_FLAGPORT":    "333",
-				"EXTRA_FLAGENUM":    "a",
-				"EXTRA_FLAGCSTRING": "HI",
-				"EXTRA_FLAGINT":     "600",
-
-				"EXTRA_FLOAT32": "11.11",
-				"EXTRA_FLOAT64": "-11.11",
-
-				"EXTRA_INT":      "-10",
-				"EXTRA_INT8":     "-11",
-				"EXTRA_INT16":    "-12",
-				"EXTRA_INT32":    "-13",
-				"EXTRA_INT64":    "-14",
-				"EXTRA_UINT":     "10",
-				"EXTRA_UINT8":    "11",
-				"EXTRA_UINT16":   "12",
-				"EXTRA_UINT32":   "13",
-				"EXTRA_UINT64":   "14",
-				"EXTRA_PTIME":    "1978-05-27T07:32:00Z",
-				"EXTRA_TIME":     "1979-05-27T07:32:00Z",
-				"EXTRA_DURATION": "115892045000000",
-
-				"EXTRA_STRING": "test",
-			},
-			CustomVars:   testhelper.ExtraConfigCustomVars,
-			CustomParser: testhelper.ExtraConfigCustomParse,
-			OutStdOut:    "0.0\n",
+			CfgContents: "labels:\n  \"on\": \"yes\"\n  \"1\": \"null\"\n  quoted: \"a \\\"b\\\" \\\\c\"\n" +
+				"profile:\n  dev:\n    verbosity: 2\n",
+			CfgFileExtension: ".yaml",
 			ExpectedValues: map[string]testhelper.Comparer{
-				"Bool":        testhelper.Comparer{Value: false},
-				"FlagIP":      testhelper.Comparer{Value: "10.0.0.1", Accessor: "GetTyped"},
-				"FlagPort":    testhelper.Comparer{Value: greenery.PortValue(333)},
-				"FlagCString": testhelper.Comparer{Value: "HI", Accessor: "GetTyped"},
-				"FlagEnum":    testhelper.Comparer{Value: "a", Accessor: "GetTyped"},
-				"FlagInt":     testhelper.Comparer{Value: 600, Accessor: "GetTyped"},
-				"Float32":     testhelper.Comparer{Value: float32(11.11)},
-				"Float64":     testhelper.Comparer{Value: float64(-11.11)},
-				"Int":         testhelper.Comparer{Value: -10},
-				"Int8":        testhelper.Comparer{Value: int8(-11)},
-				"Int16":       testhelper.Comparer{Value: int16(-12)},
-				"Int32":       testhelper.Comparer{Value: int32(-13)},
-				"Int64":       testhelper.Comparer{Value: int64(-14)},
-				"Uint":        testhelper.Comparer{Value: uint(10)},
-				"Uint8":       testhelper.Comparer{Value: uint8(11)},
-				"Uint16":      testhelper.Comparer{Value: uint16(12)},
-				"Uint32":      testhelper.Comparer{Value: uint32(13)},
-				"Uint64":      testhelper.Comparer{Value: uint64(14)},
-				"String":      testhelper.Comparer{Value: "test"},
-				"PTime":       testhelper.Comparer{Value: &ptime2},
-				"Time":        testhelper.Comparer{Value: time.Date(1979, time.May, 27, 7, 32, 00, 00, time.UTC)},
-				"Duration":    testhelper.Comparer{Value: time.Hour*32 + time.Minute*11 + time.Second*32 + time.Millisecond*45},
-
-				"SliceString": testhelper.Comparer{Value: []string{"first", "second", "third"}},
-				"SliceInt":    testhelper.Comparer{Value: []int{1, 2, 3, 4}},
-
-				// No env for these or base values, so default
-				"LogLevel":  testhelper.Comparer{Value: "error", Accessor: "GetTyped"},
-				"Pretty":    testhelper.Comparer{Value: false},
-				"Verbosity": testhelper.Comparer{Value: 1, Accessor: "GetTyped"},
-				"NameValue": testhelper.Comparer{Value: [][]string{
-					[]string{"k1", "v1"},
-					[]string{"k2", "v2"},
-					[]string{"k3", "v3"},
-				}},
-				"NameEnum": testhelper.Comparer{Value: [][]string{
-					[]string{"k1", "a"},
-					[]string{"k2", "b"},
-					[]string{"k3", "c"},
-				}},
+				"Labels": testhelper.Comparer{Value: map[string]string{"on": "yes", "1": "null", "quoted": "a \"b\" \\c"}},
 			},
+			OutStdOut: "0.0\n",
 		},
 	}
 
-	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
-		ConfigGen:  testhelper.NewExtraConfig,
-		CompareMap: testhelper.ExtraConfigCustomComparers,
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newMergedValuesConfig,
 		UserDocList: map[string]*greenery.DocSet{
-			"": &testhelper.ExtraDocs}})
+			"": &greenery.DocSet{
+				CmdLine: map[string]string{
+					"Big":    "a big number",
+					"Labels": "the labels",
+				},
+			},
+		}})
 	require.NoError(t, err)
 }
 
-func TestConfigEnv(t *testing.T) {
-	goldDefaultExtra := filepath.Join("testdata", cmdTestName+".TestConfig.extracfg")
+func TestConfigIncludes(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	mainConf := filepath.Join(cwd, cmdTestName+".toml")
+	fragment := filepath.Join(cwd, cmdTestName+".d", "10-fragment.json")
 
-	tcs1 := []testhelper.TestCase{
+	tcs := []testhelper.TestCase{
 		testhelper.TestCase{
-			Name: "Config env no env affecting noextra",
+			Name: "Includes and fragments",
 			CmdLine: []string{
-				"config",
-				"env",
+				"version",
 			},
-			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.default.noenv")},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Contents: []byte("include = [\"inc/a.toml\", \"inc/*.yaml\"]\nverbosity = 2\n"), Perms: 0644},
+				testhelper.TestFile{Location: filepath.Join("inc", "a.toml"),
+					Contents: []byte("verbosity = 3\nlog-level = \"info\"\n"), Perms: 0644},
+				testhelper.TestFile{Location: filepath.Join("inc", "b.yaml"),
+					Contents: []byte("log-level: warn\n"), Perms: 0644},
+				testhelper.TestFile{Location: fragment,
+					Contents: []byte("{\"pretty\": true}\n"), Perms: 0644},
+			},
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				c := cfg.(*cmdsBaseConfig)
+				require.Equal(t, 2, c.Verbosity.GetTyped())
+				require.Equal(t, "warn", c.LogLevel.GetTyped())
+				require.Equal(t, true, c.Pretty)
+				require.Equal(t, []string{
+					filepath.Join(cwd, "inc", "a.toml"),
+					filepath.Join(cwd, "inc", "b.yaml"),
+					mainConf,
+					fragment,
+				}, cfg.GetConfigFiles())
+				require.Equal(t, mainConf, cfg.GetConfigFile())
+			},
+			OutStdOut: "0.0\n",
 		},
 		testhelper.TestCase{
-			Name: "Config env with env affecting noextra",
+			Name: "Include cycle",
 			CmdLine: []string{
-				"config",
-				"env",
+				"version",
 			},
-			Env: map[string]string{
-				"SIMPLE_VERBOSITY": "0",
-				"SIMPLE_LOGLEVEL":  "debug",
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Contents: []byte("include = [\"inc/a.toml\"]\n"), Perms: 0644},
+				testhelper.TestFile{Location: filepath.Join("inc", "a.toml"),
+					Contents: []byte("include = \"../" + cmdTestName + ".toml\"\n"), Perms: 0644},
 			},
-			ExpectedValues: map[string]testhelper.Comparer{
-				"Verbosity": testhelper.Comparer{Value: 0, Accessor: "GetTyped"},
-				"LogLevel":  testhelper.Comparer{Value: "debug", Accessor: "GetTyped"},
+			ExecError: "Configuration file include cycle: " + mainConf + " -> " +
+				filepath.Join(cwd, "inc", "a.toml") + " -> " + mainConf,
+		},
+		testhelper.TestCase{
+			Name: "Missing include",
+			CmdLine: []string{
+				"version",
 			},
-			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.default.env")},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Contents: []byte("include = [\"inc/missing.toml\", \"inc/*.json\"]\n"), Perms: 0644},
+			},
+			ExecError: "Cannot find configuration file " + filepath.Join(cwd, "inc", "missing.toml") +
+				", included by " + mainConf,
+		},
+		testhelper.TestCase{
+			Name: "Invalid include directive",
+			CmdLine: []string{
+				"version",
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Contents: []byte("include = 5\n"), Perms: 0644},
+			},
+			ExecError: "Invalid include directive in " + mainConf,
+		},
+		testhelper.TestCase{
+			Name: "Invalid key in a fragment",
+			CmdLine: []string{
+				"version",
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Contents: []byte("verbosity = 2\n"), Perms: 0644},
+				testhelper.TestFile{Location: fragment,
+					Contents: []byte("{\"verbositi\": 3}\n"), Perms: 0644},
+			},
+			ExecError: "Invalid key(s) in the configuration file " + fragment + ": verbositi",
 		},
 	}
 
-	err := testhelper.RunTestCases(t, tcs1, testhelper.TestRunnerOptions{
-		ConfigGen: testhelper.NewSimpleConfig,
+	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newCmdsBaseConfig,
 	})
+
 	require.NoError(t, err)
+}
 
-	tcs2 := []testhelper.TestCase{
+func TestConfigProfiles(t *testing.T) {
+	profiles := `verbosity = 2
+log-level = "info"
+
+[profile.prod]
+verbosity = 0
+
+[profile.dev]
+log-level = "debug"
+`
+
+	tcs := []testhelper.TestCase{
 		testhelper.TestCase{
-			Name: "Extra config env no env affecting extra",
+			Name: "No profile",
 			CmdLine: []string{
-				"config",
-				"env",
+				"version",
 			},
-			CustomVars:   testhelper.ExtraConfigCustomVars,
-			CustomParser: testhelper.ExtraConfigCustomParse,
-			CfgFile:      goldDefaultExtra,
-			GoldStdOut:   &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.extra.noenv")},
+			CfgContents: profiles,
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
+				"LogLevel":  testhelper.Comparer{Value: "info", Accessor: "GetTyped"},
+			},
+			OutStdOut: "0.0\n",
 		},
 		testhelper.TestCase{
-			Name: "Config env with env affecting extra",
+			Name: "Profile from the command line",
 			CmdLine: []string{
-				"config",
-				"env",
+				"--profile",
+				"prod",
+				"version",
+			},
+			CfgContents: profiles,
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Profile":   testhelper.Comparer{Value: "prod"},
+				"Verbosity": testhelper.Comparer{Value: 0, Accessor: "GetTyped"},
+				"LogLevel":  testhelper.Comparer{Value: "info", Accessor: "GetTyped"},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Profile from the environment",
+			CmdLine: []string{
+				"version",
 			},
-			CustomVars:   testhelper.ExtraConfigCustomVars,
-			CustomParser: testhelper.ExtraConfigCustomParse,
-			CfgFile:      goldDefaultExtra,
 			Env: map[string]string{
-				"EXTRA_VERBOSITY": "0",
-				"EXTRA_LOGLEVEL":  "debug",
-				"EXTRA_STRING":    "hi",
+				"CMDS_TEST_PROFILE": "dev",
 			},
+			CfgContents: profiles,
 			ExpectedValues: map[string]testhelper.Comparer{
-				"Verbosity": testhelper.Comparer{Value: 0, Accessor: "GetTyped"},
+				"Profile":   testhelper.Comparer{Value: "dev"},
+				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
 				"LogLevel":  testhelper.Comparer{Value: "debug", Accessor: "GetTyped"},
-				"String":    testhelper.Comparer{Value: "hi"},
 			},
-			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.extra.env")},
+			OutStdOut: "0.0\n",
 		},
-	}
-
-	err = testhelper.RunTestCases(t, tcs2, testhelper.TestRunnerOptions{
-		ConfigGen:  testhelper.NewExtraConfig,
-		CompareMap: testhelper.ExtraConfigCustomComparers,
-		UserDocList: map[string]*greenery.DocSet{
-			"": &testhelper.ExtraDocs}})
-	require.NoError(t, err)
-
-	// Doc only for conf, not cmd, check that env still works
-	confEnvConf := func() greenery.Config {
-		return &struct {
-			*greenery.BaseConfig
-			TestParam string `greenery:"test|testParam|, , TESTPARAM"`
-		}{
-			BaseConfig: greenery.NewBaseConfig("partial", map[string]greenery.Handler{
-				"test": testhelper.NopNoArgs,
-			}),
-			TestParam: "default",
-		}
-	}
-
-	docs := map[string]*greenery.DocSet{
-		"": &greenery.DocSet{
-			Usage: map[string]*greenery.CmdHelp{
-				"test": &greenery.CmdHelp{
-					Short: "test",
-				},
+		testhelper.TestCase{
+			Name: "Profile from the command line has precedence over the environment",
+			CmdLine: []string{
+				"--profile",
+				"prod",
+				"version",
 			},
-			CmdLine: map[string]string{
-				"TestParam": "test parameter",
+			Env: map[string]string{
+				"CMDS_TEST_PROFILE": "dev",
 			},
-		}}
-
-	tcs3 := []testhelper.TestCase{
+			CfgContents: profiles,
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Profile":   testhelper.Comparer{Value: "prod"},
+				"Verbosity": testhelper.Comparer{Value: 0, Accessor: "GetTyped"},
+				"LogLevel":  testhelper.Comparer{Value: "info", Accessor: "GetTyped"},
+			},
+			OutStdOut: "0.0\n",
+		},
 		testhelper.TestCase{
-			Name: "Partial doc no env",
+			Name: "Unknown profile",
 			CmdLine: []string{
-				"config",
-				"env",
+				"--profile",
+				"staging",
+				"version",
 			},
-			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.partial.noenv")},
+			CfgContents: profiles,
+			ExecError:   "Unknown configuration profile staging, available profiles: dev, prod",
 		},
 		testhelper.TestCase{
-			Name: "Partial doc with env affecting",
+			Name: "List profiles",
 			CmdLine: []string{
+				"--profile",
+				"prod",
 				"config",
-				"env",
-			},
-			Env: map[string]string{
-				"PARTIAL_VERBOSITY": "0",
-				"PARTIAL_LOGLEVEL":  "debug",
-				"PARTIAL_TESTPARAM": "hi",
+				"profiles",
 			},
+			CfgContents: profiles,
 			ExpectedValues: map[string]testhelper.Comparer{
+				"Profile":   testhelper.Comparer{Value: "prod"},
 				"Verbosity": testhelper.Comparer{Value: 0, Accessor: "GetTyped"},
+				"LogLevel":  testhelper.Comparer{Value: "info", Accessor: "GetTyped"},
+			},
+			OutStdOut: "  dev\n* prod\n",
+		},
+		testhelper.TestCase{
+			Name: "Display the active profile",
+			CmdLine: []string{
+				"--profile",
+				"dev",
+				"config",
+				"display",
+			},
+			CfgContents: profiles,
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Profile":   testhelper.Comparer{Value: "dev"},
+				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
 				"LogLevel":  testhelper.Comparer{Value: "debug", Accessor: "GetTyped"},
-				"TestParam": testhelper.Comparer{Value: "hi"},
 			},
-			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.partial.env")},
+			OutStdOutRegex: "(?m)^Profile: dev \\(flag --profile\\)$",
 		},
 	}
 
-	err = testhelper.RunTestCases(t, tcs3, testhelper.TestRunnerOptions{
-		ConfigGen:   confEnvConf,
-		UserDocList: docs,
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newCmdsBaseConfig,
 	})
+
 	require.NoError(t, err)
 }
 
-// Custom flag with only the minimal number of methods required, Set, String
-// and UnmarshalText. Note since Type is not present the help will not be as
-// good. This will end up serializing in the config file as a string
-type minimalValue uint16
+// watchHandler returns a version handler that registers a configuration
+// change callback and then changes the configuration file to each of the
+// passed contents in turn, optionally signalling SIGHUP after each change,
+// waiting for the callback to report the wanted verbosity.
+func watchHandler(hup bool, want int, contents ...string) greenery.Handler {
+	return func(cfg greenery.Config, args []string) error {
+		changed := make(chan int, 1)
+		cfg.OnConfigChange(func(old, cur greenery.Config) {
+			if cur.(*cmdsBaseConfig).Verbosity.GetTyped() != want {
+				return
+			}
+			select {
+			case changed <- old.(*cmdsBaseConfig).Verbosity.GetTyped():
+			default:
+			}
+		})
 
-func (p *minimalValue) Set(e string) error {
-	var i int
+		for _, c := range contents {
+			if err := afero.WriteFile(cfg.GetFs(), cfg.GetConfigFile(), []byte(c), 0644); err != nil {
+				return err
+			}
 
-	if e == "" {
-		i = 0
-	} else {
-		var err error
-		i, err = strconv.Atoi(e)
+			if hup {
+				if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+					return err
+				}
+			}
+		}
+
+		select {
+		case old := <-changed:
+			fmt.Printf("verbosity %d -> %d\n", old, want)
+		case <-time.After(5 * time.Second):
+			return fmt.Errorf("configuration not reloaded")
+		}
+		return nil
+	}
+}
+
+// invalidWatchHandler returns a version handler that changes the
+// configuration file to an invalid one, checking after the reload failed
+// that the current values are kept, and then to a valid one.
+func invalidWatchHandler(cfg greenery.Config, args []string) error {
+	changed := make(chan int, 1)
+	cfg.OnConfigChange(func(old, cur greenery.Config) {
+		select {
+		case changed <- old.(*cmdsBaseConfig).Verbosity.GetTyped():
+		default:
+		}
+	})
+
+	reload := func(c string) error {
+		if err := afero.WriteFile(cfg.GetFs(), cfg.GetConfigFile(), []byte(c), 0644); err != nil {
+			return err
+		}
+		return syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	}
+
+	if err := reload("verbosity = 7\n"); err != nil {
+		return err
+	}
+
+	// The failed reload is logged
+	logFile := cfg.Snapshot().(*cmdsBaseConfig).LogFile
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, err := afero.ReadFile(cfg.GetFs(), logFile)
 		if err != nil {
-			return fmt.Errorf("%s, cannot be converted to an integer", e)
+			return err
+		}
+		if strings.Contains(string(b), "Cannot reload the configuration") {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("invalid configuration not reported")
 		}
+		time.Sleep(10 * time.Millisecond)
 	}
 
-	if i < 0 || i > 65535 {
-		return fmt.Errorf("Minimal flags must be between 0 and 65535, have %d", i)
+	if v := cfg.Snapshot().(*cmdsBaseConfig).Verbosity.GetTyped(); v != 3 {
+		return fmt.Errorf("invalid configuration reloaded, verbosity is %d", v)
 	}
 
-	*p = minimalValue(i)
+	// Including the values read from the file
+	var v int
+	if err := cfg.Unmarshal("verbosity", &v); err != nil {
+		return err
+	}
+	if v != 3 {
+		return fmt.Errorf("invalid configuration file kept, verbosity is %d", v)
+	}
+
+	if err := reload("verbosity = 0\n"); err != nil {
+		return err
+	}
+
+	select {
+	case old := <-changed:
+		fmt.Printf("verbosity %d -> %d\n", old, cfg.Snapshot().(*cmdsBaseConfig).Verbosity.GetTyped())
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("configuration not reloaded")
+	}
 	return nil
 }
-func (p *minimalValue) String() string                  { return strconv.Itoa(int(*p)) }
-func (p *minimalValue) UnmarshalText(text []byte) error { return p.Set(string(text)) }
 
-func TestConfigInitCustom(t *testing.T) {
+func TestConfigWatch(t *testing.T) {
 	tcs := []testhelper.TestCase{
 		testhelper.TestCase{
-			Name: "Generate the config",
+			Name: "Reload on SIGHUP",
 			CmdLine: []string{
-				"config",
-				"init",
+				"version",
 			},
-			GoldFiles: []testhelper.TestFile{
-				testhelper.TestFile{Location: cmdTestName + ".toml",
-					Source: filepath.Join("testdata", cmdTestName+".TestConfig.customcfg"), Perms: 0644,
-					Custom: testhelper.CompareIgnoreTmp},
+			CfgContents: "verbosity = 3\n",
+			ConfigDefaults: &greenery.BaseConfigOptions{
+				WatchConfig: true,
 			},
-			OutStdOutRegex: "Configuration file generated",
+			OverrideBuiltinHandlers: true,
+			BuiltinHandlers: map[string]greenery.Handler{
+				"version": watchHandler(true, 2, "verbosity = 2\n"),
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
+			},
+			OutStdOut: "verbosity 3 -> 2\n",
 		},
 		testhelper.TestCase{
-			Name: "Display it",
+			Name: "Invalid configuration is not reloaded",
 			CmdLine: []string{
-				"config",
-				"display",
+				"version",
 			},
-			CfgFile: filepath.Join("testdata", cmdTestName+".TestConfig.customcfg"),
-			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.customdisplaycfg"),
-				Custom: testhelper.CompareIgnoreTmp},
+			CfgContents: "verbosity = 3\n",
+			ConfigDefaults: &greenery.BaseConfigOptions{
+				WatchConfig: true,
+			},
+			OverrideBuiltinHandlers: true,
+			BuiltinHandlers: map[string]greenery.Handler{
+				"version": invalidWatchHandler,
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 0, Accessor: "GetTyped"},
+			},
+			OutStdOut: "verbosity 3 -> 0\n",
+		},
+		testhelper.TestCase{
+			Name: "Reload on file change",
+			CmdLine: []string{
+				"version",
+			},
+			CfgContents: "verbosity = 3\n",
+			ConfigDefaults: &greenery.BaseConfigOptions{
+				WatchConfig: true,
+			},
+			OverrideBuiltinHandlers: true,
+			BuiltinHandlers: map[string]greenery.Handler{
+				"version": watchHandler(false, 2, "verbosity = 2\n"),
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
+			},
+			OutStdOut:      "verbosity 3 -> 2\n",
+			RealFilesystem: true,
 		},
 	}
 
-	// Override this to make the generated config file header constant
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newCmdsBaseConfig,
+	})
+	require.NoError(t, err)
+}
+
+func TestConfigInitExtra(t *testing.T) {
+	goldDefault := filepath.Join("testdata", cmdTestName+".TestConfig.extracfg")
+	goldYaml := filepath.Join("testdata", cmdTestName+".TestConfig.extrayamlcfg")
+	goldJSON := filepath.Join("testdata", cmdTestName+".TestConfig.extrajsoncfg")
+
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	cwdConf := filepath.Join(cwd, "extra.toml")
+	ptime := time.Date(2014, time.June, 3, 12, 8, 32, 454, time.UTC)
+	ptime2 := time.Date(1978, time.May, 27, 7, 32, 00, 00, time.UTC)
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Default extra config",
+			CmdLine: []string{
+				"config",
+				"init",
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: "extra.toml", Source: goldDefault, Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			OutStdOutRegex: "^Configuration file generated at " + cwdConf + "\n$",
+		},
+		testhelper.TestCase{
+			Name: "Default extra config, yaml",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--format",
+				"yaml",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgFormat": testhelper.Comparer{Value: "yaml", Accessor: "GetTyped"},
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: "extra.yaml", Source: goldYaml, Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			OutStdOutRegex: "^Configuration file generated at " + filepath.Join(cwd, "extra.yaml") + "\n$",
+		},
+		testhelper.TestCase{
+			Name: "Default extra config, json",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--format",
+				"json",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgFormat": testhelper.Comparer{Value: "json", Accessor: "GetTyped"},
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: "extra.json", Source: goldJSON, Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			OutStdOutRegex: "^Configuration file generated at " + filepath.Join(cwd, "extra.json") + "\n$",
+		},
+		testhelper.TestCase{
+			Name: "Default extra config, invalid format",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--format",
+				"xml",
+			},
+			ExecError: "invalid argument \"xml\" for \"--format\" flag",
+		},
+		testhelper.TestCase{
+			Name: "Display a generated extra yaml config",
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			CustomVars:       testhelper.ExtraConfigCustomVars,
+			CustomParser:     testhelper.ExtraConfigCustomParse,
+			CfgFile:          goldYaml,
+			CfgFileExtension: ".yaml",
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.displayextracfg"),
+				Custom: testhelper.CompareIgnoreTmp},
+		},
+		testhelper.TestCase{
+			Name: "Display a generated extra json config",
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			CustomVars:       testhelper.ExtraConfigCustomVars,
+			CustomParser:     testhelper.ExtraConfigCustomParse,
+			CfgFile:          goldJSON,
+			CfgFileExtension: ".json",
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.displayextracfg"),
+				Custom: testhelper.CompareIgnoreTmp},
+		},
+		testhelper.TestCase{
+			Name: "help for custom flags",
+			CmdLine: []string{
+				"flag",
+				"-h",
+			},
+			NoValidateConfigValues: true,
+			GoldStdOut:             &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestCommandsAdditional.helpflag.stdout")},
+		},
+		testhelper.TestCase{
+			Name: "Display a generated extra config with custom deserialization",
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			CustomVars:   testhelper.ExtraConfigCustomVars,
+			CustomParser: testhelper.ExtraConfigCustomParse,
+			CfgFile:      goldDefault,
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.displayextracfg"),
+				Custom: testhelper.CompareIgnoreTmp},
+		},
+		testhelper.TestCase{
+			Name: "Display a generated extra config with custom deserialization, validate different values in config from default",
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			CustomVars:   testhelper.ExtraConfigCustomVars,
+			CustomParser: testhelper.ExtraConfigCustomParse,
+			CfgFile:      filepath.Join("testdata", cmdTestName+".TestConfig.extracustomcfg"),
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.displayextracustomcfg"),
+				Custom: testhelper.CompareIgnoreTmp},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"LogLevel":  testhelper.Comparer{Value: "info", Accessor: "GetTyped"},
+				"NoEnv":     testhelper.Comparer{Value: true},
+				"NoCfg":     testhelper.Comparer{Value: false},
+				"Pretty":    testhelper.Comparer{Value: true},
+				"Verbosity": testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
+				"Bool":      testhelper.Comparer{Value: false},
+				"NameValue": testhelper.Comparer{Value: [][]string{
+					[]string{"j1", "v1"},
+					[]string{"j2", "v2"},
+					[]string{"j3", "v3"},
+				}},
+				"NameEnum": testhelper.Comparer{Value: [][]string{
+					[]string{"j1", "a"},
+					[]string{"j2", "b"},
+					[]string{"j3", "c"},
+				}},
+				"FlagIP":      testhelper.Comparer{Value: "192.168.1.1", Accessor: "GetTyped"},
+				"FlagPort":    testhelper.Comparer{Value: greenery.PortValue(443)},
+				"FlagCString": testhelper.Comparer{Value: "HIHIHI", Accessor: "GetTyped"},
+				"FlagEnum":    testhelper.Comparer{Value: "c", Accessor: "GetTyped"},
+				"FlagInt":     testhelper.Comparer{Value: 500, Accessor: "GetTyped"},
+				"Float32":     testhelper.Comparer{Value: float32(13.34)},
+				"Float64":     testhelper.Comparer{Value: float64(-57.78)},
+				"Int":         testhelper.Comparer{Value: -11},
+				"Int8":        testhelper.Comparer{Value: int8(-12)},
+				"Int16":       testhelper.Comparer{Value: int16(-13)},
+				"Int32":       testhelper.Comparer{Value: int32(-14)},
+				"Int64":       testhelper.Comparer{Value: int64(-15)},
+				"Uint":        testhelper.Comparer{Value: uint(11)},
+				"Uint8":       testhelper.Comparer{Value: uint8(12)},
+				"Uint16":      testhelper.Comparer{Value: uint16(13)},
+				"Uint32":      testhelper.Comparer{Value: uint32(14)},
+				"Uint64":      testhelper.Comparer{Value: uint64(15)},
+				"String":      testhelper.Comparer{Value: "other"},
+				"PTime":       testhelper.Comparer{Value: &ptime},
+				"Time":        testhelper.Comparer{Value: time.Date(2015, time.June, 3, 12, 8, 32, 454, time.UTC)},
+				"Duration":    testhelper.Comparer{Value: time.Hour*48 + time.Minute*16 + time.Second*32 + time.Millisecond*44},
+
+				"SliceString": testhelper.Comparer{Value: []string{"hi", "there"}},
+				"SliceInt":    testhelper.Comparer{Value: []int{5, 6, 7}},
+			},
+		},
+		testhelper.TestCase{
+			Name: "Check env variable overrides for all extra variables",
+			CmdLine: []string{
+				"version",
+			},
+			Env: map[string]string{
+				"EXTRA_BOOL": "false",
+
+				"EXTRA_FLAGIP":      "10.0.0.1",
+				"EXTRA_FLAGPORT":    "333",
+				"EXTRA_FLAGENUM":    "a",
+				"EXTRA_FLAGCSTRING": "HI",
+				"EXTRA_FLAGINT":     "600",
+
+				"EXTRA_FLOAT32": "11.11",
+				"EXTRA_FLOAT64": "-11.11",
+
+				"EXTRA_INT":      "-10",
+				"EXTRA_INT8":     "-11",
+				"EXTRA_INT16":    "-12",
+				"EXTRA_INT32":    "-13",
+				"EXTRA_INT64":    "-14",
+				"EXTRA_UINT":     "10",
+				"EXTRA_UINT8":    "11",
+				"EXTRA_UINT16":   "12",
+				"EXTRA_UINT32":   "13",
+				"EXTRA_UINT64":   "14",
+				"EXTRA_PTIME":    "1978-05-27T07:32:00Z",
+				"EXTRA_TIME":     "1979-05-27T07:32:00Z",
+				"EXTRA_DURATION": "115892045000000",
+
+				"EXTRA_STRING": "test",
+			},
+			CustomVars:   testhelper.ExtraConfigCustomVars,
+			CustomParser: testhelper.ExtraConfigCustomParse,
+			OutStdOut:    "0.0\n",
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Bool":        testhelper.Comparer{Value: false},
+				"FlagIP":      testhelper.Comparer{Value: "10.0.0.1", Accessor: "GetTyped"},
+				"FlagPort":    testhelper.Comparer{Value: greenery.PortValue(333)},
+				"FlagCString": testhelper.Comparer{Value: "HI", Accessor: "GetTyped"},
+				"FlagEnum":    testhelper.Comparer{Value: "a", Accessor: "GetTyped"},
+				"FlagInt":     testhelper.Comparer{Value: 600, Accessor: "GetTyped"},
+				"Float32":     testhelper.Comparer{Value: float32(11.11)},
+				"Float64":     testhelper.Comparer{Value: float64(-11.11)},
+				"Int":         testhelper.Comparer{Value: -10},
+				"Int8":        testhelper.Comparer{Value: int8(-11)},
+				"Int16":       testhelper.Comparer{Value: int16(-12)},
+				"Int32":       testhelper.Comparer{Value: int32(-13)},
+				"Int64":       testhelper.Comparer{Value: int64(-14)},
+				"Uint":        testhelper.Comparer{Value: uint(10)},
+				"Uint8":       testhelper.Comparer{Value: uint8(11)},
+				"Uint16":      testhelper.Comparer{Value: uint16(12)},
+				"Uint32":      testhelper.Comparer{Value: uint32(13)},
+				"Uint64":      testhelper.Comparer{Value: uint64(14)},
+				"String":      testhelper.Comparer{Value: "test"},
+				"PTime":       testhelper.Comparer{Value: &ptime2},
+				"Time":        testhelper.Comparer{Value: time.Date(1979, time.May, 27, 7, 32, 00, 00, time.UTC)},
+				"Duration":    testhelper.Comparer{Value: time.Hour*32 + time.Minute*11 + time.Second*32 + time.Millisecond*45},
+
+				"SliceString": testhelper.Comparer{Value: []string{"first", "second", "third"}},
+				"SliceInt":    testhelper.Comparer{Value: []int{1, 2, 3, 4}},
+
+				// No env for these or base values, so default
+				"LogLevel":  testhelper.Comparer{Value: "error", Accessor: "GetTyped"},
+				"Pretty":    testhelper.Comparer{Value: false},
+				"Verbosity": testhelper.Comparer{Value: 1, Accessor: "GetTyped"},
+				"NameValue": testhelper.Comparer{Value: [][]string{
+					[]string{"k1", "v1"},
+					[]string{"k2", "v2"},
+					[]string{"k3", "v3"},
+				}},
+				"NameEnum": testhelper.Comparer{Value: [][]string{
+					[]string{"k1", "a"},
+					[]string{"k2", "b"},
+					[]string{"k3", "c"},
+				}},
+			},
+		},
+	}
+
+	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen:  testhelper.NewExtraConfig,
+		CompareMap: testhelper.ExtraConfigCustomComparers,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &testhelper.ExtraDocs}})
+	require.NoError(t, err)
+}
+
+func TestConfigEnv(t *testing.T) {
+	goldDefaultExtra := filepath.Join("testdata", cmdTestName+".TestConfig.extracfg")
+
+	tcs1 := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Config env no env affecting noextra",
+			CmdLine: []string{
+				"config",
+				"env",
+			},
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.default.noenv")},
+		},
+		testhelper.TestCase{
+			Name: "Config env with env affecting noextra",
+			CmdLine: []string{
+				"config",
+				"env",
+			},
+			Env: map[string]string{
+				"SIMPLE_VERBOSITY": "0",
+				"SIMPLE_LOGLEVEL":  "debug",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 0, Accessor: "GetTyped"},
+				"LogLevel":  testhelper.Comparer{Value: "debug", Accessor: "GetTyped"},
+			},
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.default.env")},
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs1, testhelper.TestRunnerOptions{
+		ConfigGen: testhelper.NewSimpleConfig,
+	})
+	require.NoError(t, err)
+
+	tcs2 := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Extra config env no env affecting extra",
+			CmdLine: []string{
+				"config",
+				"env",
+			},
+			CustomVars:   testhelper.ExtraConfigCustomVars,
+			CustomParser: testhelper.ExtraConfigCustomParse,
+			CfgFile:      goldDefaultExtra,
+			GoldStdOut:   &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.extra.noenv")},
+		},
+		testhelper.TestCase{
+			Name: "Config env with env affecting extra",
+			CmdLine: []string{
+				"config",
+				"env",
+			},
+			CustomVars:   testhelper.ExtraConfigCustomVars,
+			CustomParser: testhelper.ExtraConfigCustomParse,
+			CfgFile:      goldDefaultExtra,
+			Env: map[string]string{
+				"EXTRA_VERBOSITY": "0",
+				"EXTRA_LOGLEVEL":  "debug",
+				"EXTRA_STRING":    "hi",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 0, Accessor: "GetTyped"},
+				"LogLevel":  testhelper.Comparer{Value: "debug", Accessor: "GetTyped"},
+				"String":    testhelper.Comparer{Value: "hi"},
+			},
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.extra.env")},
+		},
+	}
+
+	err = testhelper.RunTestCases(t, tcs2, testhelper.TestRunnerOptions{
+		ConfigGen:  testhelper.NewExtraConfig,
+		CompareMap: testhelper.ExtraConfigCustomComparers,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &testhelper.ExtraDocs}})
+	require.NoError(t, err)
+
+	// Doc only for conf, not cmd, check that env still works
+	confEnvConf := func() greenery.Config {
+		return &struct {
+			*greenery.BaseConfig
+			TestParam string `greenery:"test|testParam|, , TESTPARAM"`
+		}{
+			BaseConfig: greenery.NewBaseConfig("partial", map[string]greenery.Handler{
+				"test": testhelper.NopNoArgs,
+			}),
+			TestParam: "default",
+		}
+	}
+
+	docs := map[string]*greenery.DocSet{
+		"": &greenery.DocSet{
+			Usage: map[string]*greenery.CmdHelp{
+				"test": &greenery.CmdHelp{
+					Short: "test",
+				},
+			},
+			CmdLine: map[string]string{
+				"TestParam": "test parameter",
+			},
+		}}
+
+	tcs3 := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Partial doc no env",
+			CmdLine: []string{
+				"config",
+				"env",
+			},
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.partial.noenv")},
+		},
+		testhelper.TestCase{
+			Name: "Partial doc with env affecting",
+			CmdLine: []string{
+				"config",
+				"env",
+			},
+			Env: map[string]string{
+				"PARTIAL_VERBOSITY": "0",
+				"PARTIAL_LOGLEVEL":  "debug",
+				"PARTIAL_TESTPARAM": "hi",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Verbosity": testhelper.Comparer{Value: 0, Accessor: "GetTyped"},
+				"LogLevel":  testhelper.Comparer{Value: "debug", Accessor: "GetTyped"},
+				"TestParam": testhelper.Comparer{Value: "hi"},
+			},
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfigEnv.partial.env")},
+		},
+	}
+
+	err = testhelper.RunTestCases(t, tcs3, testhelper.TestRunnerOptions{
+		ConfigGen:   confEnvConf,
+		UserDocList: docs,
+	})
+	require.NoError(t, err)
+}
+
+// Custom flag with only the minimal number of methods required, Set, String
+// and UnmarshalText. Note since Type is not present the help will not be as
+// good. This will end up serializing in the config file as a string
+type minimalValue uint16
+
+func (p *minimalValue) Set(e string) error {
+	var i int
+
+	if e == "" {
+		i = 0
+	} else {
+		var err error
+		i, err = strconv.Atoi(e)
+		if err != nil {
+			return fmt.Errorf("%s, cannot be converted to an integer", e)
+		}
+	}
+
+	if i < 0 || i > 65535 {
+		return fmt.Errorf("Minimal flags must be between 0 and 65535, have %d", i)
+	}
+
+	*p = minimalValue(i)
+	return nil
+}
+func (p *minimalValue) String() string                  { return strconv.Itoa(int(*p)) }
+func (p *minimalValue) UnmarshalText(text []byte) error { return p.Set(string(text)) }
+
+func TestConfigInitCustom(t *testing.T) {
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Generate the config",
+			CmdLine: []string{
+				"config",
+				"init",
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Source: filepath.Join("testdata", cmdTestName+".TestConfig.customcfg"), Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			OutStdOutRegex: "Configuration file generated",
+		},
+		testhelper.TestCase{
+			Name: "Display it",
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			CfgFile: filepath.Join("testdata", cmdTestName+".TestConfig.customcfg"),
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestConfig.customdisplaycfg"),
+				Custom: testhelper.CompareIgnoreTmp},
+		},
+	}
+
+	// Override this to make the generated config file header constant
 	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
 		ConfigGen: func() greenery.Config {
 			cfg := &struct {
@@ -792,17 +1492,2461 @@ func TestConfigInitCustom(t *testing.T) {
 				BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
 				Minimal:    minimalValue(100),
 			}
-			return cfg
+			return cfg
+		},
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				ConfigFile: map[string]string{
+					greenery.DocConfigHeader: "Config generated while testing",
+					"Minimal":                "minimal",
+					"Special":                "",
+				},
+			}},
+	})
+
+	require.NoError(t, err)
+}
+
+func TestValueSources(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	mainConf := filepath.Join(cwd, cmdTestName+".toml")
+	incConf := filepath.Join(cwd, "inc.toml")
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Flag, environment, config file and default",
+			CmdLine: []string{
+				"--log-level",
+				"warn",
+				"version",
+			},
+			Env: map[string]string{
+				"CMDS_TEST_PRETTY": "true",
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Contents: []byte("include = [\"inc.toml\"]\nverbosity = 2\nlog-level = \"info\"\n"), Perms: 0644},
+				testhelper.TestFile{Location: "inc.toml",
+					Contents: []byte("no-env = false\n"), Perms: 0644},
+			},
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				require.Equal(t, "flag --log-level", cfg.GetValueSource("LogLevel"))
+				require.Equal(t, "environment variable CMDS_TEST_PRETTY", cfg.GetValueSource("Pretty"))
+				require.Equal(t, "config file "+mainConf, cfg.GetValueSource("Verbosity"))
+				require.Equal(t, "config file "+incConf, cfg.GetValueSource("NoEnv"))
+				require.Equal(t, "default", cfg.GetValueSource("Profile"))
+				require.Equal(t, "", cfg.GetValueSource("NotAField"))
+				require.Equal(t, "", cfg.GetValueSource("s_v"))
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Environment without a configuration file",
+			CmdLine: []string{
+				"version",
+			},
+			Env: map[string]string{
+				"CMDS_TEST_VERBOSITY": "3",
+				"CMDS_TEST_NOCFG":     "true",
+			},
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				require.Equal(t, "environment variable CMDS_TEST_VERBOSITY", cfg.GetValueSource("Verbosity"))
+				require.Equal(t, "environment variable CMDS_TEST_NOCFG", cfg.GetValueSource("NoCfg"))
+				require.Equal(t, "default", cfg.GetValueSource("Pretty"))
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Display",
+			CmdLine: []string{
+				"-v",
+				"3",
+				"config",
+				"display",
+			},
+			Env: map[string]string{
+				"CMDS_TEST_LOGLEVEL": "info",
+			},
+			CfgContents: "log-level = \"error\"\n",
+			ExpectedValues: map[string]testhelper.Comparer{
+				"LogLevel":  testhelper.Comparer{Value: "info", Accessor: "GetTyped"},
+				"Verbosity": testhelper.Comparer{Value: 3, Accessor: "GetTyped"},
+			},
+			OutStdOutRegex: "(?m)^LogLevel: info \\(environment variable CMDS_TEST_LOGLEVEL\\)$(?s:.*)^NoCfg: false \\(default\\)$(?s:.*)^Verbosity: 3 \\(flag --verbosity\\)$",
+		},
+	}
+
+	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newCmdsBaseConfig,
+	})
+	require.NoError(t, err)
+}
+
+func TestConfigDisplayOutput(t *testing.T) {
+	custom := filepath.Join("testdata", cmdTestName+".TestConfig.extracustomcfg")
+
+	var tcs []testhelper.TestCase
+	for _, format := range []string{"json", "toml", "yaml"} {
+		gold := filepath.Join("testdata", cmdTestName+".TestConfig.displayoutput."+format)
+		tcs = append(tcs,
+			testhelper.TestCase{
+				Name: "Display as " + format,
+				CmdLine: []string{
+					"config",
+					"display",
+					"--output",
+					format,
+				},
+				CustomVars:             testhelper.ExtraConfigCustomVars,
+				CustomParser:           testhelper.ExtraConfigCustomParse,
+				CfgFile:                custom,
+				NoValidateConfigValues: true,
+				GoldStdOut:             &testhelper.TestFile{Source: gold, Custom: testhelper.CompareIgnoreTmp},
+			},
+			testhelper.TestCase{
+				Name: "Display as " + format + " is valid configuration",
+				CmdLine: []string{
+					"config",
+					"display",
+					"--output",
+					format,
+				},
+				CfgFile:                gold,
+				CfgFileExtension:       "." + format,
+				NoValidateConfigValues: true,
+				GoldStdOut:             &testhelper.TestFile{Source: gold, Custom: testhelper.CompareIgnoreTmp},
+			})
+	}
+
+	tcs = append(tcs, testhelper.TestCase{
+		Name: "Invalid output format",
+		CmdLine: []string{
+			"config",
+			"display",
+			"--output",
+			"xml",
+		},
+		ExecError: "invalid argument \"xml\" for \"--output\" flag",
+	})
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen:  testhelper.NewExtraConfig,
+		CompareMap: testhelper.ExtraConfigCustomComparers,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &testhelper.ExtraDocs}})
+	require.NoError(t, err)
+}
+
+type escapedValuesConfig struct {
+	*greenery.BaseConfig
+	Path  string            `greenery:"|path|, .path, PATH"`
+	Paths []string          `greenery:"|paths|, .paths, PATHS"`
+	Names map[string]string `greenery:"|name|, .names, NAMES"`
+}
+
+func newEscapedValuesConfig() greenery.Config {
+	return &escapedValuesConfig{
+		BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+	}
+}
+
+func newEscapedDefaultsConfig() greenery.Config {
+	return &escapedValuesConfig{
+		BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+		Path:       `C:\temp "x"`,
+		Paths:      []string{`a\b`, "say \"hi\"\tthere"},
+		Names:      map[string]string{"dir": `C:\new "y"`},
+	}
+}
+
+func TestConfigEscapedValues(t *testing.T) {
+	escaped := func(t *testing.T, icfg greenery.Config) {
+		cfg := icfg.(*escapedValuesConfig)
+		require.Equal(t, `C:\temp "x"`, cfg.Path)
+		require.Equal(t, []string{`a\b`, "say \"hi\"\tthere"}, cfg.Paths)
+		require.Equal(t, map[string]string{"dir": `C:\new "y"`}, cfg.Names)
+	}
+
+	var tcs []testhelper.TestCase
+	for _, format := range []string{"json", "toml", "yaml"} {
+		gold := filepath.Join("testdata", cmdTestName+".TestConfigEscapedValues."+format)
+		tcs = append(tcs,
+			testhelper.TestCase{
+				Name: "Display as " + format,
+				CmdLine: []string{
+					"--path",
+					`C:\temp "x"`,
+					"--paths",
+					`a\b`,
+					"--paths",
+					"say \"hi\"\tthere",
+					"--name",
+					`"dir=C:\new ""y"""`,
+					"config",
+					"display",
+					"--output",
+					format,
+				},
+				NoValidateConfigValues: true,
+				GoldStdOut:             &testhelper.TestFile{Source: gold, Custom: testhelper.CompareIgnoreTmp},
+			},
+			testhelper.TestCase{
+				Name: "Display as " + format + " is loaded back",
+				CmdLine: []string{
+					"version",
+				},
+				CfgFile:                gold,
+				CfgFileExtension:       "." + format,
+				NoValidateConfigValues: true,
+				ValuesValidator:        escaped,
+				OutStdOut:              "0.0\n",
+			})
+	}
+
+	for _, format := range []string{"json", "toml", "yaml"} {
+		gold := filepath.Join("testdata", cmdTestName+".TestConfigEscapedValues.init."+format)
+		tcs = append(tcs,
+			testhelper.TestCase{
+				Name: "Init as " + format,
+				CmdLine: []string{
+					"config",
+					"init",
+					"--format",
+					format,
+				},
+				ConfigGen: newEscapedDefaultsConfig,
+				GoldFiles: []testhelper.TestFile{
+					testhelper.TestFile{Location: cmdTestName + "." + format,
+						Source: gold, Perms: 0644, Custom: testhelper.CompareIgnoreTmp},
+				},
+				NoValidateConfigValues: true,
+				OutStdOutRegex:         "^Configuration file generated at .*" + cmdTestName + "." + format + "\n$",
+			},
+			testhelper.TestCase{
+				Name: "Init as " + format + " is loaded back",
+				CmdLine: []string{
+					"version",
+				},
+				CfgFile:                gold,
+				CfgFileExtension:       "." + format,
+				NoValidateConfigValues: true,
+				ValuesValidator:        escaped,
+				OutStdOut:              "0.0\n",
+			})
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newEscapedValuesConfig,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				CmdLine: map[string]string{
+					"Path":  "a path",
+					"Paths": "some paths",
+					"Names": "some names",
+				},
+
+				ConfigFile: map[string]string{
+					greenery.DocConfigHeader: "Config generated while testing",
+				},
+			},
+		}})
+	require.NoError(t, err)
+}
+
+func TestConfigInitInteractive(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	cwdConf := filepath.Join(cwd, cmdTestName+".toml")
+
+	withInput := func(in io.Reader) func() greenery.Config {
+		return func() greenery.Config {
+			greenery.SetInteractiveInput(in)
+			return newCmdsBaseConfig()
+		}
+	}
+	defer greenery.SetInteractiveInput(nil)()
+
+	contains := func(wanted ...string) func(*testing.T, greenery.Config) {
+		return func(t *testing.T, cfg greenery.Config) {
+			b, err := afero.ReadFile(cfg.GetFs(), cwdConf)
+			require.NoError(t, err)
+			for _, w := range wanted {
+				require.Contains(t, string(b), w)
+			}
+		}
+	}
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Answers are validated and written",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--interactive",
+			},
+			ConfigGen: withInput(strings.NewReader("\n\nloud\ndebug\n\n yes\ntrue\n5\n2\n")),
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgInteractive": testhelper.Comparer{Value: true},
+				"LogLevel":       testhelper.Comparer{Value: "debug", Accessor: "GetTyped"},
+				"Pretty":         testhelper.Comparer{Value: true},
+				"Verbosity":      testhelper.Comparer{Value: 2, Accessor: "GetTyped"},
+			},
+			OutStdOutRegex: "^# The format of the error messages[^\n]+\nerror-format \\[text, json\\] \\(text\\): " +
+				"# The log file location\nlog-file \\([^)]+\\): " +
+				"# The log level of the program[^\n]+\n" +
+				"log-level \\[debug, info, warn, error\\] \\(error\\): Invalid value loud for variable LogLevel, should be one of debug, info, warn, error. Please try again\n" +
+				"log-level \\[debug, info, warn, error\\] \\(error\\): " +
+				"# If set the environment variables will not be considered\nno-env \\(false\\): " +
+				"# If set the console output of the logging calls will be prettified\npretty \\(false\\): [^\n]+\\. Please try again\n" +
+				"pretty \\(false\\): " +
+				"# The verbosity of the program[^\n]+\n" +
+				"verbosity \\[0-3\\] \\(1\\): Invalid value 5 for variable Verbosity, should be between 0 and 3. Please try again\n" +
+				"verbosity \\[0-3\\] \\(1\\): Configuration file generated at " + cwdConf + "\n$",
+			ValuesValidator: contains("log-level = \"debug\"\n", "pretty = true\n", "verbosity = 2\n"),
+		},
+		testhelper.TestCase{
+			Name: "Not a terminal",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--interactive",
+			},
+			ConfigGen: withInput(nil),
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgInteractive": testhelper.Comparer{Value: true},
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Source: filepath.Join("testdata", cmdTestName+".TestConfig.defaultcfg"), Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			OutStdOutRegex: "^Configuration file generated at " + cwdConf + "\n$",
+		},
+		testhelper.TestCase{
+			Name: "Input ends before all the answers are given",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--interactive",
+			},
+			ConfigGen: withInput(strings.NewReader("\ndebug\n")),
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgInteractive": testhelper.Comparer{Value: true},
+			},
+			ExecError: "Interactive configuration aborted: EOF",
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				exists, err := afero.Exists(cfg.GetFs(), cwdConf)
+				require.NoError(t, err)
+				require.False(t, exists)
+			},
+		},
+		testhelper.TestCase{
+			Name: "Answers are not set if the input ends early",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--interactive",
+			},
+			ConfigGen: withInput(strings.NewReader("\n\ndebug\n")),
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgInteractive": testhelper.Comparer{Value: true},
+			},
+			ExecError:       "Interactive configuration aborted: EOF",
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOutRegex:  "\nlog-level \\[debug, info, warn, error\\] \\(error\\): # [^\n]+\nno-env \\(false\\): \n$",
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				require.Equal(t, "error", cfg.(*cmdsBaseConfig).LogLevel.Value)
+			},
+		},
+		testhelper.TestCase{
+			Name: "Existing file",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--interactive",
+			},
+			ConfigGen: withInput(strings.NewReader("")),
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgInteractive": testhelper.Comparer{Value: true},
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Source: filepath.Join("testdata", cmdTestName+".TestConfig.defaultcfg"), Perms: 0644},
+			},
+			ExecError: "Cannot create config file " + cwdConf + ", the file already exists",
+		},
+	}
+
+	// Override this to make the generated config file header constant
+	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				ConfigFile: map[string]string{
+					greenery.DocConfigHeader: "Config generated while testing",
+				},
+			},
+		}})
+	require.NoError(t, err)
+
+	tcs = []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Localized messages",
+			CmdLine: []string{
+				"config",
+				"init",
+				"--interactive",
+			},
+			ConfigGen: withInput(strings.NewReader("\n\nloud\n")),
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgInteractive": testhelper.Comparer{Value: true},
+			},
+			OutStdOutRegex: "\nlog-level \\[debug, info, warn, error\\] \\(error\\): Valore non valido \\(Invalid value loud [^\n]+\\)\n" +
+				"log-level \\[debug, info, warn, error\\] \\(error\\): \n$",
+			ExecError:       "Configurazione interrotta: EOF",
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+		},
+	}
+
+	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				PromptAborted: "Configurazione interrotta",
+				PromptInvalid: "Valore non valido (%s)",
+			},
+		}})
+	require.NoError(t, err)
+}
+
+type editConfig struct {
+	*greenery.BaseConfig
+	Name  string             `greenery:"|name|,   server.name,  NAME"`
+	Port  *greenery.IntValue `greenery:"|port|,   server.port,  PORT"`
+	Token string             `greenery:"|token|,  server.token, TOKEN, secret"`
+}
+
+func newEditConfig() greenery.Config {
+	return &editConfig{
+		BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+		Port:       greenery.NewDefaultIntValue("Port", 8080, 1, 65535),
+	}
+}
+
+func TestConfigGetSetUnset(t *testing.T) {
+	tomlCfg := `# Config generated while testing
+verbosity = 1
+
+# Server settings
+[server]
+# The port
+port = 8080 # the default
+name = "web"
+`
+
+	yamlCfg := `server:
+  port: 8080
+  # token: "********"
+`
+
+	contents := func(wanted string) func(*testing.T, greenery.Config) {
+		return func(t *testing.T, cfg greenery.Config) {
+			b, err := afero.ReadFile(cfg.GetFs(), cfg.GetConfigFile())
+			require.NoError(t, err)
+			require.Equal(t, wanted, string(b))
+		}
+	}
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Get a value",
+			CmdLine: []string{
+				"config",
+				"get",
+				"server.port",
+			},
+			CfgContents: tomlCfg,
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Name": testhelper.Comparer{Value: "web"},
+			},
+			OutStdOut: "8080\n",
+		},
+		testhelper.TestCase{
+			Name: "Get a value set in the environment",
+			CmdLine: []string{
+				"config",
+				"get",
+				"SERVER.NAME",
+			},
+			CfgContents: tomlCfg,
+			Env: map[string]string{
+				"CMDS_TEST_NAME": "api",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Name": testhelper.Comparer{Value: "api"},
+			},
+			OutStdOut: "api\n",
+		},
+		testhelper.TestCase{
+			Name: "Get a secret value",
+			CmdLine: []string{
+				"config",
+				"get",
+				"server.token",
+			},
+			Env: map[string]string{
+				"CMDS_TEST_TOKEN": "hunter2",
+			},
+			NoValidateConfigValues: true,
+			OutStdOut:              "********\n",
+		},
+		testhelper.TestCase{
+			Name: "Get a revealed secret value",
+			CmdLine: []string{
+				"config",
+				"get",
+				"--reveal",
+				"server.token",
+			},
+			Env: map[string]string{
+				"CMDS_TEST_TOKEN": "hunter2",
+			},
+			NoValidateConfigValues: true,
+			OutStdOut:              "hunter2\n",
+		},
+		testhelper.TestCase{
+			Name: "Get an unknown variable",
+			CmdLine: []string{
+				"config",
+				"get",
+				"server.host",
+			},
+			ExecError: "Unknown configuration variable server.host",
+		},
+		testhelper.TestCase{
+			Name: "Get without a variable",
+			CmdLine: []string{
+				"config",
+				"get",
+			},
+			ExecError: "The command requires the name of the configuration variable, as section.key",
+		},
+		testhelper.TestCase{
+			Name: "Set a value keeping the comments",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.port",
+				"9090",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			ValuesValidator:        contents(strings.Replace(tomlCfg, "port = 8080 #", "port = 9090 #", 1)),
+		},
+		testhelper.TestCase{
+			Name: "Set a value not in the file",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.token",
+				"it's a secret",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			ValuesValidator:        contents(tomlCfg + "token = \"it's a secret\"\n"),
+		},
+		testhelper.TestCase{
+			Name: "Set a root value not in the file",
+			CmdLine: []string{
+				"config",
+				"set",
+				"log-level",
+				"debug",
+			},
+			CfgContents:            strings.Replace(tomlCfg, "verbosity = 1\n", "", 1),
+			NoValidateConfigValues: true,
+			ValuesValidator: contents(strings.Replace(tomlCfg, "verbosity = 1\n\n",
+				"\nlog-level = \"debug\"\n\n", 1)),
+		},
+		testhelper.TestCase{
+			Name: "Set a value in a new section",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.port",
+				"9090",
+			},
+			CfgContents:            "verbosity = 1\n",
+			NoValidateConfigValues: true,
+			ValuesValidator:        contents("verbosity = 1\n\n[server]\nport = 9090\n"),
+		},
+		testhelper.TestCase{
+			Name: "Set an invalid value",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.port",
+				"70000",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			ExecError:              "Invalid value for server.port: Invalid value 70000 for variable Port, should be between 1 and 65535",
+			ExecErrorIs:            greenery.ErrInvalidValue,
+			ValuesValidator:        contents(tomlCfg),
+		},
+		testhelper.TestCase{
+			Name: "Set without a configuration file",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.port",
+				"9090",
+			},
+			NoValidateConfigValues: true,
+			ExecError:              "No configuration file was loaded, create one with config init first",
+		},
+		testhelper.TestCase{
+			Name: "Set a commented out YAML value",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.token",
+				"hunter2",
+			},
+			CfgContents:            yamlCfg,
+			CfgFileExtension:       ".yaml",
+			NoValidateConfigValues: true,
+			ValuesValidator:        contents("server:\n  port: 8080\n  token: \"hunter2\"\n"),
+		},
+		testhelper.TestCase{
+			Name: "Set a value needing escapes",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.name",
+				"a \"web\"\tserver\a",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			ValuesValidator: contents(strings.Replace(tomlCfg, "name = \"web\"",
+				"name = \"a \\\"web\\\"\\tserver\\u0007\"", 1)),
+		},
+		testhelper.TestCase{
+			Name: "Set a YAML value needing escapes",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.name",
+				"a \"web\"\tserver\a",
+			},
+			CfgContents:            yamlCfg,
+			CfgFileExtension:       ".yaml",
+			NoValidateConfigValues: true,
+			ValuesValidator: contents(strings.Replace(yamlCfg, "  port: 8080\n",
+				"  port: 8080\n  name: \"a \\\"web\\\"\\tserver\\a\"\n", 1)),
+		},
+		testhelper.TestCase{
+			Name: "Set a JSON value",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.name",
+				"api",
+			},
+			CfgContents:            "{\n  \"verbosity\": 1\n}\n",
+			CfgFileExtension:       ".json",
+			NoValidateConfigValues: true,
+			ValuesValidator:        contents("{\n  \"server\": {\n    \"name\": \"api\"\n  },\n  \"verbosity\": 1\n}\n"),
+		},
+		testhelper.TestCase{
+			Name: "Unset a value",
+			CmdLine: []string{
+				"config",
+				"unset",
+				"server.port",
+			},
+			CfgContents: tomlCfg,
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Name": testhelper.Comparer{Value: "web"},
+			},
+			ValuesValidator: contents(strings.Replace(tomlCfg, "port = 8080 # the default\n", "", 1)),
+		},
+		testhelper.TestCase{
+			Name: "Unset a YAML value",
+			CmdLine: []string{
+				"config",
+				"unset",
+				"server.port",
+			},
+			CfgContents:      yamlCfg,
+			CfgFileExtension: ".yaml",
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Port": testhelper.Comparer{Value: 8080, Accessor: "GetTyped"},
+			},
+			ValuesValidator: contents("server:\n  # token: \"********\"\n"),
+		},
+		testhelper.TestCase{
+			Name: "Unset a value not in the file",
+			CmdLine: []string{
+				"config",
+				"unset",
+				"server.token",
+			},
+			CfgContents: tomlCfg,
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Name": testhelper.Comparer{Value: "web"},
+			},
+			ExecErrorRegex: "^Configuration variable server.token is not set in /tmp/tcfg[0-9]+.toml$",
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newEditConfig,
+		CompareMap: map[string]testhelper.CompareFunc{
+			"Port": testhelper.CompareGetterToGetter,
+		},
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				CmdLine: map[string]string{
+					"Name":  "the server name",
+					"Port":  "the server port",
+					"Token": "the server token",
+				},
+			},
+		}})
+	require.NoError(t, err)
+}
+
+type nestedConfig struct {
+	*greenery.BaseConfig
+	Name   string `greenery:"|name|,        server.name,       NAME"`
+	Cert   string `greenery:"|cert|,        server.tls.cert,   CERT"`
+	Verify bool   `greenery:"|verify|,      server.tls.verify, VERIFY"`
+	Size   int    `greenery:"|pool-size|,   db.pool.size,      POOLSIZE"`
+}
+
+func newNestedConfig() greenery.Config {
+	return &nestedConfig{
+		BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+		Name:       "web",
+		Size:       4,
+	}
+}
+
+func TestConfigNestedSections(t *testing.T) {
+	tomlCfg := `[server]
+name = "api"
+
+# TLS settings
+[server.tls]
+cert = "/etc/cert.pem"
+`
+
+	yamlCfg := `server:
+  name: api
+  tls:
+    cert: /etc/cert.pem
+db:
+  pool:
+    size: 8
+`
+
+	contents := func(wanted string) func(*testing.T, greenery.Config) {
+		return func(t *testing.T, cfg greenery.Config) {
+			b, err := afero.ReadFile(cfg.GetFs(), cfg.GetConfigFile())
+			require.NoError(t, err)
+			require.Equal(t, wanted, string(b))
+		}
+	}
+
+	var tcs []testhelper.TestCase
+	for _, format := range []string{"json", "toml", "yaml"} {
+		tcs = append(tcs, testhelper.TestCase{
+			Name: "Init as " + format,
+			CmdLine: []string{
+				"config",
+				"init",
+				"--format",
+				format,
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgFormat": testhelper.Comparer{Value: format, Accessor: "GetTyped"},
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + "." + format,
+					Source: filepath.Join("testdata", cmdTestName+".TestConfigNestedSections."+format), Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file generated at .*" + cmdTestName + "." + format + "\n$",
+		}, testhelper.TestCase{
+			Name: "Init as " + format + " is valid configuration",
+			CmdLine: []string{
+				"config",
+				"validate",
+			},
+			CfgFile:                filepath.Join("testdata", cmdTestName+".TestConfigNestedSections."+format),
+			CfgFileExtension:       "." + format,
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file .* is valid\n$",
+		})
+	}
+
+	tcs = append(tcs,
+		testhelper.TestCase{
+			Name:        "Load a TOML file",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Name": testhelper.Comparer{Value: "api"},
+				"Cert": testhelper.Comparer{Value: "/etc/cert.pem"},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:             "Load a YAML file",
+			CfgContents:      yamlCfg,
+			CfgFileExtension: ".yaml",
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Name": testhelper.Comparer{Value: "api"},
+				"Cert": testhelper.Comparer{Value: "/etc/cert.pem"},
+				"Size": testhelper.Comparer{Value: 8},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "Environment variable names are unchanged",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"version",
+			},
+			Env: map[string]string{
+				"CMDS_TEST_CERT":     "/etc/other.pem",
+				"CMDS_TEST_POOLSIZE": "2",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Name": testhelper.Comparer{Value: "api"},
+				"Cert": testhelper.Comparer{Value: "/etc/other.pem"},
+				"Size": testhelper.Comparer{Value: 2},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Get a nested value",
+			CmdLine: []string{
+				"config",
+				"get",
+				"server.tls.cert",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			OutStdOut:              "/etc/cert.pem\n",
+		},
+		testhelper.TestCase{
+			Name: "Set a nested value",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.tls.verify",
+				"true",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			ValuesValidator:        contents(tomlCfg + "verify = true\n"),
+		},
+		testhelper.TestCase{
+			Name: "Set a value in a new nested section",
+			CmdLine: []string{
+				"config",
+				"set",
+				"db.pool.size",
+				"16",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			ValuesValidator:        contents(tomlCfg + "\n[db.pool]\nsize = 16\n"),
+		},
+		testhelper.TestCase{
+			Name: "Set a nested YAML value",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.tls.verify",
+				"true",
+			},
+			CfgContents:            yamlCfg,
+			CfgFileExtension:       ".yaml",
+			NoValidateConfigValues: true,
+			ValuesValidator: contents(strings.Replace(yamlCfg, "cert: /etc/cert.pem\n",
+				"cert: /etc/cert.pem\n    verify: true\n", 1)),
+		},
+		testhelper.TestCase{
+			Name: "Set a YAML value in a new nested section",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.tls.cert",
+				"/etc/cert.pem",
+			},
+			CfgContents:            "server:\n  name: api\ndb:\n  pool:\n    size: 8\n",
+			CfgFileExtension:       ".yaml",
+			NoValidateConfigValues: true,
+			ValuesValidator: contents("server:\n  name: api\n  tls:\n    cert: \"/etc/cert.pem\"\n" +
+				"db:\n  pool:\n    size: 8\n"),
+		},
+		testhelper.TestCase{
+			Name: "Unset a nested YAML value",
+			CmdLine: []string{
+				"config",
+				"unset",
+				"db.pool.size",
+			},
+			CfgContents:            yamlCfg,
+			CfgFileExtension:       ".yaml",
+			NoValidateConfigValues: true,
+			ValuesValidator:        contents(strings.Replace(yamlCfg, "    size: 8\n", "", 1)),
+		},
+		testhelper.TestCase{
+			Name: "Set a nested JSON value",
+			CmdLine: []string{
+				"config",
+				"set",
+				"server.tls.cert",
+				"/etc/cert.pem",
+			},
+			CfgContents:            "{\n  \"server\": {\n    \"name\": \"api\"\n  }\n}\n",
+			CfgFileExtension:       ".json",
+			NoValidateConfigValues: true,
+			ValuesValidator: contents("{\n  \"server\": {\n    \"name\": \"api\",\n" +
+				"    \"tls\": {\n      \"cert\": \"/etc/cert.pem\"\n    }\n  }\n}\n"),
+		},
+	)
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newNestedConfig,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				ConfigFile: map[string]string{
+					greenery.DocConfigHeader: "Config generated while testing",
+					"server.":                "Server settings",
+					"server.tls.":            "TLS settings",
+					"db.":                    "Database settings",
+				},
+				CmdLine: map[string]string{
+					"Name":   "the server name",
+					"Cert":   "the certificate file",
+					"Verify": "whether to verify the client certificates",
+					"Size":   "the connection pool size",
+				},
+			},
+		}})
+	require.NoError(t, err)
+}
+
+type dbConfig struct {
+	Host     string `greenery:"|host|,         .host,     HOST"`
+	Port     int    `greenery:"|port|,         .port,     PORT"`
+	Password string `greenery:"|password|,     .password, PASSWORD, secret"`
+}
+
+type nestedStructConfig struct {
+	*greenery.BaseConfig
+	Name    string   `greenery:"|name|,        server.name,  NAME"`
+	DB      dbConfig `greenery:"db,            db,           DB"`
+	Replica dbConfig `greenery:"replica,       db.replica,   REPLICA"`
+}
+
+func newNestedStructConfig() greenery.Config {
+	return &nestedStructConfig{
+		BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+		Name:       "web",
+		DB:         dbConfig{Host: "localhost", Port: 5432},
+		Replica:    dbConfig{Host: "localhost", Port: 5433},
+	}
+}
+
+func TestNestedStructs(t *testing.T) {
+	tomlCfg := `[db]
+host = "primary"
+
+[db.replica]
+port = 6000
+`
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Flags are prefixed",
+			CmdLine: []string{
+				"--db-host",
+				"flaghost",
+				"--replica-port",
+				"7000",
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"DB":      testhelper.Comparer{Value: dbConfig{Host: "flaghost", Port: 5432}},
+				"Replica": testhelper.Comparer{Value: dbConfig{Host: "localhost", Port: 7000}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "Config file sections are prefixed",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"DB":      testhelper.Comparer{Value: dbConfig{Host: "primary", Port: 5432}},
+				"Replica": testhelper.Comparer{Value: dbConfig{Host: "localhost", Port: 6000}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "Environment variables are prefixed",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"version",
+			},
+			Env: map[string]string{
+				"CMDS_TEST_DB_PORT":          "5000",
+				"CMDS_TEST_REPLICA_PASSWORD": "hunter2",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"DB":      testhelper.Comparer{Value: dbConfig{Host: "primary", Port: 5000}},
+				"Replica": testhelper.Comparer{Value: dbConfig{Host: "localhost", Port: 6000, Password: "hunter2"}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Flags override the config file",
+			CmdLine: []string{
+				"--db-host",
+				"flaghost",
+				"config",
+				"display",
+			},
+			CfgContents: tomlCfg,
+			Env: map[string]string{
+				"CMDS_TEST_DB_PASSWORD": "hunter2",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"DB":      testhelper.Comparer{Value: dbConfig{Host: "flaghost", Port: 5432, Password: "hunter2"}},
+				"Replica": testhelper.Comparer{Value: dbConfig{Host: "localhost", Port: 6000}},
+			},
+			OutStdOutRegex: "(?s)\nDB.Host: flaghost \\(flag --db-host\\)\nDB.Password: \\*+ \\(environment variable CMDS_TEST_DB_PASSWORD\\)\n" +
+				"DB.Port: 5432 \\(default\\)\nName: web \\(default\\)\n" +
+				"Replica.Host: localhost \\(default\\)\nReplica.Password: \\*+ \\(default\\)\nReplica.Port: 6000 \\(config file .*\\)\n",
+		},
+		testhelper.TestCase{
+			Name: "Config env",
+			CmdLine: []string{
+				"config",
+				"env",
+			},
+			GoldStdOut: &testhelper.TestFile{Source: filepath.Join("testdata", cmdTestName+".TestNestedStructs.env")},
+		},
+		testhelper.TestCase{
+			Name: "Get a value in a nested struct",
+			CmdLine: []string{
+				"config",
+				"get",
+				"db.replica.port",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			OutStdOut:              "6000\n",
+		},
+		testhelper.TestCase{
+			Name: "Init",
+			CmdLine: []string{
+				"config",
+				"init",
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Source: filepath.Join("testdata", cmdTestName+".TestNestedStructs.toml"), Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file generated at .*" + cmdTestName + ".toml\n$",
+		},
+		testhelper.TestCase{
+			Name: "Init is valid configuration",
+			CmdLine: []string{
+				"config",
+				"validate",
+			},
+			CfgFile:                filepath.Join("testdata", cmdTestName+".TestNestedStructs.toml"),
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file .* is valid\n$",
+		},
+		testhelper.TestCase{
+			Name: "Invalid nested struct tag",
+			ConfigGen: func() greenery.Config {
+				return &struct {
+					*greenery.BaseConfig
+					DB dbConfig `greenery:"db, db"`
+				}{
+					BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+				}
+			},
+			CmdLine: []string{
+				"version",
+			},
+			ExecError: "Invalid tag for nested struct DB, found 2 parts instead of 3 in db, db",
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newNestedStructConfig,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				ConfigFile: map[string]string{
+					greenery.DocConfigHeader: "Config generated while testing",
+					"server.":                "Server settings",
+					"db.":                    "Database settings",
+					"db.replica.":            "Replica settings",
+				},
+				CmdLine: map[string]string{
+					"Name":         "the server name",
+					"Host":         "the database host",
+					"Port":         "the database port",
+					"Password":     "the database password",
+					"Replica.Host": "the replica host",
+				},
+			},
+		}})
+	require.NoError(t, err)
+}
+
+type backendConfig struct {
+	URL     string `greenery:"||none,        .url,"`
+	Timeout int    `greenery:"||none,        .timeout,"`
+	Token   string `greenery:"||none,        .token,,          secret"`
+}
+
+type mapConfig struct {
+	*greenery.BaseConfig
+	Name     string                   `greenery:"|name|,        server.name,  NAME"`
+	Backends map[string]backendConfig `greenery:",              backend,"`
+}
+
+func newMapConfig() greenery.Config {
+	return &mapConfig{
+		BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+		Name:       "web",
+		Backends:   map[string]backendConfig{},
+	}
+}
+
+func TestMapSections(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	otherCfg := filepath.Join(cwd, "other.toml")
+
+	tomlCfg := `[backend.primary]
+url = "http://primary"
+timeout = 10
+token = "hunter2"
+
+[backend.replica]
+url = "http://replica"
+`
+
+	yamlCfg := `backend:
+  primary:
+    url: http://primary
+    timeout: 10
+    token: hunter2
+  replica:
+    url: http://replica
+`
+
+	backends := map[string]backendConfig{
+		"primary": backendConfig{URL: "http://primary", Timeout: 10, Token: "hunter2"},
+		"replica": backendConfig{URL: "http://replica"},
+	}
+
+	var tcs []testhelper.TestCase
+	for _, format := range []string{"json", "toml", "yaml"} {
+		tcs = append(tcs, testhelper.TestCase{
+			Name: "Init as " + format,
+			CmdLine: []string{
+				"config",
+				"init",
+				"--format",
+				format,
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgFormat": testhelper.Comparer{Value: format, Accessor: "GetTyped"},
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + "." + format,
+					Source: filepath.Join("testdata", cmdTestName+".TestMapSections."+format), Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file generated at .*" + cmdTestName + "." + format + "\n$",
+		}, testhelper.TestCase{
+			Name: "Init as " + format + " has no instances",
+			CmdLine: []string{
+				"version",
+			},
+			CfgFile:          filepath.Join("testdata", cmdTestName+".TestMapSections."+format),
+			CfgFileExtension: "." + format,
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Backends": testhelper.Comparer{Value: map[string]backendConfig{}},
+			},
+			OutStdOut: "0.0\n",
+		})
+	}
+
+	tcs = append(tcs,
+		testhelper.TestCase{
+			Name:        "Load a TOML file",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Backends": testhelper.Comparer{Value: backends},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:             "Load a YAML file",
+			CfgContents:      yamlCfg,
+			CfgFileExtension: ".yaml",
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Backends": testhelper.Comparer{Value: backends},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "All invalid instance values are reported",
+			CfgContents: "[backend.primary]\ntimeout = \"soon\"\n[backend.replica]\ntimeout = \"later\"\n",
+			CmdLine: []string{
+				"version",
+			},
+			ExecErrorRegex: "^Found 2 invalid configuration values: .*backend\\.primary\\.timeout.* \\(set in the configuration file\\); " +
+				".*backend\\.replica\\.timeout.* \\(set in the configuration file\\)$",
+			ExecErrorIs: greenery.ErrInvalidValue,
+		},
+		testhelper.TestCase{
+			Name:        "Validate reports the position of invalid instance values",
+			CfgContents: "[backend.primary]\ntimeout = \"soon\"\n\n[backend.replica]\nurl = \"http://replica\"\ntimeout = \"later\"\n",
+			CmdLine: []string{
+				"config",
+				"validate",
+			},
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOutRegex: "^/tmp/tcfg[0-9]+.toml:2:1: Cannot convert flag value backend.primary.timeout: unable to cast \"soon\" .*\n" +
+				"/tmp/tcfg[0-9]+.toml:6:1: Cannot convert flag value backend.replica.timeout: unable to cast \"later\" .*\n$",
+			ExecError: "Found 2 problem(s) in the configuration",
+		},
+		testhelper.TestCase{
+			Name:        "Instances must be sections",
+			CfgContents: "[backend]\nprimary = \"http://primary\"\n",
+			CmdLine: []string{
+				"version",
+			},
+			ExecError: "backend.primary is not a section (set in the configuration file)",
+		},
+		testhelper.TestCase{
+			Name:        "Unknown instance variable",
+			CfgContents: tomlCfg + "timeuot = 5\n",
+			CmdLine: []string{
+				"version",
+			},
+			ExecErrorIs: greenery.ErrUnknownKey,
+			ExecError:   "backend.replica.timeuot",
+		},
+		testhelper.TestCase{
+			Name:        "Display",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Backends": testhelper.Comparer{Value: backends},
+			},
+			OutStdOutRegex: "(?s)\nBackends\\.primary\\.Timeout: 10 \\(config file .*\\)\n" +
+				"Backends\\.primary\\.Token: \\*+ \\(config file .*\\)\n" +
+				"Backends\\.primary\\.URL: http://primary \\(config file .*\\)\n" +
+				"Backends\\.replica\\.Timeout: 0 \\(default\\)\n",
+		},
+		testhelper.TestCase{
+			Name:        "Diff reports the added instances",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"config",
+				"diff",
+			},
+			NoValidateConfigValues: true,
+			ExecErrorOutput:        true,
+			OutStdOutRegex: "\n# the request timeout\n\\+backend.primary.timeout = 10\n" +
+				"# the authentication token\n\\+backend.primary.token = \"\\*{8}\"\n" +
+				"# the backend URL\n\\+backend.primary.url = \"http://primary\"\n" +
+				"# the request timeout\n\\+backend.replica.timeout = 0\n" +
+				"# the authentication token\n\\+backend.replica.token = \"\\*{8}\"\n" +
+				"# the backend URL\n\\+backend.replica.url = \"http://replica\"\n$",
+			OutStdErrRegex: "^Usage:",
+			ExecError:      "The configuration differs from the defaults",
+		},
+		testhelper.TestCase{
+			Name:        "Diff reports the added and removed instances",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"config",
+				"diff",
+				otherCfg,
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: otherCfg, Contents: []byte("log-file = \"/tmp/other.log\"\n\n" +
+					"[backend.primary]\nurl = \"http://primary\"\ntimeout = 10\ntoken = \"hunter2\"\n\n" +
+					"[backend.local]\nurl = \"http://localhost\"\n"), Perms: 0644},
+			},
+			NoValidateConfigValues: true,
+			ExecErrorOutput:        true,
+			OutStdOutRegex: "^# The log file location\n-log-file = \"/tmp/tlog[0-9]+.log\"\n\\+log-file = \"/tmp/other.log\"\n" +
+				"# the request timeout\n-backend.replica.timeout = 0\n" +
+				"# the authentication token\n-backend.replica.token = \"\\*{8}\"\n" +
+				"# the backend URL\n-backend.replica.url = \"http://replica\"\n" +
+				"# the request timeout\n\\+backend.local.timeout = 0\n" +
+				"# the authentication token\n\\+backend.local.token = \"\\*{8}\"\n" +
+				"# the backend URL\n\\+backend.local.url = \"http://localhost\"\n$",
+			OutStdErrRegex: "^Usage:",
+			ExecError:      "The configuration differs from " + otherCfg,
+		},
+		testhelper.TestCase{
+			Name: "Init writes the default instances",
+			ConfigGen: func() greenery.Config {
+				cfg := newMapConfig().(*mapConfig)
+				cfg.Backends["local"] = backendConfig{URL: "http://localhost", Timeout: 5}
+				return cfg
+			},
+			CmdLine: []string{
+				"config",
+				"init",
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file generated at .*" + cmdTestName + ".toml\n$",
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				cwd, err := os.Getwd()
+				require.NoError(t, err)
+				b, err := afero.ReadFile(cfg.GetFs(), filepath.Join(cwd, cmdTestName+".toml"))
+				require.NoError(t, err)
+				require.Contains(t, string(b), "\n# The backends, one section for each\n[backend]\n\n"+
+					"# [backend.example]\n")
+				require.Contains(t, string(b), "\n[backend.local]\n# the request timeout\ntimeout = 5\n"+
+					"# the authentication token\n# token = \"********\"\n# the backend URL\nurl = \"http://localhost\"\n")
+			},
+		},
+		testhelper.TestCase{
+			Name: "Flags cannot be prefixed",
+			ConfigGen: func() greenery.Config {
+				return &struct {
+					*greenery.BaseConfig
+					Backends map[string]backendConfig `greenery:"backend, backend,"`
+				}{
+					BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+				}
+			},
+			CmdLine: []string{
+				"version",
+			},
+			ExecError: "Invalid tag for map Backends, its instances can be set only in the configuration file",
+		},
+		testhelper.TestCase{
+			Name: "Instance fields cannot be pointers",
+			ConfigGen: func() greenery.Config {
+				return &struct {
+					*greenery.BaseConfig
+					Backends map[string]struct {
+						Level *greenery.EnumValue `greenery:"||none, .level,"`
+					} `greenery:", backend,"`
+				}{
+					BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+				}
+			},
+			CmdLine: []string{
+				"version",
+			},
+			ExecError: "Invalid instance field in map Backends, Level cannot be a pointer",
+		},
+	)
+
+	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newMapConfig,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				ConfigFile: map[string]string{
+					greenery.DocConfigHeader: "Config generated while testing",
+					"server.":                "Server settings",
+					"backend.":               "The backends, one section for each",
+					"URL":                    "the backend URL",
+					"Timeout":                "the request timeout",
+					"Token":                  "the authentication token",
+				},
+				CmdLine: map[string]string{
+					"Name": "the server name",
+				},
+			},
+		}})
+	require.NoError(t, err)
+}
+
+type mapFieldsConfig struct {
+	*greenery.BaseConfig
+	Labels  map[string]string `greenery:"|label|L, .labels, LABELS"`
+	Limits  map[string]int    `greenery:"|limit|, .limits, LIMITS"`
+	Enabled map[string]bool   `greenery:"||none, .enabled,"`
+}
+
+func newMapFieldsConfig() greenery.Config {
+	return &mapFieldsConfig{
+		BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+		Labels:     map[string]string{"env": "dev"},
+		Limits:     map[string]int{},
+	}
+}
+
+func TestMapFields(t *testing.T) {
+	tomlCfg := `labels = { team = "core", env = "prod" }
+limits = { cpu = 2, memory = 512 }
+enabled = { metrics = true, tracing = false }
+`
+
+	yamlCfg := `labels:
+  team: core
+  env: prod
+limits:
+  cpu: 2
+  memory: 512
+enabled:
+  metrics: true
+  tracing: false
+`
+
+	fromCfg := map[string]testhelper.Comparer{
+		"Labels":  testhelper.Comparer{Value: map[string]string{"team": "core", "env": "prod"}},
+		"Limits":  testhelper.Comparer{Value: map[string]int{"cpu": 2, "memory": 512}},
+		"Enabled": testhelper.Comparer{Value: map[string]bool{"metrics": true, "tracing": false}},
+	}
+
+	var tcs []testhelper.TestCase
+	for _, format := range []string{"json", "toml", "yaml"} {
+		tcs = append(tcs, testhelper.TestCase{
+			Name: "Init as " + format,
+			CmdLine: []string{
+				"config",
+				"init",
+				"--format",
+				format,
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"CfgFormat": testhelper.Comparer{Value: format, Accessor: "GetTyped"},
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + "." + format,
+					Source: filepath.Join("testdata", cmdTestName+".TestMapFields."+format), Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file generated at .*" + cmdTestName + "." + format + "\n$",
+		}, testhelper.TestCase{
+			Name: "Init as " + format + " loads back the defaults",
+			CmdLine: []string{
+				"version",
+			},
+			CfgFile:          filepath.Join("testdata", cmdTestName+".TestMapFields."+format),
+			CfgFileExtension: "." + format,
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Labels": testhelper.Comparer{Value: map[string]string{"env": "dev"}},
+				"Limits": testhelper.Comparer{Value: map[string]int{}},
+			},
+			OutStdOut: "0.0\n",
+		})
+	}
+
+	tcs = append(tcs,
+		testhelper.TestCase{
+			Name: "Defaults",
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Labels": testhelper.Comparer{Value: map[string]string{"env": "dev"}},
+				"Limits": testhelper.Comparer{Value: map[string]int{}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Repeated flags",
+			CmdLine: []string{
+				"--label",
+				"team=core",
+				"-L",
+				"owner=jane,\"site=a,b\"",
+				"--limit",
+				"cpu=4",
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Labels": testhelper.Comparer{Value: map[string]string{"team": "core", "owner": "jane", "site": "a,b"}},
+				"Limits": testhelper.Comparer{Value: map[string]int{"cpu": 4}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Environment",
+			Env: map[string]string{
+				"CMDS_TEST_LABELS": "team=core, owner=jane",
+				"CMDS_TEST_LIMITS": "cpu=4,memory=1024",
+			},
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Labels": testhelper.Comparer{Value: map[string]string{"team": "core", "owner": "jane"}},
+				"Limits": testhelper.Comparer{Value: map[string]int{"cpu": 4, "memory": 1024}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "Load a TOML file",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: fromCfg,
+			OutStdOut:      "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:             "Load a YAML file",
+			CfgContents:      yamlCfg,
+			CfgFileExtension: ".yaml",
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: fromCfg,
+			OutStdOut:      "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "Flags override the configuration file",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"--label",
+				"team=web",
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Labels":  testhelper.Comparer{Value: map[string]string{"team": "web"}},
+				"Limits":  fromCfg["Limits"],
+				"Enabled": fromCfg["Enabled"],
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "Keys are lowercased from every source",
+			CfgContents: "enabled = { Metrics = true }\n",
+			Env: map[string]string{
+				"CMDS_TEST_LIMITS": "CPU=2",
+			},
+			CmdLine: []string{
+				"--label",
+				"Team=core",
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Labels":  testhelper.Comparer{Value: map[string]string{"team": "core"}},
+				"Limits":  testhelper.Comparer{Value: map[string]int{"cpu": 2}},
+				"Enabled": testhelper.Comparer{Value: map[string]bool{"metrics": true}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Invalid pair",
+			CmdLine: []string{
+				"--label",
+				"team",
+				"version",
+			},
+			ExecErrorRegex:  "team is not a key=value pair",
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "(?s)Usage:",
+		},
+		testhelper.TestCase{
+			Name:        "Invalid value in the configuration file",
+			CfgContents: "limits = { cpu = \"many\" }\n",
+			CmdLine: []string{
+				"version",
+			},
+			ExecErrorRegex: "limits\\.cpu",
+			ExecErrorIs:    greenery.ErrInvalidValue,
+		},
+		testhelper.TestCase{
+			Name:        "Display is sorted",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			ExpectedValues: fromCfg,
+			OutStdOutRegex: "(?s)\nEnabled: metrics=true,tracing=false \\(config file .*\\)\n" +
+				"Labels: env=prod,team=core \\(config file .*\\)\n" +
+				"Limits: cpu=2,memory=512 \\(config file .*\\)\n",
+		},
+		testhelper.TestCase{
+			Name: "Unsupported map type",
+			ConfigGen: func() greenery.Config {
+				return &struct {
+					*greenery.BaseConfig
+					Ratios map[string]float64 `greenery:"|ratio|, .ratios, RATIOS"`
+				}{
+					BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+				}
+			},
+			CmdLine: []string{
+				"version",
+			},
+			ExecErrorRegex: "Ratios",
+		},
+	)
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newMapFieldsConfig,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				ConfigFile: map[string]string{
+					greenery.DocConfigHeader: "Config generated while testing",
+				},
+				CmdLine: map[string]string{
+					"Labels":  "the labels",
+					"Limits":  "the resource limits",
+					"Enabled": "the enabled features",
+				},
+			},
+		}})
+	require.NoError(t, err)
+}
+
+type sliceFieldsConfig struct {
+	*greenery.BaseConfig
+	Hosts   []string        `greenery:"|host|, .hosts, HOSTS"`
+	Ports   []int           `greenery:"|port|, .ports, PORTS, csv"`
+	Weights []float64       `greenery:"|weight|, .weights, WEIGHTS, csv append sep=;"`
+	Retries []time.Duration `greenery:"|retry|, .retries, RETRIES, append"`
+}
+
+func newSliceFieldsConfig() greenery.Config {
+	return &sliceFieldsConfig{
+		BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+		Hosts:      []string{"localhost"},
+		Retries:    []time.Duration{time.Second},
+	}
+}
+
+func TestSliceFields(t *testing.T) {
+	tomlCfg := `hosts = [ "web1", "web2" ]
+ports = [ 8080, 8081 ]
+weights = [ 0.25 ]
+retries = [ "5s", "1m" ]
+`
+
+	fromCfg := map[string]testhelper.Comparer{
+		"Hosts":   testhelper.Comparer{Value: []string{"web1", "web2"}},
+		"Ports":   testhelper.Comparer{Value: []int{8080, 8081}},
+		"Weights": testhelper.Comparer{Value: []float64{0.25}},
+		"Retries": testhelper.Comparer{Value: []time.Duration{5 * time.Second, time.Minute}},
+	}
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Init",
+			CmdLine: []string{
+				"config",
+				"init",
+			},
+			GoldFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: cmdTestName + ".toml",
+					Source: filepath.Join("testdata", cmdTestName+".TestSliceFields.toml"), Perms: 0644,
+					Custom: testhelper.CompareIgnoreTmp},
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "^Configuration file generated at .*" + cmdTestName + ".toml\n$",
+		},
+		testhelper.TestCase{
+			Name: "Init loads back the defaults",
+			CmdLine: []string{
+				"version",
+			},
+			CfgFile:   filepath.Join("testdata", cmdTestName+".TestSliceFields.toml"),
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Repeated flags",
+			CmdLine: []string{
+				"--host",
+				"web1",
+				"--host",
+				"web2,web3",
+				"--port",
+				"80,443",
+				"--port",
+				"8080",
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Hosts": testhelper.Comparer{Value: []string{"web1", "web2,web3"}},
+				"Ports": testhelper.Comparer{Value: []int{80, 443, 8080}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Environment",
+			Env: map[string]string{
+				"CMDS_TEST_HOSTS":   "web1, \"web2,web3\"",
+				"CMDS_TEST_PORTS":   "80,443",
+				"CMDS_TEST_WEIGHTS": "0.5;1.5",
+				"CMDS_TEST_RETRIES": "1s,2m",
+			},
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Hosts":   testhelper.Comparer{Value: []string{"web1", "web2,web3"}},
+				"Ports":   testhelper.Comparer{Value: []int{80, 443}},
+				"Weights": testhelper.Comparer{Value: []float64{0.5, 1.5}},
+				"Retries": testhelper.Comparer{Value: []time.Duration{time.Second, 2 * time.Minute}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "Load a TOML file",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"version",
+			},
+			ExpectedValues: fromCfg,
+			OutStdOut:      "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "Flags replace or append to the configuration file",
+			CfgContents: tomlCfg,
+			CmdLine: []string{
+				"--host",
+				"web3",
+				"--port",
+				"80",
+				"--weight",
+				"0.5,0.75",
+				"--retry",
+				"10m",
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Hosts":   testhelper.Comparer{Value: []string{"web3"}},
+				"Ports":   testhelper.Comparer{Value: []int{80}},
+				"Weights": testhelper.Comparer{Value: []float64{0.25, 0.5, 0.75}},
+				"Retries": testhelper.Comparer{Value: []time.Duration{5 * time.Second, time.Minute, 10 * time.Minute}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name:        "Flags append to the environment",
+			CfgContents: tomlCfg,
+			Env: map[string]string{
+				"CMDS_TEST_WEIGHTS": "1.5",
+			},
+			CmdLine: []string{
+				"--weight",
+				"2.5",
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Hosts":   fromCfg["Hosts"],
+				"Ports":   fromCfg["Ports"],
+				"Weights": testhelper.Comparer{Value: []float64{1.5, 2.5}},
+				"Retries": fromCfg["Retries"],
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Flags append to the default",
+			CmdLine: []string{
+				"--retry",
+				"2s",
+				"version",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"Retries": testhelper.Comparer{Value: []time.Duration{time.Second, 2 * time.Second}},
+			},
+			OutStdOut: "0.0\n",
+		},
+		testhelper.TestCase{
+			Name: "Invalid flag value",
+			CmdLine: []string{
+				"--port",
+				"80,http",
+				"version",
+			},
+			ExecErrorRegex:  "invalid argument \"80,http\" for \"--port\" flag",
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "(?s)Usage:",
+		},
+		testhelper.TestCase{
+			Name: "Invalid environment value",
+			Env: map[string]string{
+				"CMDS_TEST_RETRIES": "1s,soon",
+			},
+			CmdLine: []string{
+				"version",
+			},
+			ExecErrorRegex: "retries.*\\(set in the environment\\)$",
+			ExecErrorIs:    greenery.ErrInvalidValue,
+		},
+		testhelper.TestCase{
+			Name: "Export",
+			Env: map[string]string{
+				"CMDS_TEST_WEIGHTS": "0.5;1.5",
+			},
+			CmdLine: []string{
+				"config",
+				"env",
+				"--export",
+				"bash",
+				"--all",
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex: "(?s)\nexport CMDS_TEST_HOSTS='localhost'\n.*" +
+				"\nexport CMDS_TEST_RETRIES='1s'\n.*" +
+				"\nexport CMDS_TEST_WEIGHTS='0.5;1.5'\n",
+		},
+		testhelper.TestCase{
+			Name: "Options only for slices",
+			ConfigGen: func() greenery.Config {
+				return &struct {
+					*greenery.BaseConfig
+					Host string `greenery:"|host|, .host, HOST, csv"`
+				}{
+					BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+				}
+			},
+			CmdLine: []string{
+				"version",
+			},
+			ExecErrorRegex: "^Invalid tag for Host, option 'csv' is only valid for slices",
+		},
+		testhelper.TestCase{
+			Name: "Invalid separator",
+			ConfigGen: func() greenery.Config {
+				return &struct {
+					*greenery.BaseConfig
+					Hosts []string `greenery:"|host|, .hosts, HOSTS, sep=::"`
+				}{
+					BaseConfig: greenery.NewBaseConfig(cmdTestName, nil),
+				}
+			},
+			CmdLine: []string{
+				"version",
+			},
+			ExecErrorRegex: "^Invalid tag for Hosts, the separator in 'sep=::' must be a single character",
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newSliceFieldsConfig,
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				ConfigFile: map[string]string{
+					greenery.DocConfigHeader: "Config generated while testing",
+				},
+				CmdLine: map[string]string{
+					"Hosts":   "the hosts",
+					"Ports":   "the ports",
+					"Weights": "the weights",
+					"Retries": "the retry delays",
+				},
+			},
+		}})
+	require.NoError(t, err)
+}
+
+func TestConfigDiff(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	otherCfg := filepath.Join(cwd, "other.toml")
+
+	tomlCfg := "[server]\nname = \"web\"\nport = 8080\n"
+	other := "log-file = \"/tmp/other.log\"\n\n[server]\nname = \"api\"\nport = 9090\n"
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Differences from the defaults",
+			CmdLine: []string{
+				"config",
+				"diff",
+			},
+			CfgContents: tomlCfg,
+			Env: map[string]string{
+				"CMDS_TEST_TOKEN": "hunter2",
+			},
+			NoValidateConfigValues: true,
+			ExecErrorOutput:        true,
+			OutStdOutRegex: "^# The log file location\n-log-file = \"\"\n\\+log-file = \"/tmp/tlog[0-9]+.log\"\n" +
+				"# the server name\n-server.name = \"\"\n\\+server.name = \"web\"\n" +
+				"# the server token\n-server.token = \"\\*{8}\"\n\\+server.token = \"\\*{8}\"\n$",
+			OutStdErrRegex: "^Usage:",
+			ExecError:      "The configuration differs from the defaults",
+		},
+		testhelper.TestCase{
+			Name: "Differences from another file",
+			CmdLine: []string{
+				"config",
+				"diff",
+				otherCfg,
+			},
+			CfgContents: tomlCfg,
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: otherCfg, Contents: []byte(other), Perms: 0644},
+			},
+			NoValidateConfigValues: true,
+			ExecErrorOutput:        true,
+			OutStdOutRegex: "^# The log file location\n-log-file = \"/tmp/tlog[0-9]+.log\"\n\\+log-file = \"/tmp/other.log\"\n" +
+				"# the server name\n-server.name = \"web\"\n\\+server.name = \"api\"\n" +
+				"# the server port\n-server.port = 8080\n\\+server.port = 9090\n$",
+			OutStdErrRegex: "^Usage:",
+			ExecError:      "The configuration differs from " + otherCfg,
+		},
+		testhelper.TestCase{
+			Name: "Differences of another file from the defaults",
+			CmdLine: []string{
+				"config",
+				"diff",
+				"--defaults",
+				otherCfg,
+			},
+			CfgContents: tomlCfg,
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: otherCfg, Contents: []byte(other), Perms: 0644},
+			},
+			NoValidateConfigValues: true,
+			ExecErrorOutput:        true,
+			OutStdOutRegex: "^# The log file location\n-log-file = \"\"\n\\+log-file = \"/tmp/other.log\"\n" +
+				"# the server name\n-server.name = \"\"\n\\+server.name = \"api\"\n" +
+				"# the server port\n-server.port = 8080\n\\+server.port = 9090\n$",
+			OutStdErrRegex: "^Usage:",
+			ExecError:      "The configuration file " + otherCfg + " differs from the defaults",
+		},
+		testhelper.TestCase{
+			Name: "Defaults without a file",
+			CmdLine: []string{
+				"config",
+				"diff",
+				"--defaults",
+			},
+			ExecError: "The --defaults parameter requires the configuration file to compare",
+		},
+		testhelper.TestCase{
+			Name: "Missing file",
+			CmdLine: []string{
+				"config",
+				"diff",
+				otherCfg,
+			},
+			ExecError:   "Could not load config file " + otherCfg,
+			ExecErrorIs: greenery.ErrCfgFileNotFound,
+		},
+	}
+
+	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newEditConfig,
+		CompareMap: map[string]testhelper.CompareFunc{
+			"Port": testhelper.CompareGetterToGetter,
+		},
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				CmdLine: map[string]string{
+					"Name":  "the server name",
+					"Port":  "the server port",
+					"Token": "the server token",
+				},
+			},
+		}})
+	require.NoError(t, err)
+}
+
+func TestConfigUpgrade(t *testing.T) {
+	tomlCfg := `# Config generated while testing
+verbosity = 2
+
+[server]
+# the server name
+name = "web"
+host = "localhost"
+`
+
+	yamlCfg := "verbosity: 2\nserver:\n  name: web\n  host: localhost\n"
+
+	upgraded := func(old string, wanted []string) func(*testing.T, greenery.Config) {
+		return func(t *testing.T, cfg greenery.Config) {
+			backup := cfg.GetConfigFile() + ".bak"
+			b, err := afero.ReadFile(cfg.GetFs(), backup)
+			require.NoError(t, err)
+			require.NoError(t, cfg.GetFs().Remove(backup))
+			require.Equal(t, old, string(b))
+
+			b, err = afero.ReadFile(cfg.GetFs(), cfg.GetConfigFile())
+			require.NoError(t, err)
+			for _, w := range wanted {
+				require.Contains(t, string(b), w)
+			}
+			require.NotContains(t, string(b), "\nhost = ")
+		}
+	}
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Upgrade a file",
+			CmdLine: []string{
+				"config",
+				"upgrade",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			OutStdOutRegex: "(?s)^@@ -1,3 \\+1,15 @@\n # Config generated while testing\n\\+\n\\+# The format of the error messages[^\n]+\n\\+error-format = \"text\"\n\\+# The log file location\n.*" +
+				"\n verbosity = 2\n \n@@ -5,3 \\+17,8 @@\n # the server name\n name = \"web\"\n-host = \"localhost\"\n" +
+				"\\+# the server port\n\\+port = 8080\n\\+# the server token\n\\+# token = \"\\*{8}\"\n" +
+				"\\+# This variable is no longer used\n\\+# host = \"localhost\"\n" +
+				"Configuration file /tmp/tcfg[0-9]+.toml upgraded, the previous version was saved as /tmp/tcfg[0-9]+.toml.bak\n$",
+			ValuesValidator: upgraded(tomlCfg, []string{
+				"\n[server]\n",
+				"\n# the server name\nname = \"web\"\n",
+				"\n# the server port\nport = 8080\n",
+				"\n# the server token\n# token = \"********\"\n",
+				"\n# This variable is no longer used\n# host = \"localhost\"\n",
+				"\nverbosity = 2\n",
+			}),
+		},
+		testhelper.TestCase{
+			Name: "Upgrade a YAML file",
+			CmdLine: []string{
+				"config",
+				"upgrade",
+			},
+			CfgContents:            yamlCfg,
+			CfgFileExtension:       ".yaml",
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "\n-  host: localhost\n",
+			ValuesValidator: upgraded(yamlCfg, []string{
+				"\nserver:\n",
+				"\n  # the server name\n  name: \"web\"\n",
+				"\n  # the server port\n  port: 8080\n",
+				"\n  # This variable is no longer used\n  # host: \"localhost\"\n",
+				"\nverbosity: 2\n",
+			}),
+		},
+		testhelper.TestCase{
+			Name: "Upgrade a file keeping a secret",
+			CmdLine: []string{
+				"config",
+				"upgrade",
+				"-v",
+				"0",
+			},
+			CfgContents:            tomlCfg + "token = \"hunter2\"\n",
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "\n\\+# the server token\n token = \"hunter2\"\n",
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				require.NoError(t, cfg.GetFs().Remove(cfg.GetConfigFile()+".bak"))
+				b, err := afero.ReadFile(cfg.GetFs(), cfg.GetConfigFile())
+				require.NoError(t, err)
+				require.Contains(t, string(b), "\n# the server token\ntoken = \"hunter2\"\n")
+			},
+		},
+		testhelper.TestCase{
+			Name: "Upgrade a file in dry run mode",
+			CmdLine: []string{
+				"config",
+				"upgrade",
+				"--dry-run",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "(?s)^@@ -1,3 \\+1,15 @@\n.*\n-host = \"localhost\"\n.*# host = \"localhost\"\n$",
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				_, err := cfg.GetFs().Stat(cfg.GetConfigFile() + ".bak")
+				require.True(t, os.IsNotExist(err))
+				b, err := afero.ReadFile(cfg.GetFs(), cfg.GetConfigFile())
+				require.NoError(t, err)
+				require.Equal(t, tomlCfg, string(b))
+			},
+		},
+		testhelper.TestCase{
+			Name: "Upgrade a file keeping the existing backups",
+			CmdLine: []string{
+				"config",
+				"upgrade",
+			},
+			CfgContents:    tomlCfg,
+			CmdlineCfgName: "/tmp/upgrade.toml",
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Contents: []byte(tomlCfg), Location: "/tmp/upgrade.toml"},
+				testhelper.TestFile{Contents: []byte("first"), Location: "/tmp/upgrade.toml.bak"},
+				testhelper.TestFile{Contents: []byte("second"), Location: "/tmp/upgrade.toml.bak.1"},
+			},
+			NoValidateConfigValues: true,
+			OutStdOutRegex:         "\nConfiguration file /tmp/upgrade.toml upgraded, the previous version was saved as /tmp/upgrade.toml.bak.2\n$",
+			ValuesValidator: func(t *testing.T, cfg greenery.Config) {
+				for name, contents := range map[string]string{
+					"/tmp/upgrade.toml.bak":   "first",
+					"/tmp/upgrade.toml.bak.1": "second",
+					"/tmp/upgrade.toml.bak.2": tomlCfg,
+				} {
+					b, err := afero.ReadFile(cfg.GetFs(), name)
+					require.NoError(t, err)
+					require.Equal(t, contents, string(b))
+				}
+			},
+		},
+		testhelper.TestCase{
+			Name: "Upgrade a file with includes",
+			CmdLine: []string{
+				"config",
+				"upgrade",
+			},
+			CfgContents:            "include = []\n" + tomlCfg,
+			NoValidateConfigValues: true,
+			ExecErrorRegex:         "^Cannot upgrade config file /tmp/tcfg[0-9]+.toml, files with includes or profiles are not supported$",
+		},
+		testhelper.TestCase{
+			Name: "Upgrade without a configuration file",
+			CmdLine: []string{
+				"config",
+				"upgrade",
+			},
+			ExecError: "No configuration file was loaded, create one with config init first",
+		},
+		testhelper.TestCase{
+			Name: "Invalid keys are still rejected by other commands",
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			CfgContents:            tomlCfg,
+			NoValidateConfigValues: true,
+			ExecError:              "Invalid key(s) in the configuration file: server.host",
+			ExecErrorIs:            greenery.ErrUnknownKey,
+		},
+	}
+
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newEditConfig,
+		CompareMap: map[string]testhelper.CompareFunc{
+			"Port": testhelper.CompareGetterToGetter,
 		},
 		UserDocList: map[string]*greenery.DocSet{
 			"": &greenery.DocSet{
+				CmdLine: map[string]string{
+					"Name":  "the server name",
+					"Port":  "the server port",
+					"Token": "the server token",
+				},
 				ConfigFile: map[string]string{
 					greenery.DocConfigHeader: "Config generated while testing",
-					"Minimal":                "minimal",
-					"Special":                "",
 				},
-			}},
-	})
+			},
+		}})
+	require.NoError(t, err)
+}
+
+func TestConfigValidate(t *testing.T) {
+	cwd, err := os.Getwd()
+	require.NoError(t, err)
+	otherCfg := filepath.Join(cwd, "other.yaml")
+	mainCfg := filepath.Join(cwd, "main.toml")
+	incCfg := filepath.Join(cwd, "inc", "extra.toml")
+
+	invalid := `verbosity = 7
+log-level = "loud"
+pretty = "maybe"
+host = "localhost"
+
+[server]
+name = "web"
+port = 70000
+`
+
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Valid file",
+			CmdLine: []string{
+				"config",
+				"validate",
+			},
+			CfgContents:    "[server]\nname = \"web\"\n",
+			OutStdOutRegex: "^Configuration file /tmp/tcfg[0-9]+.toml is valid\n$",
+		},
+		testhelper.TestCase{
+			Name: "Every problem is reported",
+			CmdLine: []string{
+				"config",
+				"validate",
+			},
+			CfgContents:     invalid,
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOutRegex: "^/tmp/tcfg[0-9]+.toml:1:1: Invalid value 7 for variable Verbosity, should be between 0 and 3\n" +
+				"/tmp/tcfg[0-9]+.toml:2:1: Invalid value loud for variable LogLevel, should be one of debug, info, warn, error.\n" +
+				"/tmp/tcfg[0-9]+.toml:3:1: Cannot convert flag value pretty: .*\n" +
+				"/tmp/tcfg[0-9]+.toml:4:1: Invalid key host\n" +
+				"/tmp/tcfg[0-9]+.toml:8:1: Invalid value 70000 for variable Port, should be between 1 and 65535\n$",
+			ExecError: "Found 5 problem(s) in the configuration",
+		},
+		testhelper.TestCase{
+			Name: "Parse error",
+			CmdLine: []string{
+				"config",
+				"validate",
+			},
+			CfgContents:     "[server\nname = \"web\"\n",
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOutRegex:  "^/tmp/tcfg[0-9]+.toml:1:2: unexpected token unclosed table key, was expecting a table key\n$",
+			ExecError:       "Found 1 problem(s) in the configuration",
+		},
+		testhelper.TestCase{
+			Name: "Problems in a profile",
+			CmdLine: []string{
+				"config",
+				"validate",
+			},
+			CfgContents:     "[profile.dev.server]\nport = 0\n",
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOutRegex:  "^/tmp/tcfg[0-9]+.toml:2:1: Invalid value 0 for variable Port, should be between 1 and 65535\n$",
+			ExecError:       "Found 1 problem(s) in the configuration",
+		},
+		testhelper.TestCase{
+			Name: "Unprocessed custom keys",
+			CmdLine: []string{
+				"config",
+				"validate",
+			},
+			CfgContents: "[server]\nextra = 1\nother = 2\n",
+			CustomVars:  []string{"server.extra", "server.other"},
+			CustomParser: func(cfg greenery.Config, vals map[string]interface{}) ([]string, error) {
+				return []string{"server.extra"}, nil
+			},
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOutRegex:  "^/tmp/tcfg[0-9]+.toml:3:1: Unprocessed key server.other, it needs custom processing\n$",
+			ExecError:       "Found 1 problem(s) in the configuration",
+		},
+		testhelper.TestCase{
+			Name: "Another file",
+			CmdLine: []string{
+				"config",
+				"validate",
+				otherCfg,
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: otherCfg, Contents: []byte("server:\n  port: none\n"), Perms: 0644},
+			},
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOut:       otherCfg + ": Variable Port, none, cannot be converted to a number\n",
+			ExecError:       "Found 1 problem(s) in the configuration",
+		},
+		testhelper.TestCase{
+			Name: "Valid included files",
+			CmdLine: []string{
+				"config",
+				"validate",
+				mainCfg,
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: mainCfg, Contents: []byte("include = [\"inc/*.toml\"]\nverbosity = 2\n"), Perms: 0644},
+				testhelper.TestFile{Location: incCfg, Contents: []byte("[server]\nport = 80\n"), Perms: 0644},
+			},
+			OutStdOut: "Configuration file " + mainCfg + ", " + incCfg + " is valid\n",
+		},
+		testhelper.TestCase{
+			Name: "Problems in included files",
+			CmdLine: []string{
+				"config",
+				"validate",
+				mainCfg,
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: mainCfg, Contents: []byte("include = [\"inc/*.toml\"]\nverbosity = 7\n"), Perms: 0644},
+				testhelper.TestFile{Location: incCfg, Contents: []byte("[server]\nport = 0\nhost = \"web\"\n"), Perms: 0644},
+			},
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOut: mainCfg + ":2:1: Invalid value 7 for variable Verbosity, should be between 0 and 3\n" +
+				incCfg + ":2:1: Invalid value 0 for variable Port, should be between 1 and 65535\n" +
+				incCfg + ":3:1: Invalid key server.host, did you mean server.port?\n",
+			ExecError: "Found 3 problem(s) in the configuration",
+		},
+		testhelper.TestCase{
+			Name: "Missing included file",
+			CmdLine: []string{
+				"config",
+				"validate",
+				mainCfg,
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: mainCfg, Contents: []byte("verbosity = 2\ninclude = \"inc/missing.toml\"\n"), Perms: 0644},
+			},
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOut: mainCfg + ":2:1: " + greenery.ErrCfgFileNotFound.Error() + ": Cannot find configuration file " +
+				filepath.Join(cwd, "inc", "missing.toml") + ", included by " + mainCfg + "\n",
+			ExecError: "Found 1 problem(s) in the configuration",
+		},
+		testhelper.TestCase{
+			Name: "Include cycle",
+			CmdLine: []string{
+				"config",
+				"validate",
+				mainCfg,
+			},
+			PrecreateFiles: []testhelper.TestFile{
+				testhelper.TestFile{Location: mainCfg, Contents: []byte("include = \"inc/extra.toml\"\n"), Perms: 0644},
+				testhelper.TestFile{Location: incCfg, Contents: []byte("include = \"../main.toml\"\n"), Perms: 0644},
+			},
+			ExecErrorOutput: true,
+			OutStdErrRegex:  "^Usage:",
+			OutStdOut:       incCfg + ": Configuration file include cycle: " + mainCfg + " -> " + incCfg + " -> " + mainCfg + "\n",
+			ExecError:       "Found 1 problem(s) in the configuration",
+		},
+		testhelper.TestCase{
+			Name: "No file",
+			CmdLine: []string{
+				"config",
+				"validate",
+			},
+			ExecError: "No configuration file was found to validate",
+		},
+	}
+
+	err = testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newEditConfig,
+		CompareMap: map[string]testhelper.CompareFunc{
+			"Port": testhelper.CompareGetterToGetter,
+		},
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				CmdLine: map[string]string{
+					"Name":  "the server name",
+					"Port":  "the server port",
+					"Token": "the server token",
+				},
+			},
+		}})
+	require.NoError(t, err)
+}
+
+func TestSuggestions(t *testing.T) {
+	tcs := []testhelper.TestCase{
+		testhelper.TestCase{
+			Name: "Mistyped configuration variable",
+			CmdLine: []string{
+				"config",
+				"display",
+			},
+			CfgContents: "[server]\nprot = 8080\n",
+			ExecError:   "Invalid key(s) in the configuration file: server.prot, did you mean server.port?",
+		},
+		testhelper.TestCase{
+			Name: "Mistyped flag",
+			CmdLine: []string{
+				"config",
+				"display",
+				"--revael",
+			},
+			ExecError: "unknown flag: --revael, did you mean --reveal?",
+		},
+		testhelper.TestCase{
+			Name: "Mistyped command",
+			CmdLine: []string{
+				"confg",
+			},
+			ExecError: "unknown command \"confg\" for \"cmds_test\", did you mean config?",
+		},
+		testhelper.TestCase{
+			Name: "Mistyped subcommand",
+			CmdLine: []string{
+				"config",
+				"dsplay",
+			},
+			ExecError: "unknown command \"dsplay\" for \"cmds_test config\", did you mean display?",
+		},
+		testhelper.TestCase{
+			Name: "Mistyped subcommand in another language",
+			CmdLine: []string{
+				"config",
+				"dsplay",
+			},
+			Env: map[string]string{
+				"LANG": "it_IT.UTF-8",
+			},
+			ExecError: "unknown command \"dsplay\" for \"cmds_test config\", forse intendevi display?",
+		},
+		testhelper.TestCase{
+			Name: "Nothing close enough shows the help",
+			CmdLine: []string{
+				"config",
+				"something",
+			},
+			OutStdOutRegex: "^Configuration file related commands",
+		},
+		testhelper.TestCase{
+			Name: "Mistyped environment variable",
+			CmdLine: []string{
+				"-l",
+				"info",
+				"config",
+				"get",
+				"server.port",
+			},
+			Env: map[string]string{
+				"CMDS_TEST_PROT": "9090",
+			},
+			ExpectedValues: map[string]testhelper.Comparer{
+				"LogLevel": testhelper.Comparer{Value: "info", Accessor: "String"},
+			},
+			OutStdOut:   "8080\n",
+			OutLogRegex: "Unknown environment variable CMDS_TEST_PROT, did you mean CMDS_TEST_PORT\\?",
+		},
+	}
 
+	err := testhelper.RunTestCases(t, tcs, testhelper.TestRunnerOptions{
+		ConfigGen: newEditConfig,
+		CompareMap: map[string]testhelper.CompareFunc{
+			"Port": testhelper.CompareGetterToGetter,
+		},
+		UserDocList: map[string]*greenery.DocSet{
+			"": &greenery.DocSet{
+				CmdLine: map[string]string{
+					"Name":  "the server name",
+					"Port":  "the server port",
+					"Token": "the server token",
+				},
+			},
+		}})
 	require.NoError(t, err)
 }
@@ -57,6 +57,12 @@ ofay ethay ogrampray ependingday onay ommandcay inelay argumentsay:`,
 	"Onay ariablesvay",
 	"ConfigEnvMsg3", // greenery.DocConfigEnvMsg3
 	"Ethay ollowingfay environmentay ariablesvay areay availableay orfay isthay ogrampray:",
+	"DidYouMean", // greenery.DocDidYouMean
+	"idday ouyay eanmay %s?",
+	"PromptAborted", // greenery.DocPromptAborted
+	"Interactiveay onfigurationcay aborteday",
+	"PromptInvalid", // greenery.DocPromptInvalid
+	"%s. Easeplay ytray againay",
 	"------ DELIMITER:BASE ------", // greenery.DocBaseDelimiter
 
 	"------ DELIMITER:HELP ------", // greenery.DocHelpDelimiter
@@ -109,6 +115,58 @@ Implysay ypetay appnameay elphay [path otay ommandcay] orfay ullfay etailsday.`,
 	`Howsay hetay urrentcay onfigurationcay aluesvay akingtay intoay accountay allay environmentay
 "anday onfigurationcay ilefay values. Command-line agsflay invaliday orfay hetay onfigcay isplayday
 "ommandcay ouldway otnay ebay displayed`,
+	"",
+	"config>profiles", // greenery.DocConfigProfilesCmd
+	"",
+	"Istslay hetay onfigurationcay ofilespray",
+	`Istslay hetay ofilespray availableay inay hetay onfigurationcay ilefay, hetay activeay ofilepray,
+ifay anyay, isay arkedmay ithway anay asteriskay`,
+	"",
+	"config>get", // greenery.DocConfigGetCmd
+	"[ectionsay.eykay]",
+	"Intspray hetay aluevay ofay aay onfigurationcay ariablevay",
+	`Intspray hetay urrentcay aluevay ofay hetay onfigurationcay ariablevay, akingtay intoay accountay
+allay environmentay anday onfigurationcay ilefay aluesvay. Ecretsay aluesvay illway ebay askedmay
+unlessay --reveal isay ecifiedspay`,
+	"",
+	"config>set", // greenery.DocConfigSetCmd,
+	"[ectionsay.eykay] [aluevay]",
+	"Etssay aay onfigurationcay ariablevay inay hetay onfigurationcay ilefay",
+	`Etssay hetay onfigurationcay ariablevay otay hetay aluevay inay hetay onfigurationcay ilefay hattay
+asway oadedlay, afteray alidatingvay itay. Anyay ommentscay inay hetay ilefay areay eservedpray`,
+	"",
+	"config>unset", // greenery.DocConfigUnsetCmd,
+	"[ectionsay.eykay]",
+	"Emovesray aay onfigurationcay ariablevay omfray hetay onfigurationcay ilefay",
+	`Emovesray hetay onfigurationcay ariablevay omfray hetay onfigurationcay ilefay hattay asway
+oadedlay, osay hattay itsay efaultday aluevay illway ebay useday. Anyay ommentscay inay hetay ilefay areay
+eservedpray`,
+	"",
+	"config>diff", // greenery.DocConfigDiffCmd
+	"[otheray-ilefay]",
+	"Owsshay owhay hetay onfigurationcay iffersday omfray hetay efaultsday oray anotheray ilefay",
+	`Owsshay hetay onfigurationcay ariablesvay hosewhay urrentcay aluevay iffersday omfray hetay
+efaultday, oray ifay aay onfigurationcay ilefay isay ecifiedspay, omfray hetay aluevay inay hattay ilefay.
+Ithway --defaults hetay ecifiedspay ilefay isay omparedcay againstay hetay efaultsday insteaday.
+Hetay ommandcay ailsfay ifay anyay ifferencesday areay oundfay`,
+	"",
+	"config>upgrade", // greenery.DocConfigUpgradeCmd
+	"",
+	"Upgradesay hetay onfigurationcay ilefay addingay anyay ewnay ariablesvay",
+	`Egeneratesray hetay onfigurationcay ilefay hattay asway oadedlay, eepingkay hetay aluesvay itay
+etssay, addingay anyay ewnay ariablesvay ithway heirtay efaultday aluevay anday ocumentationday anday
+ommentingcay outay anyay ariablesvay hattay areay onay longeray useday. Hetay angeschay areay ownshay
+eforebay hetay ilefay isay rittenway, anday hetay eviouspray ilefay isay eptkay asay aay ackupbay, amednay
+afteray hetay ilefay ithway aay .bak uffixsay anday aay umbernay ifay hattay ackupbay alreadyay existsay.
+Ithway --dry-run hetay angeschay areay onlyay ownshay`,
+	"",
+	"config>validate", // greenery.DocConfigValidateCmd
+	"[ilefay]",
+	"Alidatesvay hetay onfigurationcay ilefay eportingray everyay oblempray oundfay",
+	`Eckschay hetay onfigurationcay ilefay hattay ouldway ebay oadedlay, oray hetay ecifiedspay oneay,
+eportingray everyay unknownay ariablevay anday invaliday aluevay inay itay ogethertay ithway itsay
+ositionpay inay hetay ilefay henway availableay. Hetay ommandcay ailsfay ifay anyay oblemspray areay
+oundfay`,
 	"",
 	"version", // greenery.DocVersionCmd
 	"",
@@ -122,6 +180,8 @@ Implysay ypetay appnameay elphay [path otay ommandcay] orfay ullfay etailsday.`,
 	"Hetay oglay evellay ofay hetay ogrampray. Alidvay aluesvay areay \"error\", \"warn\", \"info\" anday \"debug\"",
 	"ConfFile", // greenery.DocConfFile
 	"Hetay onfigurationcay ilefay ocationlay",
+	"Profile", // greenery.DocProfile
+	"Hetay onfigurationcay ofilepray otay useay",
 	"LogFile", // greenery.DocLogFile
 	"Hetay oglay ilefay ocationlay",
 	"Pretty", // greenery.DocPretty
@@ -132,12 +192,30 @@ Implysay ypetay appnameay elphay [path otay ommandcay] orfay ullfay etailsday.`,
 	"Fiay etsay hetay environmentay ariablesvay illway otnay ebay onsideredcay",
 	"Verbosity", // greenery.DocVerbosity
 	"Hetay erbosityvay ofay hetay ogrampray, anay integeray etweenbay 0 anday 3 inclusiveay.",
+	"ErrorFormat", // greenery.DocErrorFormat
+	"Hetay ormatfay ofay hetay erroray essagesmay, oneay ofay \"text\" oray \"json\"",
 	"DoTrace", // greenery.DocDoTrace
 	"Enablesay acingtray",
 	"CfgLocation", // greenery.DocCfgLocation
 	"Hereway otay itewray hetay onfigurationcay ilefay, oneay ofay \"cwd\", \"user\" oray \"system\"",
 	"CfgForce", // greenery.DocCfgForce
 	"Fiay specified, anyay existingay onfigurationcay ilesfay illway ebay overwrittenay",
+	"CfgInteractive", // greenery.DocCfgInteractive
+	"Fiay specified, hetay onfigurationcay aluesvay illway ebay askeday orfay onay hetay erminaltay eforebay hetay ilefay isay rittenway",
+	"CfgFormat", // greenery.DocCfgFormat
+	"Hetay ormatfay ofay hetay onfigurationcay ilefay, oneay ofay \"toml\", \"yaml\" oray \"json\"",
+	"CfgOutput", // greenery.DocCfgOutput
+	"Hetay outputay ormatfay, oneay ofay \"text\", \"json\", \"toml\" oray \"yaml\"",
+	"CfgReveal", // greenery.DocCfgReveal
+	"Fiay specified, hetay aluesvay ofay ecretsay onfigurationcay ariablesvay illway ebay isplayedday",
+	"CfgExport", // greenery.DocCfgExport
+	"Utputoay hetay environmentay ariablesvay asay aay criptsay orfay hetay ellshay, oneay ofay \"bash\", \"zsh\", \"fish\" oray \"powershell\"",
+	"CfgAll", // greenery.DocCfgAll
+	"Fiay specified, allay hetay environmentay ariablesvay illway ebay exporteday, otnay onlyay hetay onesay urrentlycay etsay",
+	"CfgDryRun", // greenery.DocCfgDryRun
+	"Fiay specified, hetay angeschay illway ebay ownshay utbay hetay onfigurationcay ilefay illway otnay ebay rittenway",
+	"CfgDefaults", // greenery.DocCfgDefaults
+	"Fiay specified, hetay onfigurationcay ilefay assedpay illway ebay omparedcay againstay hetay efaultsday atherray hantay hetay urrentcay onfigurationcay",
 	"------ DELIMITER:COMMANDLINE ------", // greenery.DocCmdlineDelimiter
 
 	// Config file variable descriptions (where different from the cmdline)
@@ -172,7 +172,8 @@ func TestBadCmdHandlersCmd(t *testing.T) {
 						"Timeout": "the timeout to use for the fetch",
 					},
 				}},
-			ExecError: "Cannot find a Usage entry in the docs for command multiargs>subargs",
+			ExecError:   "Cannot find a Usage entry in the docs for command multiargs>subargs",
+			ExecErrorIs: greenery.ErrMissingDocs,
 		},
 		testhelper.TestCase{
 			Name:                "nil usage for multiargs>subargs",
@@ -0,0 +1,288 @@
+package greenery
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// cfgProblem is a problem found in a configuration file by config validate,
+// line and col are 0 if the position is not known.
+type cfgProblem struct {
+	file string
+	line int
+	col  int
+	msg  string
+}
+
+// String returns the problem in the file:line:col: message format
+func (p cfgProblem) String() string {
+	if p.line == 0 {
+		return fmt.Sprintf("%s: %s", p.file, p.msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", p.file, p.line, p.col, p.msg)
+}
+
+// validatedCfgFiles returns the configuration files that would be loaded,
+// the files they include and their fragments are found while validating.
+func (cfg *BaseConfig) validatedCfgFiles() []string {
+	if cfg.NoCfg {
+		return nil
+	}
+
+	if cfg.s_mergeCfg {
+		return cfg.cfgLayers(cfg.ConfFile)
+	}
+
+	if name := cfg.resolveCfgFile(cfg.ConfFile); name != "" {
+		return []string{name}
+	}
+	return nil
+}
+
+// validateCfgFiles validates the passed configuration file together with the
+// files it includes and, if fragments is set, the ones in its fragment
+// directory, as they would be loaded. It returns the problems found and the
+// files validated, which are added to done so that files included more than
+// once are validated only once. stack contains the files currently being
+// included, and is used to detect cycles.
+func (cfg *BaseConfig) validateCfgFiles(name string, fragments bool, stack []string,
+	done map[string]bool) ([]cfgProblem, []string) {
+	name = filepath.Clean(name)
+	for _, s := range stack {
+		if s == name {
+			return []cfgProblem{{file: stack[len(stack)-1], msg: fmt.Sprintf("Configuration file include cycle: %s",
+				strings.Join(append(stack, name), " -> "))}}, nil
+		}
+	}
+	if done[name] {
+		return nil, nil
+	}
+	done[name] = true
+	stack = append(stack, name)
+
+	cfg.Tracef("Validating the config file %s", name)
+	problems, included := cfg.validateCfgFile(name)
+	files := []string{name}
+	for _, inc := range included {
+		p, f := cfg.validateCfgFiles(inc, false, stack, done)
+		problems = append(problems, p...)
+		files = append(files, f...)
+	}
+
+	if fragments {
+		for _, frag := range cfg.fragments(name) {
+			p, f := cfg.validateCfgFiles(frag, false, stack, done)
+			problems = append(problems, p...)
+			files = append(files, f...)
+		}
+	}
+	return problems, files
+}
+
+// tomlErrorRe matches the position go-toml puts at the start of its errors
+var tomlErrorRe = regexp.MustCompile(`^\((\d+), (\d+)\): (.*)$`)
+
+// tomlPositions records the positions of all the keys in the passed TOML
+// tree, lowercased as viper does.
+func tomlPositions(tree *toml.Tree, prefix string, positions map[string]toml.Position) {
+	for _, k := range tree.Keys() {
+		name := prefix + strings.ToLower(k)
+		positions[name] = tree.GetPositionPath([]string{k})
+		if sub, ok := tree.GetPath([]string{k}).(*toml.Tree); ok {
+			tomlPositions(sub, name+sepKeyParts, positions)
+		}
+	}
+}
+
+// validateCfgFile returns all the problems found in the passed configuration
+// file: parse errors, unknown variables, invalid values and variables that
+// are not processed by the custom parser, together with the files it
+// includes. Profiles are validated as well.
+func (cfg *BaseConfig) validateCfgFile(name string) ([]cfgProblem, []string) {
+	contents, err := afero.ReadFile(cfg.s_fs, name)
+	if err != nil {
+		return []cfgProblem{{file: name, msg: fmt.Sprintf("Cannot read the config file: %v", err)}}, nil
+	}
+
+	positions := map[string]toml.Position{}
+	format := cfgFormat(name)
+	if format == "toml" {
+		tree, terr := toml.LoadBytes(contents)
+		if terr != nil {
+			p := cfgProblem{file: name, msg: terr.Error()}
+			if m := tomlErrorRe.FindStringSubmatch(terr.Error()); m != nil {
+				p.line, _ = strconv.Atoi(m[1])
+				p.col, _ = strconv.Atoi(m[2])
+				p.msg = m[3]
+			}
+			return []cfgProblem{p}, nil
+		}
+		tomlPositions(tree, "", positions)
+	}
+
+	vp := viper.New()
+	vp.SetConfigType(format)
+	if err = vp.ReadConfig(bytes.NewReader(contents)); err != nil {
+		return []cfgProblem{{file: name, msg: fmt.Sprintf("Could not parse the config file: %v", err)}}, nil
+	}
+
+	problems := cfg.validateSettings(vp, name, "", positions)
+	var included []string
+	if inc := vp.Get(cfgIncludeKey); inc != nil {
+		settings := map[string]interface{}{cfgIncludeKey: normalizeSetting(inc)}
+		if included, err = cfg.includes(name, settings); err != nil {
+			p := cfgProblem{file: name, msg: err.Error()}
+			if pos, ok := positions[cfgIncludeKey]; ok && !pos.Invalid() {
+				p.line, p.col = pos.Line, pos.Col
+			}
+			problems = append(problems, p)
+		}
+	}
+	if profiles, ok := vp.Get(cfgProfileKey).(map[string]interface{}); ok {
+		names := make([]string, 0, len(profiles))
+		for k := range profiles {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		for _, p := range names {
+			prefix := cfgProfileKey + sepKeyParts + p
+			if sub := vp.Sub(prefix); sub != nil {
+				problems = append(problems, cfg.validateSettings(sub, name, prefix+sepKeyParts, positions)...)
+			}
+		}
+	} else if vp.Get(cfgProfileKey) != nil {
+		problems = append(problems, cfgProblem{file: name,
+			msg: fmt.Sprintf("Invalid %s table in the configuration file, it should contain only profile tables", cfgProfileKey)})
+	}
+
+	sort.SliceStable(problems, func(l, r int) bool {
+		// Problems without a position go last
+		if problems[l].line == 0 || problems[r].line == 0 {
+			return problems[r].line == 0 && problems[l].line != 0
+		}
+		if problems[l].line != problems[r].line {
+			return problems[l].line < problems[r].line
+		}
+		return problems[l].col < problems[r].col
+	})
+	return problems, included
+}
+
+// validateSettings returns the problems found in the passed settings, which
+// are the ones of the passed file under prefix.
+func (cfg *BaseConfig) validateSettings(vp *viper.Viper, name, prefix string, positions map[string]toml.Position) []cfgProblem {
+	var problems []cfgProblem
+	add := func(key, msg string) {
+		p := cfgProblem{file: name, msg: msg}
+		if pos, ok := positions[prefix+strings.ToLower(key)]; ok && !pos.Invalid() {
+			p.line, p.col = pos.Line, pos.Col
+		}
+		problems = append(problems, p)
+	}
+
+	// Start from the defaults, each variable is set on its own so that all
+	// the invalid values are found.
+	ocfg, _ := copyConfig(cfg.s_defaults)
+	viperKeys := map[string]bool{}
+	helper := func(x reflect.StructField, v reflect.Value) {
+		_, vipername, _, _ := parseTags(x)
+		err := loadHelper(ocfg, vp, viperKeys, x, v)
+
+		// The problems of map instances are reported at their position
+		var cerr *ConfigErrors
+		if isMapCfg(x) && errors.As(err, &cerr) {
+			for _, fe := range cerr.Errors {
+				add(instanceKey(x, fe.Field), fe.Error())
+			}
+			return
+		}
+		if err != nil {
+			add(vipername, err.Error())
+		}
+	}
+
+	t := reflect.TypeOf(ocfg).Elem()
+	v := reflect.ValueOf(ocfg).Elem()
+	fields, _ := structFields(t)
+	for _, x := range fields {
+		if x.Type == basePType {
+			for i2 := 0; i2 < baseType.NumField(); i2++ {
+				helper(baseType.Field(i2), v.FieldByIndex(x.Index).Elem())
+			}
+		} else {
+			helper(x, v)
+		}
+	}
+
+	known := map[string]bool{}
+	for k := range viperKeys {
+		known[strings.ToLower(k)] = true
+	}
+	extraKeys := map[string]bool{}
+	for _, k := range cfg.s_extraWanted {
+		extraKeys[strings.ToLower(k)] = true
+	}
+
+	readKeys := vp.AllKeys()
+	sort.Strings(readKeys)
+	otherKeys := map[string]interface{}{}
+	for _, k := range readKeys {
+		switch {
+		case known[k]:
+		case prefix == "" && (k == cfgIncludeKey || strings.HasPrefix(k, cfgProfileKey+sepKeyParts)):
+			// Included files and profiles are checked separately
+		case extraKeys[k]:
+			otherKeys[k] = vp.Get(k)
+		default:
+			add(k, fmt.Sprintf("Invalid key %s%s", prefix+k, cfg.didYouMean(k, cfgFileKeys(ocfg))))
+		}
+	}
+
+	if len(otherKeys) != 0 {
+		var processed []string
+		if cfg.s_extraParser != nil {
+			var err error
+			if processed, err = cfg.s_extraParser(ocfg, otherKeys); err != nil {
+				add("", err.Error())
+			}
+		}
+
+		done := map[string]bool{}
+		for _, k := range processed {
+			done[strings.ToLower(k)] = true
+		}
+		for _, k := range readKeys {
+			if _, ok := otherKeys[k]; ok && !done[k] {
+				add(k, fmt.Sprintf("Unprocessed key %s, it needs custom processing", prefix+k))
+			}
+		}
+	}
+
+	return problems
+}
+
+// instanceKey returns the configuration file key of the passed field, named
+// as in the errors returned by loadMap, of an instance of the passed map
+// field. Fields that are not instance variables are instances themselves.
+func instanceKey(x reflect.StructField, field string) string {
+	name := strings.SplitN(strings.TrimPrefix(field, x.Name+sepKeyParts), sepKeyParts, 2)[0]
+	for _, ix := range instanceFields(x, name) {
+		if ix.Name == field {
+			_, vipername, _, _ := parseTags(ix)
+			return vipername
+		}
+	}
+	return mapSection(x) + sepKeyParts + name
+}
@@ -0,0 +1,253 @@
+package greenery
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// copyPointers replaces any exported pointer to struct in the passed struct
+// value with a pointer to a copy of the struct, recursively, so that flag
+// values can be set in the copy without affecting the original, also in
+// nested configuration structs. The embedded BaseConfig is copied separately
+// by copyConfig.
+func copyPointers(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		if f.CanSet() && isNestedCfg(v.Type().Field(i)) {
+			copyPointers(f)
+			continue
+		}
+
+		if !f.CanSet() || f.Kind() != reflect.Ptr || f.IsNil() || f.Elem().Kind() != reflect.Struct ||
+			f.Type() == basePType {
+			continue
+		}
+
+		np := reflect.New(f.Elem().Type())
+		np.Elem().Set(f.Elem())
+		copyPointers(np.Elem())
+		f.Set(np)
+	}
+}
+
+// copyConfig returns a copy of the passed configuration, including its
+// embedded BaseConfig and flag values, which can be loaded without affecting
+// the original.
+func copyConfig(cfg Config) (Config, *BaseConfig) {
+	ov := reflect.ValueOf(cfg).Elem()
+	nv := reflect.New(ov.Type())
+	nv.Elem().Set(ov)
+
+	var nbase *BaseConfig
+	for i := 0; i < ov.NumField(); i++ {
+		if ov.Type().Field(i).Type == basePType {
+			b := *ov.Field(i).Interface().(*BaseConfig)
+			copyPointers(reflect.ValueOf(&b).Elem())
+			nbase = &b
+			nv.Elem().Field(i).Set(reflect.ValueOf(nbase))
+			break
+		}
+	}
+
+	if nbase == nil {
+		// cfg is a BaseConfig itself
+		nbase = nv.Interface().(*BaseConfig)
+	}
+	copyPointers(nv.Elem())
+
+	// Loading writes the environment values in the map, so it can't be
+	// shared with the original
+	env := make(map[string]string, len(nbase.s_env))
+	for k, v := range nbase.s_env {
+		env[k] = v
+	}
+	nbase.s_env = env
+
+	return nv.Interface().(Config), nbase
+}
+
+// swapValues sets all the exported fields of dst to the ones in src, flag
+// values and nested configuration structs are set in place so that they are
+// still bound to the command line flags.
+func swapValues(dst, src reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		f := dst.Field(i)
+		if !f.CanSet() || f.Type() == basePType {
+			continue
+		}
+
+		if isNestedCfg(dst.Type().Field(i)) {
+			swapValues(f, src.Field(i))
+		} else if f.Kind() == reflect.Ptr && !f.IsNil() && f.Elem().Kind() == reflect.Struct &&
+			!src.Field(i).IsNil() {
+			f.Elem().Set(src.Field(i).Elem())
+		} else {
+			f.Set(src.Field(i))
+		}
+	}
+}
+
+// swapConfig sets all the exported values of the dst configuration, as well
+// as the internal state related to the loaded configuration files, to the
+// ones in src.
+func swapConfig(dst, src Config, dbase, sbase *BaseConfig) {
+	swapValues(reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem())
+	swapValues(reflect.ValueOf(dbase).Elem(), reflect.ValueOf(sbase).Elem())
+
+	dbase.s_cfgDir = sbase.s_cfgDir
+	dbase.s_cfgSources = sbase.s_cfgSources
+	dbase.s_loaded = sbase.s_loaded
+	dbase.s_profile = sbase.s_profile
+	dbase.s_profiles = sbase.s_profiles
+	dbase.s_usedConf = sbase.s_usedConf
+	dbase.s_usedConfs = sbase.s_usedConfs
+	dbase.s_valueSources = sbase.s_valueSources
+}
+
+// reload loads the configuration again in a copy of the current
+// configuration, and if successful swaps the new values in and calls the
+// registered configuration change callbacks. The values, and the viper
+// instance they were read with, are swapped holding the configuration lock,
+// so that Snapshot and the getters never see a partially reloaded
+// configuration.
+func (cfg *BaseConfig) reload() error {
+	cfg.s_reloadMtx.Lock()
+	defer cfg.s_reloadMtx.Unlock()
+
+	cfg.Trace("Reloading the configuration")
+	cfg.s_cfgMtx.RLock()
+	ncfg, nbase := copyConfig(cfg.s_cl)
+	cfg.s_cfgMtx.RUnlock()
+
+	// The state related to the configuration files is recalculated by load
+	nbase.s_loaded = false
+	nbase.s_profile = ""
+	nbase.s_profiles = nil
+	nbase.s_usedConf = ""
+	nbase.s_usedConfs = nil
+
+	// The files are read in a new viper, so that the current one is left
+	// untouched if the new configuration is not valid
+	vp, err := cfg.s_bindings.newViper(cfg.s_fs)
+	if err != nil {
+		return err
+	}
+	if err = nbase.load(ncfg, cfg.s_appName+".toml", cfg.s_ccmd, vp); err != nil {
+		return err
+	}
+
+	cfg.s_cfgMtx.Lock()
+	old, _ := copyConfig(cfg.s_cl)
+	swapConfig(cfg.s_cl, ncfg, cfg, nbase)
+	cfg.s_v = vp
+	callbacks := append([]func(Config, Config){}, cfg.s_cfgChange...)
+	cfg.s_cfgMtx.Unlock()
+
+	for _, f := range callbacks {
+		f(old, cfg.s_cl)
+	}
+	return nil
+}
+
+// watch starts watching the loaded configuration files for changes, as well
+// as for SIGHUP, reloading the configuration when either happens. Files can
+// be watched only on the OS filesystem.
+func (cfg *BaseConfig) watch(ccmd *cobra.Command) {
+	cfg.s_ccmd = ccmd
+	cfg.s_watchDone = make(chan struct{})
+	cfg.s_watchStopped = make(chan struct{})
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var events chan fsnotify.Event
+	var errs chan error
+	if _, ok := cfg.s_fs.(*afero.OsFs); ok && len(cfg.s_usedConfs) != 0 {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			cfg.Warnf("Cannot watch the configuration files for changes: %s", err)
+		} else {
+			cfg.s_watcher = w
+			events = w.Events
+			errs = w.Errors
+
+			// Watch the directories rather than the files, as editors
+			// typically replace files when saving them.
+			watched := map[string]bool{}
+			for _, f := range cfg.s_usedConfs {
+				d := filepath.Dir(f)
+				if watched[d] {
+					continue
+				}
+				watched[d] = true
+				if err = w.Add(d); err != nil {
+					cfg.Warnf("Cannot watch %s for changes: %s", d, err)
+				}
+			}
+		}
+	}
+
+	done, stopped := cfg.s_watchDone, cfg.s_watchStopped
+	go func() {
+		defer close(stopped)
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-done:
+				return
+			case <-hup:
+				cfg.Trace("SIGHUP received")
+			case ev := <-events:
+				if ev.Op&(fsnotify.Write|fsnotify.Create) == 0 || !cfg.isUsedConf(ev.Name) {
+					continue
+				}
+				cfg.Tracef("Configuration file %s changed", ev.Name)
+			case err := <-errs:
+				cfg.Warnf("Error watching the configuration files: %s", err)
+				continue
+			}
+
+			if err := cfg.reload(); err != nil {
+				cfg.Errorf("Cannot reload the configuration, keeping the current one: %s", err)
+			}
+		}
+	}()
+}
+
+// isUsedConf returns whether the passed file is one of the loaded
+// configuration files.
+func (cfg *BaseConfig) isUsedConf(name string) bool {
+	name = filepath.Clean(name)
+	for _, f := range cfg.s_usedConfs {
+		if filepath.Clean(f) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// stopWatching stops watching the configuration files, if watching, waiting
+// for any reload in progress to complete.
+func (cfg *BaseConfig) stopWatching() {
+	if cfg.s_watchDone == nil {
+		return
+	}
+
+	close(cfg.s_watchDone)
+	<-cfg.s_watchStopped
+	cfg.s_watchDone = nil
+	cfg.s_watchStopped = nil
+	if cfg.s_watcher != nil {
+		if err := cfg.s_watcher.Close(); err != nil {
+			cfg.Errorf("Cannot stop watching the configuration files (%s)", err.Error())
+		}
+		cfg.s_watcher = nil
+	}
+}
@@ -3,7 +3,6 @@ package zapbackend
 import (
 	"fmt"
 	"io"
-	"os"
 	"strings"
 	"time"
 
@@ -170,7 +169,8 @@ func logValueFromString(e string) zapcore.Level {
 	panic(fmt.Sprintf("Invalid log level %s, only error, warn, info and debug are supported", e))
 }
 
-// TraceLogger returns a greenery Logger to be used for tracing
+// TraceLogger returns a greenery Logger to be used for tracing, writing to
+// the output writer of the configuration
 func TraceLogger(cfg greenery.Config) greenery.Logger {
 	return &zapLogger{zl: zap.New(
 		zapcore.NewCore(
@@ -190,7 +190,7 @@ func TraceLogger(cfg greenery.Config) greenery.Logger {
 				EncodeDuration: zapcore.StringDurationEncoder,
 				EncodeCaller:   zapcore.ShortCallerEncoder,
 			}),
-			zap.CombineWriteSyncers(zapcore.AddSync(os.Stdout)),
+			zap.CombineWriteSyncers(zapcore.AddSync(cfg.Out())),
 			zapcore.DebugLevel),
 		zap.AddCallerSkip(2),
 		zap.Development(),